@@ -0,0 +1,271 @@
+package main
+
+import "fmt"
+
+/*
+browserAction is something the user can do from the browser with a key.
+The key bindings can be remapped by name in the config file and the help
+on the about screen is generated from this table.
+*/
+type browserAction struct {
+	name        string
+	keys        []string
+	description string
+	// Which column (0 or 1) and group of lines it's listed in on the help screen
+	helpColumn int
+	helpGroup  int
+	run        func(screen *BrowserScreen) int
+}
+
+// The actions available in the browser, with their default key bindings
+var browserActionTable = []browserAction{
+	{"close", []string{"h", "left"}, "close parent", 0, 0, func(screen *BrowserScreen) int {
+		// If we are _on_ a bucket that's open, close it
+		b, _, e := screen.db.getGenericFromPath(screen.currentPath)
+		if e == nil && b != nil && b.expanded {
+			screen.db.closeBucket(screen.currentPath)
+		} else {
+			if len(screen.currentPath) > 1 {
+				parentBucket, err := screen.db.getBucketFromPath(screen.currentPath[:len(screen.currentPath)-1])
+				if err == nil {
+					screen.db.closeBucket(parentBucket.GetPath())
+					// Figure out how far up we need to move the cursor
+					screen.currentPath = parentBucket.GetPath()
+				}
+			} else {
+				screen.db.closeBucket(screen.currentPath)
+			}
+		}
+		return BrowserScreenIndex
+	}},
+	{"down", []string{"j", "down"}, "down", 0, 0, func(screen *BrowserScreen) int {
+		screen.moveCursorDown()
+		return BrowserScreenIndex
+	}},
+	{"up", []string{"k", "up"}, "up", 0, 0, func(screen *BrowserScreen) int {
+		screen.moveCursorUp()
+		return BrowserScreenIndex
+	}},
+	{"open", []string{"l", "right"}, "open item", 0, 0, func(screen *BrowserScreen) int {
+		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
+		// Select the current item
+		if b != nil {
+			screen.db.toggleOpenBucket(screen.currentPath)
+		} else if p != nil {
+			screen.startEditItem()
+		} else {
+			screen.setMessage("Not sure what to do here...")
+		}
+		return BrowserScreenIndex
+	}},
+	{"toggle", []string{"enter"}, "toggle bucket/edit pair", 0, 0, func(screen *BrowserScreen) int {
+		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
+		if b != nil {
+			screen.db.toggleOpenBucket(screen.currentPath)
+		} else if p != nil {
+			screen.startEditItem()
+		}
+		return BrowserScreenIndex
+	}},
+	{"scroll_right_down", []string{"J"}, "scroll right pane down", 0, 0, func(screen *BrowserScreen) int {
+		screen.moveRightPaneDown()
+		return BrowserScreenIndex
+	}},
+	{"scroll_right_up", []string{"K"}, "scroll right pane up", 0, 0, func(screen *BrowserScreen) int {
+		screen.moveRightPaneUp()
+		return BrowserScreenIndex
+	}},
+	{"scroll_tree_left", []string{"H"}, "scroll tree left", 0, 0, func(screen *BrowserScreen) int {
+		screen.scrollTreeLeft()
+		return BrowserScreenIndex
+	}},
+	{"scroll_tree_right", []string{"L"}, "scroll tree right", 0, 0, func(screen *BrowserScreen) int {
+		screen.scrollTreeRight()
+		return BrowserScreenIndex
+	}},
+	{"goto_top", []string{"g"}, "goto top", 0, 1, func(screen *BrowserScreen) int {
+		// Jump to Beginning
+		screen.recordJump()
+		screen.currentPath = screen.db.getNextVisiblePath(nil, screen.filter)
+		return BrowserScreenIndex
+	}},
+	{"goto_bottom", []string{"G"}, "goto bottom", 0, 1, func(screen *BrowserScreen) int {
+		// Jump to End
+		screen.recordJump()
+		screen.currentPath = screen.db.getPrevVisiblePath(nil, screen.filter)
+		return BrowserScreenIndex
+	}},
+	{"jump_back", []string{"ctrl+o"}, "back to last jump", 0, 1, func(screen *BrowserScreen) int {
+		screen.jumpBack()
+		return BrowserScreenIndex
+	}},
+	{"jump_forward", []string{"ctrl+n"}, "forward a jump", 0, 1, func(screen *BrowserScreen) int {
+		screen.jumpForward()
+		return BrowserScreenIndex
+	}},
+	{"set_mark", []string{"m"}, "set mark, then its name", 0, 1, func(screen *BrowserScreen) int {
+		screen.startSetMark()
+		return BrowserScreenIndex
+	}},
+	{"goto_mark", []string{"'", "`"}, "go to mark, then its name", 0, 1, func(screen *BrowserScreen) int {
+		screen.startGotoMark()
+		return BrowserScreenIndex
+	}},
+	{"jump_down", []string{"ctrl+f"}, "jump down", 0, 2, func(screen *BrowserScreen) int {
+		// Jump forward half a screen
+		_, h := screenDisplay.Size()
+		half := h / 2
+		screen.jumpCursorDown(half)
+		return BrowserScreenIndex
+	}},
+	{"jump_up", []string{"ctrl+b"}, "jump up", 0, 2, func(screen *BrowserScreen) int {
+		_, h := screenDisplay.Size()
+		half := h / 2
+		screen.jumpCursorUp(half)
+		return BrowserScreenIndex
+	}},
+	{"filter", []string{"/"}, "filter keys", 0, 3, func(screen *BrowserScreen) int {
+		screen.startFilter()
+		return BrowserScreenIndex
+	}},
+	{"cycle_sort", []string{"o"}, "change how bucket is sorted", 0, 3, func(screen *BrowserScreen) int {
+		bucket := screen.commandBucketPath()
+		screen.setMessage(fmt.Sprintf("%s sorted by %s", displayPath(bucket), screen.cycleSortMode(bucket)))
+		return BrowserScreenIndex
+	}},
+	{"query", []string{"Q"}, "jq query over bucket", 0, 3, func(screen *BrowserScreen) int {
+		screen.startQuery()
+		return BrowserScreenIndex
+	}},
+	{"sql", []string{"S"}, "sql query", 0, 3, func(screen *BrowserScreen) int {
+		screen.startSQL()
+		return BrowserScreenIndex
+	}},
+	{"next_result", []string{"n"}, "next query result", 0, 3, func(screen *BrowserScreen) int {
+		screen.queryStep(1)
+		return BrowserScreenIndex
+	}},
+	{"prev_result", []string{"N"}, "previous query result", 0, 3, func(screen *BrowserScreen) int {
+		screen.queryStep(-1)
+		return BrowserScreenIndex
+	}},
+	{"command", []string{":"}, "command line", 0, 3, func(screen *BrowserScreen) int {
+		screen.startCommand()
+		return BrowserScreenIndex
+	}},
+	{"refresh", []string{"ctrl+r"}, "reload db", 0, 3, func(screen *BrowserScreen) int {
+		screen.refreshDatabase()
+		return BrowserScreenIndex
+	}},
+	{"shrink_tree", []string{"<"}, "shrink tree pane", 0, 4, func(screen *BrowserScreen) int {
+		screen.moveSplit(-0.05)
+		return BrowserScreenIndex
+	}},
+	{"grow_tree", []string{">"}, "grow tree pane", 0, 4, func(screen *BrowserScreen) int {
+		screen.moveSplit(0.05)
+		return BrowserScreenIndex
+	}},
+	{"toggle_wrap", []string{"w"}, "wrap long lines in right pane", 0, 4, func(screen *BrowserScreen) int {
+		screen.noWrap = !screen.noWrap
+		return BrowserScreenIndex
+	}},
+	{"toggle_detail", []string{"tab"}, "tree/detail view (narrow)", 0, 4, func(screen *BrowserScreen) int {
+		// Narrow terminals only have room for one pane, swap which one it is
+		if screen.narrowLayout == narrowDetail {
+			screen.narrowLayout = narrowTree
+		} else {
+			screen.narrowLayout = narrowDetail
+		}
+		return BrowserScreenIndex
+	}},
+	{"toggle_stacked", []string{"s"}, "stack panes (narrow)", 0, 4, func(screen *BrowserScreen) int {
+		if screen.narrowLayout == narrowStacked {
+			screen.narrowLayout = narrowTree
+		} else {
+			screen.narrowLayout = narrowStacked
+		}
+		return BrowserScreenIndex
+	}},
+
+	{"insert_pair", []string{"p"}, "create pair", 1, 0, func(screen *BrowserScreen) int {
+		// p creates a new pair at the current level
+		screen.startInsertItem(typePair)
+		return BrowserScreenIndex
+	}},
+	{"insert_pair_at_parent", []string{"P"}, "create pair at parent", 1, 0, func(screen *BrowserScreen) int {
+		// P creates a new pair at the parent level
+		screen.startInsertItemAtParent(typePair)
+		return BrowserScreenIndex
+	}},
+	{"insert_bucket", []string{"b"}, "create bucket", 1, 0, func(screen *BrowserScreen) int {
+		// b creates a new bucket at the current level
+		screen.startInsertItem(typeBucket)
+		return BrowserScreenIndex
+	}},
+	{"insert_bucket_at_parent", []string{"B"}, "create bucket at parent", 1, 0, func(screen *BrowserScreen) int {
+		// B creates a new bucket at the parent level
+		screen.startInsertItemAtParent(typeBucket)
+		return BrowserScreenIndex
+	}},
+	{"edit", []string{"e"}, "edit value of pair", 1, 0, func(screen *BrowserScreen) int {
+		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
+		if b != nil {
+			screen.setMessage("Cannot edit a bucket, did you mean to (r)ename?")
+		} else if p != nil {
+			screen.startEditItem()
+		}
+		return BrowserScreenIndex
+	}},
+	{"rename", []string{"r"}, "rename pair/bucket", 1, 0, func(screen *BrowserScreen) int {
+		screen.startRenameItem()
+		return BrowserScreenIndex
+	}},
+	{"delete", []string{"D"}, "delete item", 1, 1, func(screen *BrowserScreen) int {
+		screen.startDeleteItem()
+		return BrowserScreenIndex
+	}},
+	{"export_value", []string{"x"}, "export the raw value", 1, 1, func(screen *BrowserScreen) int {
+		return screen.startExport("raw")
+	}},
+	{"export_json", []string{"X"}, "export (json, csv, yaml, bolt...)", 1, 1, func(screen *BrowserScreen) int {
+		return screen.startExport("json")
+	}},
+	{"import_value", []string{"i"}, "import file to value of pair", 1, 1, func(screen *BrowserScreen) int {
+		// Import value from a file
+		screen.startImportValue()
+		return BrowserScreenIndex
+	}},
+	{"extract", []string{"E"}, "extract item to a new db", 1, 1, func(screen *BrowserScreen) int {
+		// Copy the item, and the buckets it's in, to a new DB file
+		screen.startExtract()
+		return BrowserScreenIndex
+	}},
+	{"backup", []string{"W"}, "backup whole db", 1, 1, func(screen *BrowserScreen) int {
+		// Write a backup of the whole DB to a file
+		screen.startBackup()
+		return BrowserScreenIndex
+	}},
+	{"restore", []string{"R"}, "restore whole db", 1, 1, func(screen *BrowserScreen) int {
+		// Restore the whole DB from a backup file
+		screen.startRestore()
+		return BrowserScreenIndex
+	}},
+	{"inspect", []string{"I"}, "inspect pages of item", 1, 2, func(screen *BrowserScreen) int {
+		return InspectorScreenIndex
+	}},
+	{"table", []string{"T"}, "show bucket as a table", 1, 2, func(screen *BrowserScreen) int {
+		return screen.startTable()
+	}},
+	{"help", []string{"?"}, "this screen", 1, 2, func(screen *BrowserScreen) int {
+		return AboutScreenIndex
+	}},
+	{"quit", []string{"q", "esc", "ctrl+c"}, "quit program", 1, 2, func(screen *BrowserScreen) int {
+		return ExitScreenIndex
+	}},
+}
+
+func init() {
+	if err := buildKeyBindings(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/br0xen/boltbrowser/model"
+	"go.etcd.io/bbolt"
+)
+
+// Set once we've made the automatic backup for the currently open file
+var autoBackupDone bool
+
+/*
+backupDatabase streams a consistent copy of 'bdb' into the file 'fName'
+from inside a read transaction, so it's safe to do while the DB is in use.
+If 'compress' is true the copy is gzipped.
+It returns the number of bytes of DB data written.
+*/
+func backupDatabase(bdb *bbolt.DB, fName string, compress bool) (int64, error) {
+	if sameFile(fName, bdb.Path()) {
+		return 0, errors.New("Can't back up the DB over itself")
+	}
+	f, err := os.OpenFile(fName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	var n int64
+	err = bdb.View(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	if gz != nil {
+		if err = gz.Close(); err != nil {
+			return n, err
+		}
+	}
+	return n, f.Sync()
+}
+
+/*
+restoreDatabase replaces the bolt file 'dbFile' with the backup in 'backupFile'.
+Gzipped backups are detected and decompressed. The backup is copied next to
+'dbFile' and checked before it's renamed into place, so a bad backup never
+clobbers the original. 'dbFile' must not be open when this is called.
+*/
+func restoreDatabase(backupFile, dbFile string) error {
+	src, err := os.Open(backupFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	br := bufio.NewReader(src)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dbFile), filepath.Base(dbFile)+".restore-*")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = checkDatabaseFile(tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return errors.New("backup is not a valid bolt file: " + err.Error())
+	}
+	if err = os.Rename(tmp.Name(), dbFile); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// errReopen is returned when the DB couldn't be opened again after a restore
+type errReopen struct{ err error }
+
+func (e errReopen) Error() string {
+	return "Could not re-open the DB after restoring: " + e.err.Error()
+}
+
+/*
+restoreOpenDatabase restores 'backupFile' over the file we currently have
+open, closing and re-opening the global db around the swap.
+If the file can't be opened again db is nil and the error is an errReopen,
+there's nothing left to browse then.
+*/
+func restoreOpenDatabase(backupFile string) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	fn := db.Path()
+	if err := db.Close(); err != nil {
+		return err
+	}
+	restoreErr := restoreDatabase(backupFile, fn)
+	var err error
+	if db, err = openDB(fn); err != nil {
+		db = nil
+		return errReopen{err}
+	}
+	return restoreErr
+}
+
+// autoBackupFilename builds the name for an automatic backup of 'fn'
+func autoBackupFilename(fn string) string {
+	return fn + "." + time.Now().Format("20060102-150405") + ".bak"
+}
+
+// sameFile is true if 'a' and 'b' are both there and are the same file
+func sameFile(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	return err == nil && os.SameFile(sa, sb)
+}
+
+/*
+checkWritable is called by every function that changes the DB.
+It refuses if we're in read-only mode, and if automatic backups
+were requested it makes one before the first change of the session.
+*/
+func checkWritable() error {
+	if AppArgs.ReadOnly {
+		return model.ErrReadOnly
+	}
+	if AppArgs.AutoBackup && !autoBackupDone {
+		if _, err := backupDatabase(db, autoBackupFilename(db.Path()), false); err != nil {
+			return errors.New("Automatic backup failed: " + err.Error())
+		}
+		autoBackupDone = true
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// useTestDB makes 'bdb' the global db for the length of the test
+func useTestDB(t *testing.T, bdb *bbolt.DB) {
+	t.Helper()
+	oldDB, oldArgs, oldDone := db, AppArgs, autoBackupDone
+	db = bdb
+	t.Cleanup(func() {
+		if db != nil && db != bdb {
+			db.Close()
+		}
+		db, AppArgs, autoBackupDone = oldDB, oldArgs, oldDone
+	})
+}
+
+func TestRestoreOpenDatabase(t *testing.T) {
+	useTestDB(t, openSQLTestDB(t))
+	backup := filepath.Join(t.TempDir(), "backup.db.gz")
+	if _, err := backupDatabase(db, backup, true); err != nil {
+		t.Fatal(err)
+	}
+	before := dumpTestDB(t, db)
+	err := db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket([]byte("users"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restoring is a change like any other, it's backed up first
+	AppArgs.AutoBackup = true
+	autoBackupDone = false
+	if err = restoreOpenDatabase(backup); err != nil {
+		t.Fatal(err)
+	}
+	if after := dumpTestDB(t, db); after != before {
+		t.Errorf("restored DB is:\n%s\nexpected:\n%s", after, before)
+	}
+	if backups, _ := filepath.Glob(db.Path() + ".*.bak"); len(backups) != 1 {
+		t.Errorf("expected an automatic backup, found %q", backups)
+	}
+
+	AppArgs.ReadOnly = true
+	if err = restoreOpenDatabase(backup); err == nil {
+		t.Error("expected an error restoring in read-only mode")
+	}
+}
+
+func TestBackupOverItself(t *testing.T) {
+	bdb := openSQLTestDB(t)
+	before := dumpTestDB(t, bdb)
+	// Through a symlink too
+	link := filepath.Join(t.TempDir(), "link.db")
+	if err := os.Symlink(bdb.Path(), link); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{bdb.Path(), link} {
+		if _, err := backupDatabase(bdb, fn, false); err == nil {
+			t.Errorf("%s: expected an error backing up over the DB", fn)
+		}
+	}
+	if after := dumpTestDB(t, bdb); after != before {
+		t.Errorf("the DB was changed:\n%s", after)
+	}
+}
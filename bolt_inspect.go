@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/br0xen/boltbrowser/model"
+)
+
+/*
+pageChainStep is one page on the way from the root of the DB to an item
+*/
+type pageChainStep struct {
+	bucket   []string // The bucket whose tree this page belongs to
+	page     *boltRawPage
+	inline   bool // Page is stored inline in its parent bucket's leaf
+	elements int
+	used     int
+}
+
+// fill is how full the page is, in percent
+func (s pageChainStep) fill() int {
+	if len(s.page.data) == 0 {
+		return 0
+	}
+	return s.used * 100 / len(s.page.data)
+}
+
+/*
+pageChain finds all of the branch and leaf pages that have to be read
+to get from the root of the DB to the item at 'path'.
+If 'path' is a bucket, its own root page is included at the end.
+*/
+func (pf *boltPageFile) pageChain(path []string) ([]pageChainStep, error) {
+	meta, err := pf.currentMeta()
+	if err != nil {
+		return nil, err
+	}
+	var ret []pageChainStep
+	root := meta.root
+	var inline *boltRawPage
+	for i := range path {
+		var steps []pageChainStep
+		var elem *boltLeafElement
+		steps, elem, err = pf.findLeafElement(path[:i], root, inline, []byte(path[i]))
+		ret = append(ret, steps...)
+		if err != nil {
+			return ret, err
+		}
+		if elem.flags&boltBucketLeafFlag != boltBucketLeafFlag {
+			if i < len(path)-1 {
+				return ret, fmt.Errorf("%s is not a bucket", path[i])
+			}
+			return ret, nil
+		}
+		if root, _, err = bucketHeader(elem.value); err != nil {
+			return ret, err
+		}
+		inline = nil
+		if root == 0 {
+			if inline, err = inlineBucketPage(elem.value); err != nil {
+				return ret, err
+			}
+		}
+	}
+	// 'path' is a bucket, add its root page
+	if inline != nil {
+		return append(ret, newPageChainStep(path, inline, true)), nil
+	}
+	p, err := pf.readPage(root)
+	if err != nil {
+		return ret, err
+	}
+	return append(ret, newPageChainStep(path, p, false)), nil
+}
+
+/*
+findLeafElement walks down the tree starting at page 'root' (or the inline
+page, if there is one) looking for 'key', returning the pages it went through
+*/
+func (pf *boltPageFile) findLeafElement(bucket []string, root uint64, inline *boltRawPage, key []byte) ([]pageChainStep, *boltLeafElement, error) {
+	var ret []pageChainStep
+	p := inline
+	if p == nil {
+		var err error
+		if p, err = pf.readPage(root); err != nil {
+			return ret, nil, err
+		}
+	}
+	for p.flags == boltBranchPageFlag {
+		ret = append(ret, newPageChainStep(bucket, p, false))
+		elems, err := p.branchElements()
+		if err != nil {
+			return ret, nil, err
+		}
+		if len(elems) == 0 {
+			return ret, nil, fmt.Errorf("branch page %d is empty", p.id)
+		}
+		// The child holding 'key' is the last one whose first key is <= 'key'
+		idx := 0
+		for i := range elems {
+			if bytes.Compare(elems[i].key, key) <= 0 {
+				idx = i
+			}
+		}
+		if p, err = pf.readPage(elems[idx].pgid); err != nil {
+			return ret, nil, err
+		}
+	}
+	ret = append(ret, newPageChainStep(bucket, p, inline != nil))
+	elems, err := p.leafElements()
+	if err != nil {
+		return ret, nil, err
+	}
+	for i := range elems {
+		if bytes.Equal(elems[i].key, key) {
+			return ret, &elems[i], nil
+		}
+	}
+	return ret, nil, fmt.Errorf("key %s not found on leaf page %d", model.Stringify(key), p.id)
+}
+
+func newPageChainStep(bucket []string, p *boltRawPage, inline bool) pageChainStep {
+	step := pageChainStep{
+		bucket:   append([]string{}, bucket...),
+		page:     p,
+		inline:   inline,
+		elements: int(p.count),
+		used:     boltPageHeaderLen + int(p.count)*boltElementLen,
+	}
+	if p.flags == boltLeafPageFlag {
+		elems, _ := p.leafElements()
+		for _, e := range elems {
+			step.used += len(e.key) + len(e.value)
+		}
+	} else if p.flags == boltBranchPageFlag {
+		elems, _ := p.branchElements()
+		for _, e := range elems {
+			step.used += len(e.key)
+		}
+	}
+	return step
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"os"
 	"strings"
 
-	"go.etcd.io/bbolt"
+	"github.com/br0xen/boltbrowser/model"
 )
 
 /*
@@ -265,35 +264,64 @@ func (bd *BoltDB) syncOpenBuckets(shadow *BoltDB) {
 	}
 }
 
-func (bd *BoltDB) refreshDatabase() *BoltDB {
+// expandedPaths lists the paths of all of the open buckets, for saving them
+func (bd *BoltDB) expandedPaths() [][]string {
+	var ret [][]string
+	for i := range bd.buckets {
+		ret = append(ret, bd.buckets[i].expandedPaths()...)
+	}
+	return ret
+}
+
+/*
+openPaths opens the buckets at 'paths', like syncOpenBuckets does with
+another BoltDB. Paths that aren't there anymore are skipped.
+*/
+func (bd *BoltDB) openPaths(paths [][]string) {
+	for _, path := range paths {
+		bd.openBucket(path)
+	}
+}
+
+func (bd *BoltDB) refreshDatabase() (*BoltDB, error) {
 	// Reload the database into memBolt
+	tree, err := model.New(db).ReadTree()
 	memBolt = new(BoltDB)
-	db.View(func(tx *bbolt.Tx) error {
-		err := tx.ForEach(func(nm []byte, b *bbolt.Bucket) error {
-			bb, err := readBucket(b)
-			if err == nil {
-				bb.name = string(nm)
-				bb.expanded = false
-				memBolt.buckets = append(memBolt.buckets, *bb)
-				return nil
-			}
-			return err
-		})
-		if err != nil {
-			memBolt = new(BoltDB)
-			// Check if there are key/values directly in the root
-			bb, err := readBucket(tx.Cursor().Bucket())
-			if err == nil {
-				bb.isRoot = true
-				bb.expanded = true
-				memBolt.buckets = append(memBolt.buckets, *bb)
-				return nil
-			}
-			return err
+	for i := range tree.Buckets {
+		bb := newBoltBucket(&tree.Buckets[i])
+		// The root pairs' bucket starts open
+		bb.expanded = bb.isRoot
+		memBolt.buckets = append(memBolt.buckets, *bb)
+	}
+	memBolt.markBrokenPaths(salvagedBrokenPaths)
+	return memBolt, err
+}
+
+/*
+newBoltBucket makes the browser's copy of a bucket from the model's.
+The parents are the copies made here, not the ones in the slices,
+so they don't move when the slices are sorted.
+*/
+func newBoltBucket(mb *model.Bucket) *BoltBucket {
+	bb := &BoltBucket{name: mb.Name, isRoot: mb.IsRoot}
+	for i := range mb.Buckets {
+		child := newBoltBucket(&mb.Buckets[i])
+		child.parent = bb
+		bb.buckets = append(bb.buckets, *child)
+	}
+	for _, p := range mb.Pairs {
+		bb.pairs = append(bb.pairs, BoltPair{parent: bb, key: p.Key, val: p.Value})
+	}
+	return bb
+}
+
+// markBrokenPaths sets the error flag on all of the buckets in 'paths'
+func (bd *BoltDB) markBrokenPaths(paths [][]string) {
+	for _, path := range paths {
+		if b, err := bd.getBucketFromPath(path); err == nil {
+			b.errorFlag = true
 		}
-		return err
-	})
-	return memBolt
+	}
 }
 
 /*
@@ -346,6 +374,17 @@ func (b *BoltBucket) syncOpenBuckets(shadow *BoltBucket) {
 	}
 }
 
+func (b *BoltBucket) expandedPaths() [][]string {
+	var ret [][]string
+	if b.expanded && !b.isRoot {
+		ret = append(ret, b.GetPath())
+	}
+	for i := range b.buckets {
+		ret = append(ret, b.buckets[i].expandedPaths()...)
+	}
+	return ret
+}
+
 func (b *BoltBucket) openAllBuckets() {
 	for i := range b.buckets {
 		b.buckets[i].openAllBuckets()
@@ -378,360 +417,101 @@ func (p *BoltPair) GetPath() []string {
 	return append(p.parent.GetPath(), p.key)
 }
 
-/* This is a go-between function (between the boltbrowser structs
- * above, and the bolt convenience functions below)
- * for taking a boltbrowser bucket and recursively adding it
- * and all of it's children into the database.
- * Mainly used for moving a bucket from one path to another
- * as in the 'renameBucket' function below.
- */
-func addBucketFromBoltBucket(path []string, bb *BoltBucket) error {
-	if err := insertBucket(path, bb.name); err == nil {
-		bucketPath := append(path, bb.name)
-		for i := range bb.pairs {
-			if err = insertPair(bucketPath, bb.pairs[i].key, bb.pairs[i].val); err != nil {
-				return err
-			}
-		}
-		for i := range bb.buckets {
-			if err = addBucketFromBoltBucket(bucketPath, &bb.buckets[i]); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
+/*
+The changes below are made by the model, after checking
+that the DB can be changed (and backing it up if it's the
+first change with -autobackup)
+*/
 
 func deleteKey(path []string) error {
-	if AppArgs.ReadOnly {
-		return errors.New("DB is in Read-Only Mode")
-	}
-	err := db.Update(func(tx *bbolt.Tx) error {
-		// len(b.path)-1 is the key we need to delete,
-		// the rest are buckets leading to that key
-		if len(path) == 1 {
-			// Deleting a root bucket
-			return tx.DeleteBucket([]byte(path[0]))
-		}
-		b := tx.Bucket([]byte(path[0]))
-		if b == nil {
-			// Invalid path, try for the root bucket
-			b = tx.Cursor().Bucket()
-		}
-		if b != nil {
-			if len(path) > 1 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("deleteKey: Invalid Path")
-					}
-				}
-			}
-			// Now delete the last key in the path
-			var err error
-			if deleteBkt := b.Bucket([]byte(path[len(path)-1])); deleteBkt == nil {
-				// Must be a pair
-				err = b.Delete([]byte(path[len(path)-1]))
-			} else {
-				err = b.DeleteBucket([]byte(path[len(path)-1]))
-			}
-			return err
-		}
-		return errors.New("deleteKey: Invalid Path")
-	})
-	return err
-}
-
-func readBucket(b *bbolt.Bucket) (*BoltBucket, error) {
-	bb := new(BoltBucket)
-	if b == nil {
-		return nil, errors.New("No bucket passed")
+	if err := checkWritable(); err != nil {
+		return err
 	}
-	b.ForEach(func(k, v []byte) error {
-		if v == nil {
-			tb, err := readBucket(b.Bucket(k))
-			tb.parent = bb
-			if err == nil {
-				tb.name = string(k)
-				bb.buckets = append(bb.buckets, *tb)
-			}
-		} else {
-			tp := BoltPair{key: string(k), val: string(v)}
-			tp.parent = bb
-			bb.pairs = append(bb.pairs, tp)
-		}
-		return nil
-	})
-	return bb, nil
+	return model.New(db).Delete(path)
 }
 
 func renameBucket(path []string, name string) error {
-	if name == path[len(path)-1] {
-		// No change requested
-		return nil
-	}
-	var bb *BoltBucket // For caching the current bucket
-	err := db.View(func(tx *bbolt.Tx) error {
-		// len(b.path)-1 is the key we need to delete,
-		// the rest are buckets leading to that key
-		b := tx.Bucket([]byte(path[0]))
-		if b == nil {
-			// Invalid path, try for the root bucket
-			b = tx.Cursor().Bucket()
-		}
-		if b != nil {
-			if len(path) > 1 {
-				for i := range path[1:] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("renameBucket: Invalid Path")
-					}
-				}
-			}
-			var err error
-			// Ok, cache b
-			bb, err = readBucket(b)
-			if err != nil {
-				return err
-			}
-		} else {
-			return errors.New("renameBucket: Invalid Bucket")
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	if bb == nil {
-		return errors.New("renameBucket: Couldn't find Bucket")
-	}
-
-	// Ok, we have the bucket cached, now delete the current instance
-	if err = deleteKey(path); err != nil {
-		return err
-	}
-	// Rechristen our cached bucket
-	bb.name = name
-	// And re-add it
-
-	parentPath := path[:len(path)-1]
-	if err = addBucketFromBoltBucket(parentPath, bb); err != nil {
+	if err := checkWritable(); err != nil {
 		return err
 	}
-	return nil
+	return model.New(db).RenameBucket(path, name)
 }
 
 func updatePairKey(path []string, k string) error {
-	if AppArgs.ReadOnly {
-		return errors.New("DB is in Read-Only Mode")
-	}
-	err := db.Update(func(tx *bbolt.Tx) error {
-		// len(b.path)-1 is the key for the pair we're updating,
-		// the rest are buckets leading to that key
-		b := tx.Bucket([]byte(path[0]))
-		if b == nil {
-			// Invalid path, try for the root bucket
-			b = tx.Cursor().Bucket()
-		}
-		if b != nil {
-			if len(path) > 0 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("updatePairValue: Invalid Path")
-					}
-				}
-			}
-			bk := []byte(path[len(path)-1])
-			v := b.Get(bk)
-			err := b.Delete(bk)
-			if err == nil {
-				// Old pair has been deleted, now add the new one
-				err = b.Put([]byte(k), v)
-			}
-			// Now update the last key in the path
-			return err
-		}
-		return errors.New("updatePairValue: Invalid Path")
-	})
-	return err
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return model.New(db).RenamePair(path, k)
 }
 
 func updatePairValue(path []string, v string) error {
-	if AppArgs.ReadOnly {
-		return errors.New("DB is in Read-Only Mode")
-	}
-	err := db.Update(func(tx *bbolt.Tx) error {
-		// len(b.GetPath())-1 is the key for the pair we're updating,
-		// the rest are buckets leading to that key
-		b := tx.Bucket([]byte(path[0]))
-		if b == nil {
-			// Invalid path, try for the root bucket
-			b = tx.Cursor().Bucket()
-		}
-		if b != nil {
-			if len(path) > 0 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("updatePairValue: Invalid Path")
-					}
-				}
-			}
-			// Now update the last key in the path
-			err := b.Put([]byte(path[len(path)-1]), []byte(v))
-			return err
-		}
-		return errors.New("updatePairValue: Invalid Path")
-	})
-	return err
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return model.New(db).SetValue(path, []byte(v))
 }
 
 func insertBucket(path []string, n string) error {
-	if AppArgs.ReadOnly {
-		return errors.New("DB is in Read-Only Mode")
-	}
-	// Inserts a new bucket named 'n' at 'path'
-	err := db.Update(func(tx *bbolt.Tx) error {
-		if len(path) == 0 || path[0] == "" {
-			// insert at root
-			_, err := tx.CreateBucket([]byte(n))
-			if err != nil {
-				return fmt.Errorf("insertBucket: %s", err)
-			}
-		} else {
-			rootBucket, path := path[0], path[1:]
-			b := tx.Bucket([]byte(rootBucket))
-			if b != nil {
-				for len(path) > 0 {
-					tstBucket := ""
-					tstBucket, path = path[0], path[1:]
-					nB := b.Bucket([]byte(tstBucket))
-					if nB == nil {
-						// Not a bucket, if we're out of path, just move on
-						if len(path) != 0 {
-							// Out of path, error
-							return errors.New("insertBucket: Invalid Path 1")
-						}
-					} else {
-						b = nB
-					}
-				}
-				_, err := b.CreateBucket([]byte(n))
-				return err
-			}
-			return fmt.Errorf("insertBucket: Invalid Path %s", rootBucket)
-		}
-		return nil
-	})
-	return err
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return model.New(db).InsertBucket(path, n)
 }
 
 func insertPair(path []string, k string, v string) error {
-	if AppArgs.ReadOnly {
-		return errors.New("DB is in Read-Only Mode")
-	}
-	// Insert a new pair k => v at path
-	err := db.Update(func(tx *bbolt.Tx) error {
-		if len(path) == 0 {
-			// We cannot insert a pair at root
-			return errors.New("insertPair: Cannot insert pair at root")
-		}
-		var err error
-		b := tx.Bucket([]byte(path[0]))
-		if b == nil {
-			// Invalid path, try for the root bucket
-			b = tx.Cursor().Bucket()
-		}
-		if b != nil {
-			if len(path) > 0 {
-				for i := 1; i < len(path); i++ {
-					b = b.Bucket([]byte(path[i]))
-					if b == nil {
-						return fmt.Errorf("insertPair: %s", err)
-					}
-				}
-			}
-			err := b.Put([]byte(k), []byte(v))
-			if err != nil {
-				return fmt.Errorf("insertPair: %s", err)
-			}
-		}
-		return nil
-	})
-	return err
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return model.New(db).InsertPair(path, k, v)
 }
 
 func exportValue(path []string, fName string) error {
-	return db.View(func(tx *bbolt.Tx) error {
-		// len(b.path)-1 is the key whose value we want to export
-		// the rest are buckets leading to that key
-		b := tx.Bucket([]byte(path[0]))
-		if b == nil {
-			// Invalid path, try for the root bucket
-			b = tx.Cursor().Bucket()
-		}
-		if b != nil {
-			if len(path) > 1 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("exportValue: Invalid Path: " + strings.Join(path, "/"))
-					}
-				}
-			}
-			bk := []byte(path[len(path)-1])
-			v := b.Get(bk)
-			return writeToFile(fName, string(v), os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
-		}
-		return errors.New("exportValue: Invalid Bucket")
-	})
+	v, err := model.New(db).Value(path)
+	if err != nil {
+		return err
+	}
+	return writeToFile(fName, string(v), os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
 }
 
 func exportJSON(path []string, fName string) error {
-	return db.View(func(tx *bbolt.Tx) error {
-		// len(b.path)-1 is the key whose value we want to export
-		// the rest are buckets leading to that key
-		b := tx.Bucket([]byte(path[0]))
-		if b == nil {
-			// Invalid path, try for the root bucket
-			b = tx.Cursor().Bucket()
-		}
-		if b != nil {
-			if len(path) > 1 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("exportValue: Invalid Path: " + strings.Join(path, "/"))
-					}
-				}
-			}
-			bk := []byte(path[len(path)-1])
-			if v := b.Get(bk); v != nil {
-				return writeToFile(fName, "{\""+string(bk)+"\":\""+string(v)+"\"}", os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
-			}
-			if b.Bucket(bk) != nil {
-				return writeToFile(fName, genJSONString(b.Bucket(bk)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
-			}
-			return writeToFile(fName, genJSONString(b), os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
-		}
-		return errors.New("exportValue: Invalid Bucket")
-	})
+	out, err := model.New(db).JSON(path)
+	if err != nil {
+		return err
+	}
+	return writeToFile(fName, out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
 }
 
-func genJSONString(b *bbolt.Bucket) string {
-	ret := "{"
-	b.ForEach(func(k, v []byte) error {
-		ret = fmt.Sprintf("%s\"%s\":", ret, string(k))
-		if v == nil {
-			ret = fmt.Sprintf("%s%s,", ret, genJSONString(b.Bucket(k)))
-		} else {
-			ret = fmt.Sprintf("%s\"%s\",", ret, string(v))
-		}
-		return nil
-	})
-	ret = fmt.Sprintf("%s}", ret[:len(ret)-1])
-	return ret
+// exportNDJSON streams the pairs at 'path' to 'fName', a json object on each line
+func exportNDJSON(path []string, fName string) (int, error) {
+	f, err := os.OpenFile(fName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, err := model.New(db).ExportNDJSON(f, path)
+	if err != nil {
+		return n, err
+	}
+	return n, f.Sync()
+}
+
+// exportCSV writes the pairs in the bucket at 'path' to 'fName' as CSV
+func exportCSV(path []string, fName string) (int, error) {
+	f, err := os.OpenFile(fName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, err := model.New(db).ExportCSV(f, path, model.CSVOptions{})
+	if err != nil {
+		return n, err
+	}
+	return n, f.Sync()
+}
+
+// extractToFile copies 'paths', with the buckets they're in, to the new DB file 'fName'
+func extractToFile(paths [][]string, fName string) (model.ExtractReport, error) {
+	return model.New(db).Extract(fName, paths)
 }
 
 func logToFile(s string) error {
@@ -758,34 +538,23 @@ func writeToFile(fn, s string, mode int) error {
 }
 
 func importValue(path []string, fName string) error {
-	if AppArgs.ReadOnly {
-		return errors.New("DB is in Read-Only Mode")
-	}
-	return db.Update(func(tx *bbolt.Tx) error {
-		// len(b.GetPath())-1 is the key for the pair we're updating,
-		// the rest are buckets leading to that key
-		b := tx.Bucket([]byte(path[0]))
-		if b == nil {
-			// Invalid path, try for the root bucket
-			b = tx.Cursor().Bucket()
-		}
-		if b != nil {
-			if len(path) > 0 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("updatePairValue: Invalid Path")
-					}
-				}
-			}
-			// Now update the last key in the path
-			bk := []byte(path[len(path)-1])
-			v, err := os.ReadFile(fName)
-			if err != nil {
-				return err
-			}
-			return b.Put(bk, v)
-		}
-		return errors.New("importValue: Invalid Bucket")
-	})
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	v, err := os.ReadFile(fName)
+	if err != nil {
+		return err
+	}
+	return model.New(db).SetValue(path, v)
+}
+
+func getBucketSequence(path []string) (uint64, error) {
+	return model.New(db).Sequence(path)
+}
+
+func setBucketSequence(path []string, seq uint64) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return model.New(db).SetSequence(path, seq)
 }
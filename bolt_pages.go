@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+)
+
+/*
+This file reads the bolt file format directly, without going through bbolt.
+It's used when bbolt itself can't (or shouldn't) be trusted with the file,
+like salvaging a damaged DB or looking at the physical layout of pages.
+Bolt writes everything in native byte order, we assume little endian.
+*/
+
+const (
+	boltMagic         = 0xED0CDAED
+	boltVersion       = 2
+	boltPageHeaderLen = 16
+	boltElementLen    = 16
+	boltBucketHdrLen  = 16
+	boltMetaLen       = 64
+
+	boltBranchPageFlag   = 0x01
+	boltLeafPageFlag     = 0x02
+	boltMetaPageFlag     = 0x04
+	boltFreelistPageFlag = 0x10
+
+	boltBucketLeafFlag = 0x01
+)
+
+var boltByteOrder = binary.LittleEndian
+
+/*
+boltPageFile is an open bolt file we read page by page
+*/
+type boltPageFile struct {
+	f        *os.File
+	size     int64
+	pageSize int
+}
+
+/*
+boltRawPage is a page read from the file, including any overflow pages
+*/
+type boltRawPage struct {
+	id       uint64
+	flags    uint16
+	count    uint16
+	overflow uint32
+	data     []byte
+}
+
+/*
+boltMeta is the decoded contents of one of the two meta pages
+*/
+type boltMeta struct {
+	pageID   uint64
+	magic    uint32
+	version  uint32
+	pageSize uint32
+	flags    uint32
+	root     uint64
+	sequence uint64
+	freelist uint64
+	pgid     uint64
+	txid     uint64
+	checksum uint64
+	err      error
+}
+
+/*
+boltLeafElement is a key/value (or key/bucket) on a leaf page
+*/
+type boltLeafElement struct {
+	flags uint32
+	key   []byte
+	value []byte
+}
+
+/*
+boltBranchElement is a key pointing to a child page on a branch page
+*/
+type boltBranchElement struct {
+	key  []byte
+	pgid uint64
+}
+
+func openBoltPageFile(fn string) (*boltPageFile, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	pf := &boltPageFile{f: f, size: st.Size()}
+	if pf.pageSize, err = pf.findPageSize(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return pf, nil
+}
+
+func (pf *boltPageFile) Close() error {
+	return pf.f.Close()
+}
+
+// findPageSize gets the page size from the first meta page or, if that one is
+// damaged, by looking for the second meta page at the common page sizes
+func (pf *boltPageFile) findPageSize() (int, error) {
+	buf := make([]byte, boltPageHeaderLen+boltMetaLen)
+	if _, err := pf.f.ReadAt(buf, 0); err != nil {
+		return 0, fmt.Errorf("can't read first meta page: %w", err)
+	}
+	if m := decodeBoltMeta(0, buf[boltPageHeaderLen:]); m.err == nil {
+		return int(m.pageSize), nil
+	}
+	for _, sz := range []int{4096, 8192, 16384, 32768, 65536, 1024, 2048} {
+		if _, err := pf.f.ReadAt(buf, int64(sz)); err != nil {
+			continue
+		}
+		if m := decodeBoltMeta(1, buf[boltPageHeaderLen:]); m.err == nil && int(m.pageSize) == sz {
+			return sz, nil
+		}
+	}
+	return 0, errors.New("no valid meta page found, this doesn't look like a bolt file")
+}
+
+// pageCount is the number of pages that physically fit in the file
+func (pf *boltPageFile) pageCount() uint64 {
+	return uint64(pf.size / int64(pf.pageSize))
+}
+
+/*
+readPage reads page 'id' and its overflow pages, checking that the header
+makes sense before anything tries to use it
+*/
+func (pf *boltPageFile) readPage(id uint64) (*boltRawPage, error) {
+	if id >= pf.pageCount() {
+		return nil, fmt.Errorf("page %d is past the end of the file", id)
+	}
+	hdr := make([]byte, boltPageHeaderLen)
+	if _, err := pf.f.ReadAt(hdr, int64(id)*int64(pf.pageSize)); err != nil {
+		return nil, fmt.Errorf("page %d: %w", id, err)
+	}
+	p := &boltRawPage{
+		id:       boltByteOrder.Uint64(hdr[0:]),
+		flags:    boltByteOrder.Uint16(hdr[8:]),
+		count:    boltByteOrder.Uint16(hdr[10:]),
+		overflow: boltByteOrder.Uint32(hdr[12:]),
+	}
+	if p.id != id {
+		return nil, fmt.Errorf("page %d identifies itself as page %d", id, p.id)
+	}
+	switch p.flags {
+	case boltBranchPageFlag, boltLeafPageFlag, boltMetaPageFlag, boltFreelistPageFlag:
+	default:
+		return nil, fmt.Errorf("page %d has unknown type flags %02x", id, p.flags)
+	}
+	if id+uint64(p.overflow) >= pf.pageCount() {
+		return nil, fmt.Errorf("page %d overflows past the end of the file", id)
+	}
+	p.data = make([]byte, (int(p.overflow)+1)*pf.pageSize)
+	if _, err := pf.f.ReadAt(p.data, int64(id)*int64(pf.pageSize)); err != nil {
+		return nil, fmt.Errorf("page %d: %w", id, err)
+	}
+	return p, nil
+}
+
+// metas reads both meta pages
+func (pf *boltPageFile) metas() [2]boltMeta {
+	var ret [2]boltMeta
+	for i := range ret {
+		p, err := pf.readPage(uint64(i))
+		if err != nil {
+			ret[i] = boltMeta{pageID: uint64(i), err: err}
+			continue
+		}
+		if p.flags != boltMetaPageFlag {
+			ret[i] = boltMeta{pageID: uint64(i), err: fmt.Errorf("page %d is not a meta page", i)}
+			continue
+		}
+		ret[i] = decodeBoltMeta(uint64(i), p.data[boltPageHeaderLen:])
+	}
+	return ret
+}
+
+// currentMeta is the valid meta page with the highest transaction id,
+// which is the one bolt would use
+func (pf *boltPageFile) currentMeta() (boltMeta, error) {
+	m := pf.metas()
+	if m[0].err != nil && m[1].err != nil {
+		return boltMeta{}, fmt.Errorf("both meta pages are invalid: %s; %s", m[0].err, m[1].err)
+	}
+	if m[0].err != nil || (m[1].err == nil && m[1].txid > m[0].txid) {
+		return m[1], nil
+	}
+	return m[0], nil
+}
+
+func decodeBoltMeta(id uint64, b []byte) boltMeta {
+	m := boltMeta{
+		pageID:   id,
+		magic:    boltByteOrder.Uint32(b[0:]),
+		version:  boltByteOrder.Uint32(b[4:]),
+		pageSize: boltByteOrder.Uint32(b[8:]),
+		flags:    boltByteOrder.Uint32(b[12:]),
+		root:     boltByteOrder.Uint64(b[16:]),
+		sequence: boltByteOrder.Uint64(b[24:]),
+		freelist: boltByteOrder.Uint64(b[32:]),
+		pgid:     boltByteOrder.Uint64(b[40:]),
+		txid:     boltByteOrder.Uint64(b[48:]),
+		checksum: boltByteOrder.Uint64(b[56:]),
+	}
+	h := fnv.New64a()
+	h.Write(b[:56])
+	if m.magic != boltMagic {
+		m.err = errors.New("invalid magic number")
+	} else if m.version != boltVersion {
+		m.err = fmt.Errorf("unsupported version %d", m.version)
+	} else if m.checksum != h.Sum64() {
+		m.err = errors.New("checksum mismatch")
+	}
+	return m
+}
+
+func (p *boltRawPage) typeString() string {
+	switch p.flags {
+	case boltBranchPageFlag:
+		return "branch"
+	case boltLeafPageFlag:
+		return "leaf"
+	case boltMetaPageFlag:
+		return "meta"
+	case boltFreelistPageFlag:
+		return "freelist"
+	}
+	return fmt.Sprintf("unknown<%02x>", p.flags)
+}
+
+// leafElements decodes the elements of a leaf page, bounds checking everything
+func (p *boltRawPage) leafElements() ([]boltLeafElement, error) {
+	if p.flags != boltLeafPageFlag {
+		return nil, fmt.Errorf("page %d is a %s page, expected leaf", p.id, p.typeString())
+	}
+	var ret []boltLeafElement
+	for i := 0; i < int(p.count); i++ {
+		off := boltPageHeaderLen + i*boltElementLen
+		if off+boltElementLen > len(p.data) {
+			return ret, fmt.Errorf("page %d: element %d is out of bounds", p.id, i)
+		}
+		e := p.data[off:]
+		pos := int(boltByteOrder.Uint32(e[4:]))
+		ksize := int(boltByteOrder.Uint32(e[8:]))
+		vsize := int(boltByteOrder.Uint32(e[12:]))
+		start := off + pos
+		if pos < 0 || ksize < 0 || vsize < 0 || start+ksize+vsize > len(p.data) {
+			return ret, fmt.Errorf("page %d: element %d is out of bounds", p.id, i)
+		}
+		ret = append(ret, boltLeafElement{
+			flags: boltByteOrder.Uint32(e[0:]),
+			key:   p.data[start : start+ksize],
+			value: p.data[start+ksize : start+ksize+vsize],
+		})
+	}
+	return ret, nil
+}
+
+// branchElements decodes the elements of a branch page, bounds checking everything
+func (p *boltRawPage) branchElements() ([]boltBranchElement, error) {
+	if p.flags != boltBranchPageFlag {
+		return nil, fmt.Errorf("page %d is a %s page, expected branch", p.id, p.typeString())
+	}
+	var ret []boltBranchElement
+	for i := 0; i < int(p.count); i++ {
+		off := boltPageHeaderLen + i*boltElementLen
+		if off+boltElementLen > len(p.data) {
+			return ret, fmt.Errorf("page %d: element %d is out of bounds", p.id, i)
+		}
+		e := p.data[off:]
+		pos := int(boltByteOrder.Uint32(e[0:]))
+		ksize := int(boltByteOrder.Uint32(e[4:]))
+		start := off + pos
+		if start+ksize > len(p.data) {
+			return ret, fmt.Errorf("page %d: element %d is out of bounds", p.id, i)
+		}
+		ret = append(ret, boltBranchElement{
+			key:  p.data[start : start+ksize],
+			pgid: boltByteOrder.Uint64(e[8:]),
+		})
+	}
+	return ret, nil
+}
+
+// freelistIDs decodes the page ids stored on a freelist page
+func (p *boltRawPage) freelistIDs() ([]uint64, error) {
+	if p.flags != boltFreelistPageFlag {
+		return nil, fmt.Errorf("page %d is a %s page, expected freelist", p.id, p.typeString())
+	}
+	idx, count := 0, uint64(p.count)
+	if count == 0xFFFF {
+		// The real count is in the first slot
+		if len(p.data) < boltPageHeaderLen+8 {
+			return nil, fmt.Errorf("page %d: freelist is out of bounds", p.id)
+		}
+		idx = 1
+		count = boltByteOrder.Uint64(p.data[boltPageHeaderLen:])
+	}
+	// The count comes from the file, so it's compared before it's multiplied by anything
+	if len(p.data) < boltPageHeaderLen || count > uint64(len(p.data)-boltPageHeaderLen)/8-uint64(idx) {
+		return nil, fmt.Errorf("page %d: freelist is out of bounds", p.id)
+	}
+	ret := make([]uint64, count)
+	for i := range ret {
+		ret[i] = boltByteOrder.Uint64(p.data[boltPageHeaderLen+(idx+i)*8:])
+	}
+	return ret, nil
+}
+
+/*
+inlineBucketPage returns the page stored inside the value of a bucket
+whose root is 0 (small buckets are stored inline in their parent)
+*/
+func inlineBucketPage(value []byte) (*boltRawPage, error) {
+	if len(value) < boltBucketHdrLen+boltPageHeaderLen {
+		return nil, errors.New("inline bucket is truncated")
+	}
+	data := value[boltBucketHdrLen:]
+	return &boltRawPage{
+		flags:    boltByteOrder.Uint16(data[8:]),
+		count:    boltByteOrder.Uint16(data[10:]),
+		overflow: boltByteOrder.Uint32(data[12:]),
+		data:     data,
+	}, nil
+}
+
+// bucketHeader decodes the root page id and sequence from a bucket value
+func bucketHeader(value []byte) (root, sequence uint64, err error) {
+	if len(value) < boltBucketHdrLen {
+		return 0, 0, errors.New("bucket header is truncated")
+	}
+	return boltByteOrder.Uint64(value[0:]), boltByteOrder.Uint64(value[8:]), nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// testPage is a page of 'size' bytes with a header, the rest is up to the test
+func testPage(flags, count uint16, size int) *boltRawPage {
+	p := &boltRawPage{id: 3, flags: flags, count: count, data: make([]byte, size)}
+	boltByteOrder.PutUint64(p.data[0:], p.id)
+	boltByteOrder.PutUint16(p.data[8:], flags)
+	boltByteOrder.PutUint16(p.data[10:], count)
+	return p
+}
+
+func TestFreelistIDs(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		count uint16
+		// Written after the header
+		slots []uint64
+		size  int
+		want  []uint64
+	}{
+		{"ids", 2, []uint64{7, 9}, 32, []uint64{7, 9}},
+		{"too many", 3, []uint64{7, 9}, 32, nil},
+		{"long count", 0xFFFF, []uint64{2, 7, 9}, 40, []uint64{7, 9}},
+		{"long count too big", 0xFFFF, []uint64{3, 7, 9}, 40, nil},
+		// (1+2^61)*8 wraps around to 8
+		{"long count wraps", 0xFFFF, []uint64{1 << 61, 7}, 40, nil},
+		{"long count max", 0xFFFF, []uint64{math.MaxUint64}, 40, nil},
+		{"long count truncated", 0xFFFF, nil, boltPageHeaderLen + 4, nil},
+		{"no header", 0, nil, 8, nil},
+	} {
+		p := testPage(boltFreelistPageFlag, tc.count, 64)
+		for i, id := range tc.slots {
+			boltByteOrder.PutUint64(p.data[boltPageHeaderLen+i*8:], id)
+		}
+		p.data = p.data[:tc.size]
+		ids, err := p.freelistIDs()
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tc.name, ids)
+			}
+		} else if err != nil || len(ids) != len(tc.want) || ids[0] != tc.want[0] || ids[1] != tc.want[1] {
+			t.Errorf("%s: got %v, %v, expected %v", tc.name, ids, err, tc.want)
+		}
+	}
+	if _, err := testPage(boltLeafPageFlag, 0, 64).freelistIDs(); err == nil {
+		t.Error("expected an error for a leaf page")
+	}
+}
+
+// leafPage is a leaf page with one element for each key, and room for 'extra' more
+func leafPage(keys []string, extra uint16) *boltRawPage {
+	p := testPage(boltLeafPageFlag, uint16(len(keys))+extra, 256)
+	data := boltPageHeaderLen + len(keys)*boltElementLen
+	for i, k := range keys {
+		e := p.data[boltPageHeaderLen+i*boltElementLen:]
+		boltByteOrder.PutUint32(e[4:], uint32(data-(boltPageHeaderLen+i*boltElementLen)))
+		boltByteOrder.PutUint32(e[8:], uint32(len(k)))
+		boltByteOrder.PutUint32(e[12:], 1)
+		data += copy(p.data[data:], k+"v")
+	}
+	p.data = p.data[:data]
+	return p
+}
+
+func TestLeafElements(t *testing.T) {
+	elems, err := leafPage([]string{"a", "bc"}, 0).leafElements()
+	if err != nil || len(elems) != 2 || string(elems[1].key) != "bc" || string(elems[1].value) != "v" {
+		t.Fatalf("got %v, %v", elems, err)
+	}
+
+	// More elements than fit on the page, the ones before it are kept
+	p := leafPage([]string{"a"}, 0xFF)
+	if elems, err = p.leafElements(); err == nil || len(elems) != 1 {
+		t.Errorf("too many elements: got %d, %v", len(elems), err)
+	}
+	for _, field := range []int{4, 8, 12} {
+		p := leafPage([]string{"a"}, 0)
+		boltByteOrder.PutUint32(p.data[boltPageHeaderLen+field:], math.MaxUint32)
+		if _, err := p.leafElements(); err == nil {
+			t.Errorf("expected an error with field %d out of bounds", field)
+		}
+	}
+	p = leafPage([]string{"a"}, 0)
+	p.data = p.data[:boltPageHeaderLen+8]
+	if _, err := p.leafElements(); err == nil {
+		t.Error("expected an error for a truncated element")
+	}
+}
+
+func TestBranchElements(t *testing.T) {
+	p := testPage(boltBranchPageFlag, 1, 64)
+	e := p.data[boltPageHeaderLen:]
+	boltByteOrder.PutUint32(e[0:], boltElementLen)
+	boltByteOrder.PutUint32(e[4:], 2)
+	boltByteOrder.PutUint64(e[8:], 42)
+	copy(p.data[boltPageHeaderLen+boltElementLen:], "ab")
+	elems, err := p.branchElements()
+	if err != nil || len(elems) != 1 || string(elems[0].key) != "ab" || elems[0].pgid != 42 {
+		t.Fatalf("got %v, %v", elems, err)
+	}
+	boltByteOrder.PutUint32(e[4:], math.MaxUint32)
+	if _, err = p.branchElements(); err == nil {
+		t.Error("expected an error for a key past the end")
+	}
+	p.count = 10
+	if _, err = p.branchElements(); err == nil {
+		t.Error("expected an error for too many elements")
+	}
+}
+
+func TestBucketValues(t *testing.T) {
+	if _, _, err := bucketHeader(make([]byte, boltBucketHdrLen-1)); err == nil {
+		t.Error("expected an error for a truncated bucket header")
+	}
+	if _, err := inlineBucketPage(make([]byte, boltBucketHdrLen+boltPageHeaderLen-1)); err == nil {
+		t.Error("expected an error for a truncated inline bucket")
+	}
+}
+
+// Garbage pages can't make any of the decoders panic
+func TestGarbagePages(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		data := make([]byte, rnd.Intn(128))
+		rnd.Read(data)
+		for _, flags := range []uint16{boltBranchPageFlag, boltLeafPageFlag, boltFreelistPageFlag} {
+			p := &boltRawPage{flags: flags, count: uint16(rnd.Intn(0x10000)), data: data}
+			if rnd.Intn(4) == 0 {
+				p.count = 0xFFFF
+			}
+			p.leafElements()
+			p.branchElements()
+			p.freelistIDs()
+		}
+		if p, err := inlineBucketPage(data); err == nil {
+			p.leafElements()
+		}
+	}
+}
+
+func TestOpenGarbageFile(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, size := range []int{0, 40, 16384} {
+		fn := filepath.Join(t.TempDir(), "garbage.db")
+		data := make([]byte, size)
+		rnd.Read(data)
+		if err := os.WriteFile(fn, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if pf, err := openBoltPageFile(fn); err == nil {
+			pf.Close()
+			t.Errorf("opened %d bytes of garbage", size)
+		}
+	}
+}
+
+// writeSalvageDB makes a DB with a bucket big enough to have its own pages, and a small one
+func writeSalvageDB(t *testing.T) (string, uint64) {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "damaged.db")
+	bdb, err := bbolt.Open(fn, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root uint64
+	err = bdb.Update(func(tx *bbolt.Tx) error {
+		big, err := tx.CreateBucket([]byte("big"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 200; i++ {
+			big.Put([]byte(strings.Repeat("k", 5)+string(rune('a'+i%26))+string(rune('a'+i/26))), bytes.Repeat([]byte("v"), 100))
+		}
+		small, err := tx.CreateBucket([]byte("small"))
+		if err != nil {
+			return err
+		}
+		small.SetSequence(7)
+		return small.Put([]byte("k"), []byte("v"))
+	})
+	if err == nil {
+		err = bdb.View(func(tx *bbolt.Tx) error {
+			root = uint64(tx.Bucket([]byte("big")).Root())
+			return nil
+		})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdb.Close()
+	return fn, root
+}
+
+func TestSalvage(t *testing.T) {
+	fn, root := writeSalvageDB(t)
+	// Scribble over the big bucket's root page
+	f, err := os.OpenFile(fn, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	f.WriteAt(garbage, int64(root)*int64(os.Getpagesize()))
+	f.Close()
+
+	out := filepath.Join(t.TempDir(), "salvaged.db")
+	report, err := salvageDatabase(fn, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.broken) != 1 || report.broken[0][0] != "big" {
+		t.Errorf("broken paths are %q, expected big", report.broken)
+	}
+	if report.buckets != 2 || report.pairs != 1 {
+		t.Errorf("salvaged %d buckets and %d pairs, expected 2 and 1", report.buckets, report.pairs)
+	}
+	bdb, err := bbolt.Open(out, 0600, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	bdb.View(func(tx *bbolt.Tx) error {
+		small := tx.Bucket([]byte("small"))
+		if small == nil || string(small.Get([]byte("k"))) != "v" || small.Sequence() != 7 {
+			t.Error("the small bucket wasn't salvaged")
+		}
+		return nil
+	})
+
+	if _, err = salvageDatabase(fn, out); err == nil {
+		t.Error("expected an error salvaging into a file that's there")
+	}
+}
+
+func TestSalvageTruncated(t *testing.T) {
+	fn, _ := writeSalvageDB(t)
+	st, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep the meta pages and cut off everything after half of the file
+	if err = os.Truncate(fn, st.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+	report, err := salvageDatabase(fn, filepath.Join(t.TempDir(), "salvaged.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.broken) == 0 {
+		t.Error("expected some of a truncated file to be broken")
+	}
+}
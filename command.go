@@ -0,0 +1,884 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/br0xen/boltbrowser/model"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+/*
+browserCommand is something that can be run from the ':' command line.
+Commands work on the selected item, or on the bucket it's in (see commandBucketPath).
+*/
+type browserCommand struct {
+	name        string
+	args        string
+	description string
+	// Lists what argument number 'argIdx' can be completed to
+	complete func(screen *BrowserScreen, argIdx int, word string) []string
+	run      func(screen *BrowserScreen, args []string) int
+}
+
+// The commands for the command line, they can be shortened as long as it's unambiguous
+var browserCommandTable = []browserCommand{
+	{"cd", "[path]", "go to a bucket, like users/42, .. or /", completeBucketPath, cmdCd},
+	{"set", "[option[=value]]...", "decoder=name, split=ratio, layout=name, wrap or nowrap", completeSetOption, cmdSet},
+	{"export", "json|ndjson|csv|value <file>", "export the selected item to a file", completeExportType, cmdExport},
+	{"put", "<key> <value>", "create or update a pair in this bucket", completeItemName, cmdPut},
+	{"rm", "[name]", "delete the selected item, or 'name' in this bucket", completeItemName, cmdRm},
+	{"seq", "[n]", "show or set the sequence of this bucket", nil, cmdSeq},
+	{"sort", "[mode]", "show or set how this bucket is sorted (byte, numeric, int, size, modified)", completeSortMode, cmdSort},
+	{"marks", "", "list the marks", nil, func(screen *BrowserScreen, args []string) int {
+		if list := screen.markList(); list != "" {
+			screen.setMessage(list)
+		} else {
+			screen.setMessage("No marks set, set one with m")
+		}
+		return BrowserScreenIndex
+	}},
+	{"query", "[expr]", "run a jq expression over the json values in this bucket", nil, cmdQuery},
+	{"run", "[-dry-run] <script.lua> [args...]", "run a Lua script against the db in one transaction", nil, cmdRun},
+	{"sql", "[select]", "run a SQL query, each bucket is a table of its pairs", nil, cmdSQLQuery},
+	{"extract", "[-marks] <file>", "copy the selected item (or everything marked) and its buckets to a new db", nil, cmdExtract},
+	{"w", "[file]", "back up the db to a file", nil, cmdWrite},
+	{"q", "", "quit", nil, func(screen *BrowserScreen, args []string) int {
+		return ExitScreenIndex
+	}},
+}
+
+/*
+Commands that get the rest of the line as it was typed instead of split into
+arguments, after this many arguments (put's key comes first, then its value)
+*/
+var rawArgCommands = map[string]int{"query": 0, "sql": 0, "put": 1}
+
+// commandName is the full name of the command 'name' is short for, if there is one
+func commandName(name string) string {
+	if cmd, err := findCommand(name); err == nil {
+		return cmd.name
+	}
+	return ""
+}
+
+// findCommand looks a command up by its name, or an unambiguous start of it
+func findCommand(name string) (*browserCommand, error) {
+	var found *browserCommand
+	for i := range browserCommandTable {
+		if browserCommandTable[i].name == name {
+			return &browserCommandTable[i], nil
+		}
+		if strings.HasPrefix(browserCommandTable[i].name, name) {
+			if found != nil {
+				return nil, fmt.Errorf("Ambiguous command: %s", name)
+			}
+			found = &browserCommandTable[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Not a command: %s", name)
+	}
+	return found, nil
+}
+
+/*
+commandLine is the state of the ':' prompt while it's being edited
+*/
+type commandLine struct {
+	text []rune
+	pos  int
+	// Older commands, oldest first, and where we are in them with up/down
+	history    []string
+	historyIdx int
+	// What was typed before going through the history, it's also the prefix we match on
+	historyPrefix string
+	// Set while tab is cycling through completions
+	completions   []string
+	completionIdx int
+	completeStart int
+}
+
+func (screen *BrowserScreen) startCommand() bool {
+	if screen.cmdLine.history == nil {
+		screen.cmdLine.history = loadCommandHistory()
+	}
+	screen.cmdLine.text = nil
+	screen.cmdLine.pos = 0
+	screen.cmdLine.historyIdx = len(screen.cmdLine.history)
+	screen.cmdLine.completions = nil
+	screen.mode = modeCommand
+	return true
+}
+
+func (screen *BrowserScreen) handleCommandKeyEvent(event termbox.Event) int {
+	cl := &screen.cmdLine
+	if event.Key != termbox.KeyTab {
+		cl.completions = nil
+	}
+	switch event.Key {
+	case termbox.KeyEsc:
+		screen.mode = modeBrowse
+	case termbox.KeyEnter:
+		screen.mode = modeBrowse
+		line := strings.TrimSpace(string(cl.text))
+		if line == "" {
+			return BrowserScreenIndex
+		}
+		if len(cl.history) == 0 || cl.history[len(cl.history)-1] != line {
+			cl.history = append(cl.history, line)
+			// Losing the history isn't worth interrupting anything over
+			saveCommandHistory(cl.history)
+		}
+		return screen.runCommandLine(line)
+	case termbox.KeyTab:
+		screen.completeCommandLine()
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if len(cl.text) == 0 {
+			screen.mode = modeBrowse
+		} else if cl.pos > 0 {
+			cl.text = append(cl.text[:cl.pos-1], cl.text[cl.pos:]...)
+			cl.pos--
+		}
+	case termbox.KeyDelete:
+		if cl.pos < len(cl.text) {
+			cl.text = append(cl.text[:cl.pos], cl.text[cl.pos+1:]...)
+		}
+	case termbox.KeyArrowLeft:
+		if cl.pos > 0 {
+			cl.pos--
+		}
+	case termbox.KeyArrowRight:
+		if cl.pos < len(cl.text) {
+			cl.pos++
+		}
+	case termbox.KeyHome, termbox.KeyCtrlA:
+		cl.pos = 0
+	case termbox.KeyEnd, termbox.KeyCtrlE:
+		cl.pos = len(cl.text)
+	case termbox.KeyCtrlU:
+		cl.text = cl.text[cl.pos:]
+		cl.pos = 0
+	case termbox.KeyCtrlW:
+		// Delete the word before the cursor
+		start := cl.pos
+		for start > 0 && cl.text[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && cl.text[start-1] != ' ' {
+			start--
+		}
+		cl.text = append(cl.text[:start], cl.text[cl.pos:]...)
+		cl.pos = start
+	case termbox.KeyArrowUp:
+		screen.commandHistoryStep(-1)
+	case termbox.KeyArrowDown:
+		screen.commandHistoryStep(1)
+	default:
+		ch := event.Ch
+		if event.Key == termbox.KeySpace {
+			ch = ' '
+		}
+		if ch != 0 {
+			cl.text = append(cl.text[:cl.pos], append([]rune{ch}, cl.text[cl.pos:]...)...)
+			cl.pos++
+		}
+	}
+	return BrowserScreenIndex
+}
+
+/*
+commandHistoryStep moves through the history, only stopping at commands
+that start with whatever was typed before we started moving
+*/
+func (screen *BrowserScreen) commandHistoryStep(dir int) {
+	cl := &screen.cmdLine
+	if cl.historyIdx == len(cl.history) {
+		cl.historyPrefix = string(cl.text)
+	}
+	for idx := cl.historyIdx + dir; idx >= 0 && idx <= len(cl.history); idx += dir {
+		if idx == len(cl.history) {
+			cl.historyIdx = idx
+			cl.text = []rune(cl.historyPrefix)
+			cl.pos = len(cl.text)
+			return
+		}
+		if strings.HasPrefix(cl.history[idx], cl.historyPrefix) {
+			cl.historyIdx = idx
+			cl.text = []rune(cl.history[idx])
+			cl.pos = len(cl.text)
+			return
+		}
+	}
+}
+
+/*
+completeCommandLine completes the word before the cursor. The first tab
+fills in as much as all of the matches have in common, after that each
+tab cycles through the matches.
+*/
+func (screen *BrowserScreen) completeCommandLine() {
+	cl := &screen.cmdLine
+	if cl.completions != nil {
+		cl.completionIdx = (cl.completionIdx + 1) % len(cl.completions)
+		screen.replaceCommandWord(cl.completeStart, cl.completions[cl.completionIdx])
+		return
+	}
+	before := string(cl.text[:cl.pos])
+	start := lastWordStart(before)
+	words, _ := splitCommandLine(before[:start])
+	word, _ := splitCommandLine(before[start:])
+	partial := ""
+	if len(word) > 0 {
+		partial = word[0]
+	}
+	var candidates []string
+	if len(words) == 0 {
+		for _, cmd := range browserCommandTable {
+			if strings.HasPrefix(cmd.name, partial) {
+				candidates = append(candidates, cmd.name+" ")
+			}
+		}
+	} else if cmd, err := findCommand(words[0]); err == nil && cmd.complete != nil {
+		for _, c := range cmd.complete(screen, len(words)-1, partial) {
+			if strings.HasPrefix(c, partial) {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	sort.Strings(candidates)
+	startRune := len([]rune(before[:start]))
+	if len(candidates) == 1 {
+		screen.replaceCommandWord(startRune, candidates[0])
+		return
+	}
+	if common := commonPrefix(candidates); len(common) > len(partial) {
+		screen.replaceCommandWord(startRune, common)
+		return
+	}
+	cl.completions = candidates
+	cl.completionIdx = 0
+	cl.completeStart = startRune
+	screen.replaceCommandWord(startRune, candidates[0])
+}
+
+// replaceCommandWord replaces everything from 'start' to the cursor with 'word'
+func (screen *BrowserScreen) replaceCommandWord(start int, word string) {
+	cl := &screen.cmdLine
+	quoted := quoteCommandArg(strings.TrimSuffix(word, " "))
+	if strings.HasSuffix(word, " ") {
+		quoted += " "
+	}
+	rest := append([]rune{}, cl.text[cl.pos:]...)
+	cl.text = append(append(cl.text[:start], []rune(quoted)...), rest...)
+	cl.pos = start + len([]rune(quoted))
+}
+
+func commonPrefix(strs []string) string {
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			r := []rune(prefix)
+			prefix = string(r[:len(r)-1])
+		}
+	}
+	return prefix
+}
+
+// lastWordStart finds where the last (possibly quoted) word in 'line' starts
+func lastWordStart(line string) int {
+	start, quote := 0, rune(0)
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ':
+			start = i + 1
+		}
+	}
+	return start
+}
+
+/*
+splitCommandLine splits a command line into words. Words can be quoted with
+" or ', and inside double quotes a backslash escapes " and \.
+*/
+func splitCommandLine(line string) ([]string, error) {
+	var ret []string
+	var word strings.Builder
+	inWord, quote := false, rune(0)
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '"' && r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\'):
+			i++
+			word.WriteRune(runes[i])
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				ret = append(ret, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return ret, errors.New("Missing closing quote")
+	}
+	if inWord {
+		ret = append(ret, word.String())
+	}
+	return ret, nil
+}
+
+// splitCommandWords splits the first 'n' words off of 'line', the rest of it is returned as it is
+func splitCommandWords(line string, n int) ([]string, string, error) {
+	var ret []string
+	for len(ret) < n {
+		line = strings.TrimLeft(line, " \t")
+		end, quote := len(line), byte(0)
+	scan:
+		for i := 0; i < len(line); i++ {
+			switch c := line[i]; {
+			case quote == '"' && c == '\\':
+				i++
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'':
+				quote = c
+			case c == ' ' || c == '\t':
+				end = i
+				break scan
+			}
+		}
+		words, err := splitCommandLine(line[:end])
+		if err != nil {
+			return ret, "", err
+		} else if len(words) == 0 {
+			break
+		}
+		ret = append(ret, words[0])
+		line = line[end:]
+	}
+	return ret, line, nil
+}
+
+// quoteCommandArg quotes 'arg' if it wouldn't come back out of splitCommandLine as is
+func quoteCommandArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(arg) + "\""
+}
+
+/*
+splitPathArg splits a path given to a command on '/',
+a '/' that's part of a name is written as '\/'
+*/
+func splitPathArg(arg string) []string {
+	var ret []string
+	var part strings.Builder
+	for i := 0; i < len(arg); i++ {
+		if arg[i] == '\\' && i+1 < len(arg) && (arg[i+1] == '/' || arg[i+1] == '\\') {
+			i++
+			part.WriteByte(arg[i])
+		} else if arg[i] == '/' {
+			ret = append(ret, part.String())
+			part.Reset()
+		} else {
+			part.WriteByte(arg[i])
+		}
+	}
+	return append(ret, part.String())
+}
+
+// escapePathPart escapes a name so splitPathArg keeps it in one piece
+func escapePathPart(name string) string {
+	return strings.NewReplacer("\\", "\\\\", "/", "\\/").Replace(name)
+}
+
+func (screen *BrowserScreen) runCommandLine(line string) int {
+	name, rest, _ := strings.Cut(line, " ")
+	if n, ok := rawArgCommands[commandName(name)]; ok {
+		args, rest, err := splitCommandWords(rest, n)
+		if err != nil {
+			screen.setMessage(err.Error())
+			return BrowserScreenIndex
+		}
+		if rest = strings.TrimSpace(rest); rest != "" {
+			args = append(args, rest)
+		}
+		screen.clearMessage()
+		cmd, _ := findCommand(name)
+		return cmd.run(screen, args)
+	}
+	args, err := splitCommandLine(line)
+	if err != nil {
+		screen.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+	if len(args) == 0 {
+		return BrowserScreenIndex
+	}
+	cmd, err := findCommand(args[0])
+	if err != nil {
+		screen.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+	screen.clearMessage()
+	return cmd.run(screen, args[1:])
+}
+
+/*
+commandBucketPath is the bucket that commands work in: the selected bucket
+when it's open, otherwise the bucket that the selected item is in
+*/
+func (screen *BrowserScreen) commandBucketPath() []string {
+	b, _, err := screen.db.getGenericFromPath(screen.currentPath)
+	if err == nil && b != nil && b.expanded {
+		return append([]string{}, screen.currentPath...)
+	}
+	if len(screen.currentPath) > 0 {
+		return append([]string{}, screen.currentPath[:len(screen.currentPath)-1]...)
+	}
+	return nil
+}
+
+// displayPath formats a path for messages
+func displayPath(path []string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	return strings.Join(model.StringifyPath(append([]string{}, path...)), " → ")
+}
+
+/*
+resolveBucketPath turns a path argument into a full path to a bucket,
+it's relative to the command bucket unless it starts with '/'
+*/
+func (screen *BrowserScreen) resolveBucketPath(arg string) ([]string, error) {
+	var path []string
+	if !strings.HasPrefix(arg, "/") {
+		path = screen.commandBucketPath()
+	}
+	for _, part := range splitPathArg(strings.TrimPrefix(arg, "/")) {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		default:
+			path = append(path, part)
+		}
+	}
+	if len(path) > 0 {
+		if _, err := screen.db.getBucketFromPath(path); err != nil {
+			return nil, fmt.Errorf("No bucket at %s", displayPath(path))
+		}
+	}
+	return path, nil
+}
+
+// bucketChildren lists the names of the buckets and pairs in the bucket at 'path'
+func (screen *BrowserScreen) bucketChildren(path []string) ([]string, []string) {
+	var buckets, pairs []string
+	if len(path) == 0 {
+		for _, b := range screen.db.buckets {
+			if b.isRoot {
+				for _, p := range b.pairs {
+					pairs = append(pairs, p.key)
+				}
+			} else {
+				buckets = append(buckets, b.name)
+			}
+		}
+		return buckets, pairs
+	}
+	b, err := screen.db.getBucketFromPath(path)
+	if err != nil {
+		return nil, nil
+	}
+	for _, bb := range b.buckets {
+		buckets = append(buckets, bb.name)
+	}
+	for _, p := range b.pairs {
+		pairs = append(pairs, p.key)
+	}
+	return buckets, pairs
+}
+
+func completeBucketPath(screen *BrowserScreen, argIdx int, word string) []string {
+	if argIdx != 0 {
+		return nil
+	}
+	dir := ""
+	if idx := strings.LastIndex(word, "/"); idx >= 0 {
+		dir = word[:idx+1]
+	}
+	path, err := screen.resolveBucketPath(dir)
+	if err != nil {
+		return nil
+	}
+	buckets, _ := screen.bucketChildren(path)
+	var ret []string
+	for _, b := range buckets {
+		ret = append(ret, dir+escapePathPart(b)+"/")
+	}
+	return ret
+}
+
+func completeItemName(screen *BrowserScreen, argIdx int, word string) []string {
+	if argIdx != 0 {
+		return nil
+	}
+	buckets, pairs := screen.bucketChildren(screen.commandBucketPath())
+	var ret []string
+	for _, n := range append(buckets, pairs...) {
+		ret = append(ret, n+" ")
+	}
+	return ret
+}
+
+// The options that ':set' takes
+var setOptions = []string{"decoder=", "split=", "layout=", "wrap", "nowrap"}
+
+func completeSetOption(screen *BrowserScreen, argIdx int, word string) []string {
+	var ret []string
+	switch {
+	case strings.HasPrefix(word, "decoder="):
+		for _, d := range model.DecoderNames() {
+			ret = append(ret, "decoder="+d+" ")
+		}
+	case strings.HasPrefix(word, "layout="):
+		for l := range narrowLayoutNames {
+			ret = append(ret, "layout="+l+" ")
+		}
+	default:
+		for _, o := range setOptions {
+			if !strings.HasSuffix(o, "=") {
+				o += " "
+			}
+			ret = append(ret, o)
+		}
+	}
+	return ret
+}
+
+func completeExportType(screen *BrowserScreen, argIdx int, word string) []string {
+	if argIdx != 0 {
+		return nil
+	}
+	return []string{"json ", "ndjson ", "csv ", "value "}
+}
+
+func completeSortMode(screen *BrowserScreen, argIdx int, word string) []string {
+	if argIdx != 0 {
+		return nil
+	}
+	var ret []string
+	for _, m := range sortModeNames() {
+		ret = append(ret, m+" ")
+	}
+	return ret
+}
+
+func cmdCd(screen *BrowserScreen, args []string) int {
+	if len(args) > 1 {
+		screen.setMessage("Usage: cd [path]")
+		return BrowserScreenIndex
+	}
+	var path []string
+	if len(args) == 1 {
+		var err error
+		if path, err = screen.resolveBucketPath(args[0]); err != nil {
+			screen.setMessage(err.Error())
+			return BrowserScreenIndex
+		}
+	}
+	if len(path) == 0 {
+		screen.recordJump()
+		screen.currentPath = screen.db.getNextVisiblePath(nil, screen.filter)
+		return BrowserScreenIndex
+	}
+	// Open everything on the way, so it's visible
+	screen.recordJump()
+	for i := 1; i <= len(path); i++ {
+		screen.db.openBucket(path[:i])
+	}
+	screen.currentPath = path
+	return BrowserScreenIndex
+}
+
+func cmdSet(screen *BrowserScreen, args []string) int {
+	bucket := screen.commandBucketPath()
+	if len(args) == 0 {
+		wrap := "wrap"
+		if screen.noWrap {
+			wrap = "nowrap"
+		}
+		layout := ""
+		for nm, l := range narrowLayoutNames {
+			if l == screen.narrowLayout {
+				layout = nm
+			}
+		}
+		screen.setMessage(fmt.Sprintf("decoder=%s split=%.2f layout=%s %s",
+			screen.decoderFor(bucket), screen.splitRatioOrDefault(), layout, wrap))
+		return BrowserScreenIndex
+	}
+	for _, arg := range args {
+		opt, val, _ := strings.Cut(arg, "=")
+		var err error
+		switch opt {
+		case "decoder":
+			if err = screen.setDecoder(bucket, val); err == nil {
+				// Some sort modes depend on the decoder
+				screen.applySortModes()
+			}
+		case "split":
+			var ratio float64
+			if ratio, err = strconv.ParseFloat(val, 64); err != nil || ratio < 0.1 || ratio > 0.9 {
+				err = errors.New("split must be between 0.1 and 0.9")
+			} else {
+				screen.setSplitRatio(ratio)
+			}
+		case "layout":
+			if l, ok := narrowLayoutNames[val]; ok {
+				screen.narrowLayout = l
+			} else {
+				err = fmt.Errorf("unknown layout %q, try one of: tree, detail, stacked", val)
+			}
+		case "wrap":
+			screen.noWrap = false
+		case "nowrap":
+			screen.noWrap = true
+		default:
+			err = fmt.Errorf("unknown option %q", opt)
+		}
+		if err != nil {
+			screen.setMessage(err.Error())
+			return BrowserScreenIndex
+		}
+	}
+	return BrowserScreenIndex
+}
+
+func cmdExport(screen *BrowserScreen, args []string) int {
+	if len(args) != 2 || (args[0] != "json" && args[0] != "ndjson" && args[0] != "csv" && args[0] != "value") {
+		screen.setMessage("Usage: export json|ndjson|csv|value <file>")
+		return BrowserScreenIndex
+	}
+	b, p, err := screen.db.getGenericFromPath(screen.currentPath)
+	if err != nil {
+		screen.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+	switch args[0] {
+	case "value":
+		if p == nil || b != nil {
+			screen.setMessage("Only pairs have a value to export")
+			return BrowserScreenIndex
+		}
+		err = exportValue(screen.currentPath, args[1])
+	case "ndjson", "csv":
+		var n int
+		if args[0] == "csv" {
+			n, err = exportCSV(screen.tableBucketPath(), args[1])
+		} else {
+			n, err = exportNDJSON(screen.currentPath, args[1])
+		}
+		if err == nil {
+			screen.setMessage(fmt.Sprintf("Exported %d pairs to file: %s", n, args[1]))
+			return BrowserScreenIndex
+		}
+	default:
+		err = exportJSON(screen.currentPath, args[1])
+	}
+	if err != nil {
+		screen.setMessage(err.Error())
+	} else {
+		screen.setMessage("Exported to file: " + args[1])
+	}
+	return BrowserScreenIndex
+}
+
+func cmdPut(screen *BrowserScreen, args []string) int {
+	if len(args) < 2 {
+		screen.setMessage("Usage: put <key> <value>")
+		return BrowserScreenIndex
+	}
+	bucket := screen.commandBucketPath()
+	if len(bucket) == 0 {
+		screen.setMessage("Pairs can't go in the root, cd into a bucket first")
+		return BrowserScreenIndex
+	}
+	// The value is the rest of the line as it was typed, unless
+	// it's all one quoted string (for spaces at the ends)
+	key, val := args[0], args[1]
+	if words, err := splitCommandLine(val); err == nil && len(words) == 1 && len(val) > 1 &&
+		(val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+		val = words[0]
+	}
+	if err := insertPair(bucket, key, val); err != nil {
+		screen.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+	screen.refreshDatabase()
+	screen.db.openBucket(bucket)
+	screen.currentPath = append(bucket, key)
+	screen.setMessage("Pair updated!")
+	return BrowserScreenIndex
+}
+
+func cmdRm(screen *BrowserScreen, args []string) int {
+	if len(args) > 1 {
+		screen.setMessage("Usage: rm [name]")
+		return BrowserScreenIndex
+	}
+	if len(args) == 0 {
+		if err := screen.deleteCurrentItem(); err != nil {
+			screen.setMessage(err.Error())
+		}
+		return BrowserScreenIndex
+	}
+	path := append(screen.commandBucketPath(), args[0])
+	if _, _, err := screen.db.getGenericFromPath(path); err != nil {
+		screen.setMessage("Nothing at " + displayPath(path))
+		return BrowserScreenIndex
+	}
+	if comparePaths(path, screen.currentPath) {
+		if err := screen.deleteCurrentItem(); err != nil {
+			screen.setMessage(err.Error())
+		}
+		return BrowserScreenIndex
+	}
+	if err := deleteKey(path); err != nil {
+		screen.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+	screen.refreshDatabase()
+	screen.setMessage("Deleted " + displayPath(path))
+	return BrowserScreenIndex
+}
+
+func cmdSeq(screen *BrowserScreen, args []string) int {
+	bucket := screen.commandBucketPath()
+	if len(args) > 1 {
+		screen.setMessage("Usage: seq [n]")
+		return BrowserScreenIndex
+	}
+	if len(args) == 0 {
+		seq, err := getBucketSequence(bucket)
+		if err != nil {
+			screen.setMessage(err.Error())
+		} else {
+			screen.setMessage(fmt.Sprintf("Sequence of %s is %d", displayPath(bucket), seq))
+		}
+		return BrowserScreenIndex
+	}
+	seq, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		screen.setMessage("Sequence must be a whole number: " + args[0])
+		return BrowserScreenIndex
+	}
+	if err = setBucketSequence(bucket, seq); err != nil {
+		screen.setMessage(err.Error())
+	} else {
+		screen.setMessage(fmt.Sprintf("Sequence of %s set to %d", displayPath(bucket), seq))
+	}
+	return BrowserScreenIndex
+}
+
+func cmdSort(screen *BrowserScreen, args []string) int {
+	bucket := screen.commandBucketPath()
+	if len(args) > 1 {
+		screen.setMessage("Usage: sort [mode]")
+		return BrowserScreenIndex
+	}
+	if len(args) == 0 {
+		screen.setMessage(fmt.Sprintf("%s is sorted by %s", displayPath(bucket), screen.sortModeFor(bucket)))
+		return BrowserScreenIndex
+	}
+	if err := screen.setSortMode(bucket, args[0]); err != nil {
+		screen.setMessage(err.Error())
+	}
+	return BrowserScreenIndex
+}
+
+func cmdExtract(screen *BrowserScreen, args []string) int {
+	marks := len(args) == 2 && args[0] == "-marks"
+	if len(args) != 1 && !marks {
+		screen.setMessage("Usage: extract [-marks] <file>")
+		return BrowserScreenIndex
+	}
+	paths := [][]string{screen.currentPath}
+	if marks {
+		if paths = screen.markedPaths(); len(paths) == 0 {
+			screen.setMessage("No marks set, set one with m")
+			return BrowserScreenIndex
+		}
+	}
+	screen.extractTo(paths, args[len(args)-1])
+	return BrowserScreenIndex
+}
+
+func cmdWrite(screen *BrowserScreen, args []string) int {
+	if len(args) > 1 {
+		screen.setMessage("Usage: w [file]")
+		return BrowserScreenIndex
+	}
+	fileName := autoBackupFilename(currentFilename)
+	if len(args) == 1 {
+		fileName = args[0]
+	}
+	if n, err := backupDatabase(db, fileName, strings.HasSuffix(fileName, ".gz")); err != nil {
+		screen.setMessage(err.Error())
+	} else {
+		screen.setMessage(fmt.Sprintf("Backed up %d bytes to file: %s", n, fileName))
+	}
+	return BrowserScreenIndex
+}
+
+/*
+drawCommandLine draws the ':' prompt over the footer, scrolled
+sideways if it has to be to keep the cursor on the screen
+*/
+func (screen *BrowserScreen) drawCommandLine(style Style) {
+	width, height := screenDisplay.Size()
+	cl := &screen.cmdLine
+	line := ":" + string(cl.text)
+	cursorX := 1 + runewidth.StringWidth(string(cl.text[:cl.pos]))
+	skip := 0
+	if cursorX >= width {
+		skip = cursorX - width + 1
+	}
+	drawStringClipped(line, 0, height-1, skip, width, style.footerFg, style.footerBg)
+	cursorCh := ' '
+	if cl.pos < len(cl.text) {
+		cursorCh = cl.text[cl.pos]
+	}
+	screenDisplay.SetCell(cursorX-skip, height-1, cursorCh, style.cursorFg, style.cursorBg)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{"cd  users/42 ", []string{"cd", "users/42"}, false},
+		{`put "a key" 'x "y"'`, []string{"put", "a key", `x "y"`}, false},
+		{`put k "a \"b\" \\ c"`, []string{"put", "k", `a "b" \ c`}, false},
+		{`put a'b c'd`, []string{"put", "ab cd"}, false},
+		{`put "" v`, []string{"put", "", "v"}, false},
+		{`put "k v`, []string{"put"}, true},
+	} {
+		got, err := splitCommandLine(tc.line)
+		if (err != nil) != tc.err || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, %v", tc.line, got, err)
+		}
+	}
+	// What completion puts in comes back out the same
+	for _, arg := range []string{"plain", "a b", `q"uote`, `back\slash`, "it's", ""} {
+		if got, _ := splitCommandLine(quoteCommandArg(arg)); len(got) != 1 || got[0] != arg {
+			t.Errorf("%q quoted as %s came back as %q", arg, quoteCommandArg(arg), got)
+		}
+	}
+}
+
+func TestCommandCompletion(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	for _, tc := range []struct {
+		keys, want string
+	}{
+		{"c tab", "cd "},
+		{"cd space tab", "cd config/"},
+		{"cd space u tab", "cd users/"},
+		{"cd space u tab tab", "cd users/orders/"},
+		// What all of the matches start with, then tab goes through them
+		{"e tab", "ex"},
+		{"ex tab", "export "},
+		{"ex tab tab", "extract "},
+		{"ex tab tab tab", "export "},
+		{"s tab tab", "set "},
+	} {
+		h.press(": " + tc.keys)
+		if got := string(h.browser().cmdLine.text); got != tc.want {
+			t.Errorf("%s: completed to %q, expected %q", tc.keys, got, tc.want)
+		}
+		h.press("esc")
+	}
+
+	// Names in the bucket, quoted if they need to be
+	h.press("j l : put space u tab")
+	if got := string(h.browser().cmdLine.text); got != "put u1 " {
+		t.Errorf("completed to %q", got)
+	}
+	h.press("esc : rm space or tab")
+	if got := string(h.browser().cmdLine.text); got != "rm orders " {
+		t.Errorf("completed to %q", got)
+	}
+	h.press(`esc : put space "my space key" space v enter : rm space my tab`)
+	if got := string(h.browser().cmdLine.text); got != `rm "my key" ` {
+		t.Errorf("completed to %q", got)
+	}
+}
+
+func TestCdCommand(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	for _, tc := range []struct {
+		arg  string
+		want []string
+	}{
+		{"users/orders", []string{"users", "orders"}},
+		{"..", []string{"users"}},
+		{"orders/../..", []string{"config"}},
+		{"/users", []string{"users"}},
+		{"/", []string{"config"}},
+	} {
+		h.press(": cd space " + tc.arg + " enter")
+		h.checkPath(tc.want...)
+	}
+	h.press(": cd space nope enter")
+	h.checkPath("config")
+	if msg := h.browser().message; msg != "No bucket at nope" {
+		t.Errorf("the message is %q", msg)
+	}
+}
+
+func TestPutCommand(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("l")
+	for _, tc := range []struct {
+		keys, key, want string
+	}{
+		// The value is the rest of the line as it was typed
+		{"k space a space space space b", "k", "a   b"},
+		{`"a space key" space {"x": space 1, space "y": space "z"}`, "a key", `{"x": 1, "y": "z"}`},
+		{`k space "a" space b`, "k", `"a" b`},
+		// Unless it's one quoted string
+		{`k space " space padded space "`, "k", " padded "},
+		{`k space ""`, "k", ""},
+	} {
+		h.press(": put space " + tc.keys + " enter")
+		h.checkValue([]string{"config", tc.key}, []byte(tc.want))
+		h.checkPath("config", tc.key)
+	}
+	h.press(": put space k enter")
+	if msg := h.browser().message; msg != "Usage: put <key> <value>" {
+		t.Errorf("the message is %q", msg)
+	}
+}
+
+func TestRmCommand(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("j l j j : rm space u3 enter")
+	h.checkValue([]string{"users", "u3"}, nil)
+	h.checkPath("users", "u1")
+	h.press(": rm space nope enter")
+	if msg := h.browser().message; msg != "Nothing at users → nope" {
+		t.Errorf("the message is %q", msg)
+	}
+	// Without a name it's the selected item
+	h.press(": rm enter")
+	h.checkValue([]string{"users", "u1"}, nil)
+	h.checkValue([]string{"users", "u2"}, []byte(`{"name":"bob","age":25}`))
+}
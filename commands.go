@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/br0xen/boltbrowser/model"
+	"go.etcd.io/bbolt"
+)
+
+/*
+subCommand is a non-interactive command, run as 'boltbrowser <name> ...'
+instead of opening the browser.
+*/
+type subCommand struct {
+	name        string
+	args        string
+	description string
+	run         func(opts map[string]string, args []string) error
+}
+
+func subCommands() []subCommand {
+	return []subCommand{
+		{"backup", "[-gzip] <db file> <backup file>", "Write a consistent copy of the DB to a file", cmdBackup},
+		{"restore", "<backup file> <db file>", "Replace the DB with a (checked) backup", cmdRestore},
+		{"export", "[-format=ndjson|csv] [-raw] <db file> [path]", "Stream the pairs in a bucket (or the whole DB) to stdout, as NDJSON or CSV", cmdExportData},
+		{"import", "[-format=ndjson|csv] [-bucket=path] [-key=column] [-value=column] [-batch=1000] <db file> [file]", "Put the pairs from an NDJSON or CSV file (or stdin) in the DB, a transaction per batch", cmdImportData},
+		{"extract", "<db file> <new db file> <path>...", "Copy buckets or pairs, with the buckets they're in, to a new DB", cmdExtractData},
+		{"salvage", "<damaged db file> <new db file>", "Copy everything readable from a damaged DB into a new one", cmdSalvage},
+		{"query", "[-format=tsv|csv|json] <db file> <sql>", "Run a SQL SELECT over the DB, each bucket is a table of its pairs", cmdSQL},
+		{"run", "[-dry-run] <script.lua> <db file> [args...]", "Run a Lua script against the DB in one transaction", cmdRunScript},
+		{"serve", "[-addr=127.0.0.1:8080] [-readonly] [-token=token] <db file>", "Serve a REST API and a web UI for the DB, changes need the token", cmdServe},
+	}
+}
+
+func findSubCommand(name string) (subCommand, bool) {
+	for _, cmd := range subCommands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return subCommand{}, false
+}
+
+/*
+commandLineSubCommand is the command that 'args' (the whole command line)
+runs, if it runs one. A file that's named like a command is a DB to browse.
+*/
+func commandLineSubCommand(args []string) (subCommand, bool) {
+	if len(args) < 2 {
+		return subCommand{}, false
+	}
+	cmd, ok := findSubCommand(args[1])
+	if !ok {
+		return cmd, false
+	}
+	if _, err := os.Stat(args[1]); err == nil {
+		return subCommand{}, false
+	}
+	return cmd, true
+}
+
+func printSubCommandUsage() {
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, cmd := range subCommands() {
+		fmt.Fprintf(os.Stderr, "  %s %s\n        %s\n", cmd.name, cmd.args, cmd.description)
+	}
+}
+
+// runSubCommand runs 'cmd' with the rest of the command line and exits
+func runSubCommand(cmd subCommand, parms []string) {
+	opts, args := parseSubCommandArgs(parms)
+	if _, ok := opts["-help"]; ok {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n        %s\n", ProgramName, cmd.name, cmd.args, cmd.description)
+		os.Exit(0)
+	}
+	if err := cmd.run(opts, args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", ProgramName, cmd.name, err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+/*
+parseSubCommandArgs splits the arguments into options and positional arguments.
+Options look like the main ones: "-key=value" or just "-flag" (which is stored as "true").
+They stop at the first positional argument or at "--", so what comes after
+(like a script's arguments) is passed on as it is, even if it starts with '-'.
+*/
+func parseSubCommandArgs(parms []string) (map[string]string, []string) {
+	opts := make(map[string]string)
+	var args []string
+	for i := range parms {
+		if parms[i] == "--" {
+			args = append(args, parms[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(parms[i], "-") {
+			args = append(args, parms[i:]...)
+			break
+		}
+		if strings.Contains(parms[i], "=") {
+			pts := strings.SplitN(parms[i], "=", 2)
+			opts[pts[0]] = pts[1]
+		} else {
+			opts[parms[i]] = "true"
+		}
+	}
+	if v, ok := opts["-timeout"]; ok {
+		if err := parseTimeoutArg(v); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	return opts, args
+}
+
+// openSubCommandDB opens the DB for a sub command, with a shared lock when 'readOnly' is set
+func openSubCommandDB(fn string, readOnly bool) (*bbolt.DB, error) {
+	if _, err := os.Stat(fn); err != nil {
+		return nil, err
+	}
+	b, err := model.Open(fn, model.Options{Timeout: AppArgs.DBOpenTimeout, ReadOnly: readOnly})
+	if err == bbolt.ErrTimeout {
+		return nil, fmt.Errorf("file %s is locked", fn)
+	} else if err != nil {
+		return nil, err
+	}
+	return b.DB(), nil
+}
+
+func cmdBackup(opts map[string]string, args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected <db file> <backup file>")
+	}
+	bdb, err := openSubCommandDB(args[0], true)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	compress := opts["-gzip"] == "true" || strings.HasSuffix(args[1], ".gz")
+	n, err := backupDatabase(bdb, args[1], compress)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up %d bytes to %s\n", n, args[1])
+	return nil
+}
+
+func cmdRestore(opts map[string]string, args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected <backup file> <db file>")
+	}
+	// Take the write lock on the target (if it exists), so we don't swap
+	// the file out from under another app
+	if _, err := os.Stat(args[1]); err == nil {
+		bdb, err := openSubCommandDB(args[1], false)
+		if err != nil {
+			return err
+		}
+		defer bdb.Close()
+	}
+	if err := restoreDatabase(args[0], args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s\n", args[1], args[0])
+	return nil
+}
+
+func cmdSalvage(opts map[string]string, args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected <damaged db file> <new db file>")
+	}
+	report, err := salvageDatabase(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Salvaged %d buckets and %d pairs into %s\n", report.buckets, report.pairs, args[1])
+	for _, e := range report.errs {
+		fmt.Printf("  unreadable: %s\n", e.Error())
+	}
+	return nil
+}
+
+func cmdSQL(opts map[string]string, args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected <db file> <sql>")
+	}
+	q, err := parseSQL(args[1])
+	if err != nil {
+		return err
+	}
+	format := opts["-format"]
+	if format == "" {
+		format = "tsv"
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var write func(row []interface{}) error
+	switch format {
+	case "tsv":
+		// Tabs and newlines in values would break up the rows
+		escape := strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+		write = func(row []interface{}) error {
+			for i, v := range row {
+				if i > 0 {
+					out.WriteByte('\t')
+				}
+				out.WriteString(escape.Replace(sqlText(v)))
+			}
+			return out.WriteByte('\n')
+		}
+	case "csv":
+		w := csv.NewWriter(out)
+		defer w.Flush()
+		write = func(row []interface{}) error {
+			rec := make([]string, len(row))
+			for i, v := range row {
+				rec[i] = sqlText(v)
+			}
+			return w.Write(rec)
+		}
+	case "json":
+		// One object per line, with the columns in the order they were selected
+		names := q.columnNames()
+		write = func(row []interface{}) error {
+			out.WriteByte('{')
+			for i, v := range row {
+				if i > 0 {
+					out.WriteByte(',')
+				}
+				k, _ := json.Marshal(names[i])
+				val, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				out.Write(k)
+				out.WriteByte(':')
+				out.Write(val)
+			}
+			_, err := out.WriteString("}\n")
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q, expected tsv, csv or json", format)
+	}
+
+	bdb, err := openSubCommandDB(args[0], true)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	return bdb.View(func(tx *bbolt.Tx) error {
+		if _, err := q.bucket(tx); err != nil {
+			return err
+		}
+		if format != "json" {
+			names := q.columnNames()
+			header := make([]interface{}, len(names))
+			for i := range names {
+				header[i] = names[i]
+			}
+			if err := write(header); err != nil {
+				return err
+			}
+		}
+		return q.run(tx, func(row []interface{}, key []byte) error {
+			return write(row)
+		})
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseSubCommandArgs(t *testing.T) {
+	for _, tc := range []struct {
+		parms []string
+		opts  map[string]string
+		args  []string
+	}{
+		{[]string{"-gzip", "db.bolt", "out.gz"}, map[string]string{"-gzip": "true"}, []string{"db.bolt", "out.gz"}},
+		{[]string{"-format=csv", "-bucket=a/b", "db.bolt"}, map[string]string{"-format": "csv", "-bucket": "a/b"}, []string{"db.bolt"}},
+		// A script's arguments are its own
+		{[]string{"-dry-run", "s.lua", "db.bolt", "-5", "-x=y"}, map[string]string{"-dry-run": "true"}, []string{"s.lua", "db.bolt", "-5", "-x=y"}},
+		{[]string{"--", "-db.bolt", "-n"}, map[string]string{}, []string{"-db.bolt", "-n"}},
+		{[]string{"-raw", "--"}, map[string]string{"-raw": "true"}, nil},
+	} {
+		opts, args := parseSubCommandArgs(tc.parms)
+		if !reflect.DeepEqual(opts, tc.opts) || !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("%q: got %q and %q, expected %q and %q", tc.parms, opts, args, tc.opts, tc.args)
+		}
+	}
+}
+
+func TestCommandLineSubCommand(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"boltbrowser"}, ""},
+		{[]string{"boltbrowser", "db.bolt"}, ""},
+		{[]string{"boltbrowser", "backup", "db.bolt", "out"}, "backup"},
+		{[]string{"boltbrowser", "query", "db.bolt", "select"}, "query"},
+	} {
+		if cmd, _ := commandLineSubCommand(tc.args); cmd.name != tc.want {
+			t.Errorf("%q runs %q, expected %q", tc.args, cmd.name, tc.want)
+		}
+	}
+
+	// Once there's a file called backup it's browsed
+	if err = os.WriteFile("backup", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if cmd, ok := commandLineSubCommand([]string{"boltbrowser", "backup"}); ok {
+		t.Errorf("runs %q, expected the file to be browsed", cmd.name)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+Config is what we load from the user's config file (JSON).
+Anything that's left out keeps its default.
+
+	{
+	  "keys": {
+	    "goto_top": ["gg"],
+	    "delete": ["dd"]
+	  },
+	  "theme": "solarized",
+	  "split_ratio": 0.4,
+	  "narrow_layout": "stacked",
+	  "key_timeout": 1000
+	}
+*/
+type Config struct {
+	// Action name => key bindings, replacing the defaults for that action
+	Keys map[string][]string `json:"keys"`
+	// The name of the theme to use, either built in or from Themes
+	Theme string `json:"theme"`
+	// User defined themes, by name
+	Themes map[string]ThemeColors `json:"themes"`
+	// Where the panes are split, as a fraction of the screen (0.1 to 0.9)
+	SplitRatio float64 `json:"split_ratio"`
+	// What narrow terminals show to start with: "tree", "detail" or "stacked"
+	NarrowLayout string `json:"narrow_layout"`
+	// How long to wait for the rest of a key sequence, in milliseconds
+	KeyTimeout int `json:"key_timeout"`
+}
+
+// The default KeyTimeout, the same as vim's timeoutlen
+const defaultKeyTimeout = 1000
+
+// keyTimeout is how long a key that starts a longer binding waits for the next one
+func keyTimeout() time.Duration {
+	if AppConfig.KeyTimeout <= 0 {
+		return defaultKeyTimeout * time.Millisecond
+	}
+	return time.Duration(AppConfig.KeyTimeout) * time.Millisecond
+}
+
+var AppConfig Config
+
+// configFilename returns the config file to load, either from the
+// command line or the default in the user's config directory
+func configFilename() string {
+	if AppArgs.ConfigFile != "" {
+		return AppArgs.ConfigFile
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ProgramName, "config.json")
+}
+
+/*
+loadConfig reads the config file into AppConfig and applies it.
+A missing config file isn't an error, unless it was given on the command line.
+*/
+func loadConfig() error {
+	fn := configFilename()
+	if fn == "" {
+		return nil
+	}
+	data, err := os.ReadFile(fn)
+	if os.IsNotExist(err) && AppArgs.ConfigFile == "" {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &AppConfig); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	if err = applyKeyConfig(AppConfig.Keys); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	if AppConfig.SplitRatio != 0 && (AppConfig.SplitRatio < 0.1 || AppConfig.SplitRatio > 0.9) {
+		return fmt.Errorf("%s: split_ratio must be between 0.1 and 0.9", fn)
+	}
+	if _, ok := narrowLayoutNames[AppConfig.NarrowLayout]; AppConfig.NarrowLayout != "" && !ok {
+		return fmt.Errorf("%s: unknown narrow_layout %q", fn, AppConfig.NarrowLayout)
+	}
+	if AppConfig.KeyTimeout < 0 {
+		return fmt.Errorf("%s: key_timeout can't be negative", fn)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/br0xen/boltbrowser/model"
+)
+
+/*
+decoderFor finds the decoder set for the bucket at 'path',
+or the closest bucket above it that has one
+*/
+func (screen *BrowserScreen) decoderFor(path []string) string {
+	for i := len(path); i >= 0; i-- {
+		if name, ok := screen.decoders[pathKey(path[:i])]; ok {
+			return name
+		}
+	}
+	return model.DefaultDecoder
+}
+
+/*
+decodeValue decodes a pair's value with the decoder for its bucket,
+if that fails the value is shown the default way along with the error
+*/
+func (screen *BrowserScreen) decodeValue(p *BoltPair) (string, error) {
+	d, ok := model.FindDecoder(screen.decoderFor(p.GetPath()))
+	if !ok {
+		d, _ = model.FindDecoder(model.DefaultDecoder)
+	}
+	out, err := d.Decode([]byte(p.val))
+	if err != nil {
+		return string(model.FormatValue([]byte(p.val))), err
+	}
+	return out, nil
+}
+
+// setDecoder sets the decoder for the bucket at 'path' and everything under it
+func (screen *BrowserScreen) setDecoder(path []string, name string) error {
+	if _, ok := model.FindDecoder(name); !ok {
+		names := model.DecoderNames()
+		sort.Strings(names)
+		return fmt.Errorf("unknown decoder %q, try one of: %s", name, strings.Join(names, ", "))
+	}
+	if screen.decoders == nil {
+		screen.decoders = make(map[string]string)
+	}
+	screen.decoders[pathKey(path)] = name
+	return nil
+}
+
+/*
+pathKey turns a path into a string that can be used as a map key. Bucket
+names can have any bytes in them, NULs too, so it's the json the path is
+saved as rather than the parts joined with a separator.
+*/
+func pathKey(path []string) string {
+	data, _ := json.Marshal(statePath(path))
+	return string(data)
+}
+
+// keyPath is the path a pathKey was made from
+func keyPath(k string) []string {
+	var path statePath
+	json.Unmarshal([]byte(k), &path)
+	return path
+}
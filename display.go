@@ -0,0 +1,190 @@
+package main
+
+import (
+	"strings"
+
+	termboxUtil "github.com/br0xen/termbox-util"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+/*
+display is what the screens draw on, the terminal when boltbrowser
+is running, or a cellBuffer when it isn't (like in the tests)
+*/
+type display interface {
+	Size() (int, int)
+	SetCell(x, y int, ch rune, fg, bg termbox.Attribute)
+	Clear(fg, bg termbox.Attribute) error
+	Flush() error
+	// Interrupt wakes the main loop up with an EventInterrupt
+	Interrupt()
+}
+
+// screenDisplay is where everything is drawn
+var screenDisplay display = termboxDisplay{}
+
+// termboxDisplay draws on the terminal
+type termboxDisplay struct{}
+
+func (termboxDisplay) Size() (int, int) { return termbox.Size() }
+
+func (termboxDisplay) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	termbox.SetCell(x, y, ch, fg, bg)
+}
+
+func (termboxDisplay) Clear(fg, bg termbox.Attribute) error { return termbox.Clear(fg, bg) }
+
+func (termboxDisplay) Flush() error { return termbox.Flush() }
+
+func (termboxDisplay) Interrupt() { termbox.Interrupt() }
+
+/*
+cellBuffer is a display in memory, a grid of cells like termbox's
+back buffer. Anything drawn off of the grid is left out.
+*/
+type cellBuffer struct {
+	width  int
+	height int
+	cells  []termbox.Cell
+}
+
+func newCellBuffer(width, height int) *cellBuffer {
+	b := &cellBuffer{width: width, height: height, cells: make([]termbox.Cell, width*height)}
+	b.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	return b
+}
+
+func (b *cellBuffer) Size() (int, int) { return b.width, b.height }
+
+func (b *cellBuffer) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	if x < 0 || y < 0 || x >= b.width || y >= b.height {
+		return
+	}
+	b.cells[y*b.width+x] = termbox.Cell{Ch: ch, Fg: fg, Bg: bg}
+}
+
+func (b *cellBuffer) Clear(fg, bg termbox.Attribute) error {
+	for i := range b.cells {
+		b.cells[i] = termbox.Cell{Ch: ' ', Fg: fg, Bg: bg}
+	}
+	return nil
+}
+
+func (b *cellBuffer) Flush() error { return nil }
+
+// Interrupt does nothing, there's no loop waiting on a cellBuffer
+func (b *cellBuffer) Interrupt() {}
+
+// Cell is the cell at x,y
+func (b *cellBuffer) Cell(x, y int) termbox.Cell {
+	return b.cells[y*b.width+x]
+}
+
+// String is the text on the grid, a line for each row without the spaces at the end
+func (b *cellBuffer) String() string {
+	var ret strings.Builder
+	for y := 0; y < b.height; y++ {
+		var line strings.Builder
+		for x := 0; x < b.width; x++ {
+			ch := b.Cell(x, y).Ch
+			if ch == 0 {
+				ch = ' '
+			}
+			line.WriteRune(ch)
+			// The cell after a wide rune is covered by it
+			if runewidth.RuneWidth(ch) == 2 {
+				x++
+			}
+		}
+		ret.WriteString(strings.TrimRight(line.String(), " "))
+		ret.WriteByte('\n')
+	}
+	return ret.String()
+}
+
+// drawStringAtPoint is termboxUtil.DrawStringAtPoint, on the screenDisplay
+func drawStringAtPoint(str string, x int, y int, fg termbox.Attribute, bg termbox.Attribute) (int, int) {
+	xPos := x
+	for _, runeValue := range str {
+		screenDisplay.SetCell(xPos, y, runeValue, fg, bg)
+		xPos++
+	}
+	return xPos, y
+}
+
+// fillWithChar is termboxUtil.FillWithChar, on the screenDisplay
+func fillWithChar(r rune, x1, y1, x2, y2 int, fg termbox.Attribute, bg termbox.Attribute) {
+	for xx := x1; xx <= x2; xx++ {
+		for yx := y1; yx <= y2; yx++ {
+			screenDisplay.SetCell(xx, yx, r, fg, bg)
+		}
+	}
+}
+
+func drawBorder(x1, y1, x2, y2 int, fg termbox.Attribute, bg termbox.Attribute) {
+	screenDisplay.SetCell(x1, y1, '+', fg, bg)
+	fillWithChar('-', x1+1, y1, x2-1, y1, fg, bg)
+	screenDisplay.SetCell(x2, y1, '+', fg, bg)
+	fillWithChar('|', x1, y1+1, x1, y2-1, fg, bg)
+	fillWithChar('|', x2, y1+1, x2, y2-1, fg, bg)
+	screenDisplay.SetCell(x1, y2, '+', fg, bg)
+	fillWithChar('-', x1+1, y2, x2-1, y2, fg, bg)
+	screenDisplay.SetCell(x2, y2, '+', fg, bg)
+}
+
+/*
+drawInputModal draws 'mod'. termboxUtil's modals only draw on the terminal,
+so on any other display it's drawn here with just what boltbrowser's modals
+have: a title, a line of text, the value (its end, if it doesn't fit) and
+the help, all clipped to the modal by their width on the screen.
+*/
+func drawInputModal(mod *termboxUtil.InputModal) {
+	if _, ok := screenDisplay.(termboxDisplay); ok {
+		mod.Draw()
+		return
+	}
+	if !mod.IsVisible() {
+		return
+	}
+	x, y, w, h := mod.GetX(), mod.GetY(), mod.GetWidth(), mod.GetHeight()
+	fg, bg := mod.GetFgColor(), mod.GetBgColor()
+	fillWithChar(' ', x, y, x+w, y+h, fg, bg)
+	drawBorder(x, y, x+w, y+h, fg, bg)
+	nextY := drawModalText(mod.GetTitle(), mod.GetText(), x, y+1, w, fg, bg)
+	fieldX, fieldW := x+2, w-2
+	drawBorder(fieldX, nextY, fieldX+fieldW, nextY+2, fg, bg)
+	cx := fieldX + 1 + drawStringClipped(truncateLeft(mod.GetValue(), fieldW-2), fieldX+1, nextY+1, 0, fieldW-2, fg, bg)
+	screenDisplay.SetCell(cx, nextY+1, ' ', bg, fg)
+	help := " (ENTER) to Accept. (ESC) to Cancel. "
+	drawStringClipped(help, x+w-len(help)-1, nextY+3, 0, len(help), fg, bg)
+}
+
+// drawConfirmModal draws 'mod', like drawInputModal
+func drawConfirmModal(mod *termboxUtil.ConfirmModal) {
+	if _, ok := screenDisplay.(termboxDisplay); ok {
+		mod.Draw()
+		return
+	}
+	x, y, w, h := mod.GetX(), mod.GetY(), mod.GetWidth(), mod.GetHeight()
+	fg, bg := mod.GetFgColor(), mod.GetBgColor()
+	fillWithChar(' ', x, y, x+w, y+h, fg, bg)
+	drawBorder(x, y, x+w, y+h, fg, bg)
+	nextY := drawModalText(mod.GetTitle(), mod.GetText(), x, y+1, w, fg, bg)
+	help := " (Y/y) Confirm. (N/n) Reject. "
+	drawStringClipped(help, x+w-len(help)-1, nextY+1, 0, len(help), fg, bg)
+}
+
+// drawModalText draws a modal's title, with a line under it, and its text from row 'y', it returns the row after them
+func drawModalText(title, text string, x, y, w int, fg, bg termbox.Attribute) int {
+	if title != "" {
+		drawStringClipped(title, x+1, y, 0, w-1, fg, bg)
+		fillWithChar('-', x+1, y+1, x+w-1, y+1, fg, bg)
+		y += 2
+	}
+	if text != "" {
+		drawStringClipped(text, x+1, y, 0, w-1, fg, bg)
+		y++
+	}
+	return y
+}
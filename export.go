@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/br0xen/boltbrowser/model"
+	"go.etcd.io/bbolt"
+)
+
+// The formats the export dialog can write, and the extension of the file name it suggests for each
+var exportFormats = []string{"raw", "json", "ndjson", "csv", "yaml", "hex", "base64", "bolt"}
+var exportExtensions = map[string]string{
+	"json": ".json", "ndjson": ".ndjson", "csv": ".csv", "yaml": ".yaml",
+	"hex": ".hex", "base64": ".b64", "bolt": ".db",
+}
+
+// What gets exported
+const (
+	exportScopePair = iota
+	exportScopeBucket
+	exportScopeRecursive
+	exportScopeFiltered
+	exportScopeResults
+)
+
+var exportScopeNames = []string{"pair", "bucket", "recursive", "filtered view", "search results"}
+
+// Where it goes
+const (
+	exportToFile = iota
+	exportToStdout
+	exportToClipboard
+)
+
+var exportDestNames = []string{"file", "stdout on exit", "clipboard"}
+
+// exitOutput is what's been exported to stdout, it's written out when boltbrowser exits
+var exitOutput bytes.Buffer
+
+/*
+exportWriter writes pairs in one of the export formats. 'bucket' is the
+path to the bucket the pair is in, from the root. A nil 'v' is the bucket
+'k', which comes before what's in it.
+*/
+type exportWriter interface {
+	pair(bucket []string, k, v []byte) error
+	close() error
+}
+
+/*
+newExportWriter makes the writer for 'format'. Buckets in json and yaml
+are nested under 'base', which is left off of their paths. 'single' is set
+when it's one pair, so raw, hex and base64 write just the value, otherwise
+they write a line for each pair: the key, a tab and the value. csv works out
+its columns from 'sample', some of the values. bolt writes a new DB to the
+file 'fName' instead of 'w'.
+*/
+func newExportWriter(format string, w io.Writer, fName string, base []string, single bool, sample [][]byte) (exportWriter, error) {
+	out := bufio.NewWriter(w)
+	switch format {
+	case "raw":
+		return &valueExportWriter{out: out, single: single, enc: func(b []byte) string { return string(b) }}, nil
+	case "hex":
+		return &valueExportWriter{out: out, single: single, enc: hex.EncodeToString, newline: true}, nil
+	case "base64":
+		return &valueExportWriter{out: out, single: single, enc: base64.StdEncoding.EncodeToString, newline: true}, nil
+	case "json", "yaml":
+		return &treeExportWriter{out: out, base: base, yaml: format == "yaml", items: []int{0}}, nil
+	case "ndjson":
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+		return &ndjsonExportWriter{out: out, enc: enc}, nil
+	case "csv":
+		c, err := model.NewCSVWriter(out, sample, model.CSVOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{out: out, csv: c}, nil
+	case "bolt":
+		return newBoltExportWriter(fName)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// valueExportWriter writes raw, hex or base64 values
+type valueExportWriter struct {
+	out    *bufio.Writer
+	enc    func([]byte) string
+	single bool
+	// A single value ends with a newline, for the encoded ones
+	newline bool
+}
+
+func (e *valueExportWriter) pair(bucket []string, k, v []byte) error {
+	if v == nil {
+		return nil
+	}
+	if e.single {
+		e.out.WriteString(e.enc(v))
+		if e.newline {
+			e.out.WriteByte('\n')
+		}
+		return nil
+	}
+	e.out.WriteString(e.enc(k))
+	e.out.WriteByte('\t')
+	e.out.WriteString(e.enc(v))
+	return e.out.WriteByte('\n')
+}
+
+func (e *valueExportWriter) close() error { return e.out.Flush() }
+
+/*
+treeExportWriter writes the pairs as json objects (or yaml maps), with a
+nested one for each bucket, empty ones too. The pairs and buckets come in
+the order they're in the DB, so only the buckets that the last one was in
+have to be kept open.
+*/
+type treeExportWriter struct {
+	out  *bufio.Writer
+	yaml bool
+	base []string
+	// The buckets that are open, under base
+	open []string
+	// How many items have been written in the root, and each open bucket
+	items []int
+	// In yaml the innermost bucket's name has been written, but not
+	// what comes after it, that depends on whether it's empty
+	pending bool
+}
+
+func (e *treeExportWriter) pair(bucket []string, k, v []byte) error {
+	rel := bucket
+	if len(rel) >= len(e.base) {
+		rel = rel[len(e.base):]
+	}
+	if v == nil {
+		e.openBuckets(append(rel[:len(rel):len(rel)], string(k)))
+		return nil
+	}
+	e.openBuckets(rel)
+	e.item(string(k))
+	if e.yaml {
+		e.out.WriteString(": " + jsonQuote(string(v)) + "\n")
+	} else {
+		e.out.WriteString(":" + jsonQuote(string(v)))
+	}
+	return nil
+}
+
+// openBuckets closes the open buckets that aren't in 'rel', and opens the ones in it that aren't open
+func (e *treeExportWriter) openBuckets(rel []string) {
+	same := 0
+	for same < len(e.open) && same < len(rel) && e.open[same] == rel[same] {
+		same++
+	}
+	for len(e.open) > same {
+		e.closeBucket()
+	}
+	for _, nm := range rel[same:] {
+		e.item(nm)
+		if e.yaml {
+			e.pending = true
+		} else {
+			e.out.WriteString(":{")
+		}
+		e.open = append(e.open, nm)
+		e.items = append(e.items, 0)
+	}
+}
+
+// item starts the next item in the innermost open bucket, the key 'nm'
+func (e *treeExportWriter) item(nm string) {
+	if e.yaml {
+		if e.pending {
+			// The bucket isn't empty
+			e.out.WriteString(":\n")
+			e.pending = false
+		}
+		e.out.WriteString(strings.Repeat("  ", len(e.open)))
+	} else if e.items[len(e.open)] > 0 {
+		e.out.WriteByte(',')
+	} else if len(e.open) == 0 {
+		e.out.WriteByte('{')
+	}
+	e.items[len(e.open)]++
+	e.out.WriteString(jsonQuote(nm))
+}
+
+func (e *treeExportWriter) closeBucket() {
+	if !e.yaml {
+		e.out.WriteByte('}')
+	} else if e.pending {
+		e.out.WriteString(": {}\n")
+		e.pending = false
+	}
+	e.open = e.open[:len(e.open)-1]
+	e.items = e.items[:len(e.items)-1]
+}
+
+func (e *treeExportWriter) close() error {
+	for len(e.open) > 0 {
+		e.closeBucket()
+	}
+	switch {
+	case e.items[0] == 0 && e.yaml:
+		e.out.WriteString("{}\n")
+	case e.items[0] == 0:
+		e.out.WriteString("{}")
+	case !e.yaml:
+		e.out.WriteByte('}')
+	}
+	return e.out.Flush()
+}
+
+// jsonQuote quotes 's' as a json string, which yaml reads too
+func jsonQuote(s string) string {
+	out, _ := json.Marshal(s)
+	return string(out)
+}
+
+type ndjsonExportWriter struct {
+	out *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *ndjsonExportWriter) pair(bucket []string, k, v []byte) error {
+	if v == nil {
+		// The records are only pairs, the buckets are in their paths
+		return nil
+	}
+	return e.enc.Encode(model.NewRecord(bucket, k, v))
+}
+
+func (e *ndjsonExportWriter) close() error { return e.out.Flush() }
+
+type csvExportWriter struct {
+	out *bufio.Writer
+	csv *model.CSVWriter
+}
+
+func (e *csvExportWriter) pair(bucket []string, k, v []byte) error {
+	if v == nil {
+		return nil
+	}
+	return e.csv.Write(k, v)
+}
+
+func (e *csvExportWriter) close() error {
+	if err := e.csv.Flush(); err != nil {
+		return err
+	}
+	return e.out.Flush()
+}
+
+/*
+boltExportWriter puts the pairs in a new DB file, in the same buckets
+they're in now, committing every model.DefaultBatchSize pairs
+*/
+type boltExportWriter struct {
+	db    *bbolt.DB
+	tx    *bbolt.Tx
+	n     int
+	fName string
+}
+
+func newBoltExportWriter(fName string) (*boltExportWriter, error) {
+	// Putting pairs in a DB that's already there would mix them in with what's in it
+	if _, err := os.Stat(fName); err == nil {
+		return nil, fmt.Errorf("%s already exists", fName)
+	}
+	bdb, err := bbolt.Open(fName, 0600, &bbolt.Options{Timeout: AppArgs.DBOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	tx, err := bdb.Begin(true)
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+	return &boltExportWriter{db: bdb, tx: tx, fName: fName}, nil
+}
+
+func (e *boltExportWriter) pair(bucket []string, k, v []byte) error {
+	if v == nil {
+		bucket, k = append(bucket[:len(bucket):len(bucket)], string(k)), nil
+	}
+	if len(bucket) == 0 {
+		return model.ErrRootPair
+	}
+	bkt, err := e.tx.CreateBucketIfNotExists([]byte(bucket[0]))
+	for i := 1; err == nil && i < len(bucket); i++ {
+		bkt, err = bkt.CreateBucketIfNotExists([]byte(bucket[i]))
+	}
+	if err != nil || v == nil {
+		return err
+	}
+	if err = bkt.Put(k, v); err != nil {
+		return err
+	}
+	if e.n++; e.n%model.DefaultBatchSize == 0 {
+		if err = e.tx.Commit(); err != nil {
+			return err
+		}
+		e.tx, err = e.db.Begin(true)
+	}
+	return err
+}
+
+func (e *boltExportWriter) close() error {
+	err := e.tx.Commit()
+	if cerr := e.db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(e.fName)
+	}
+	return err
+}
+
+// abort is close for when the export failed, like an extract nothing is left behind
+func (e *boltExportWriter) abort() {
+	e.tx.Rollback()
+	e.db.Close()
+	os.Remove(e.fName)
+}
+
+/*
+exportPairs calls 'fn' with each pair in 'scope', in a read transaction.
+The recursive scope has the buckets too, with a nil value, so empty ones
+are exported. The filtered view is the pairs in the tree as it's shown,
+and the search results are the pairs from the last query or SQL query.
+*/
+func (screen *BrowserScreen) exportPairs(scope int, fn func(bucket []string, k, v []byte) error) error {
+	b := model.New(db)
+	switch scope {
+	case exportScopePair:
+		return b.ForEachPair(screen.currentPath, false, fn)
+	case exportScopeBucket:
+		return b.ForEachPair(screen.tableBucketPath(), false, fn)
+	case exportScopeRecursive:
+		return b.Walk(screen.tableBucketPath(), fn)
+	}
+	var paths [][]string
+	if scope == exportScopeFiltered {
+		paths = screen.filteredPairPaths()
+	} else {
+		paths = screen.searchResultPaths()
+	}
+	return db.View(func(tx *bbolt.Tx) error {
+		for _, p := range paths {
+			bucket, key := p[:len(p)-1], []byte(p[len(p)-1])
+			bkt, err := model.TxBucket(tx, bucket)
+			if err != nil {
+				// It's gone since the tree was read
+				continue
+			}
+			if v := bkt.Get(key); v != nil {
+				if len(bucket) > 0 && bucket[0] == "" {
+					bucket = bucket[1:]
+				}
+				if err = fn(bucket, key, v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// exportBase is the bucket that json and yaml exports of 'scope' are nested under
+func (screen *BrowserScreen) exportBase(scope int) []string {
+	var ret []string
+	switch scope {
+	case exportScopePair:
+		ret = screen.currentPath[:len(screen.currentPath)-1]
+	case exportScopeBucket, exportScopeRecursive:
+		ret = screen.tableBucketPath()
+	}
+	if len(ret) > 0 && ret[0] == "" {
+		ret = ret[1:]
+	}
+	return ret
+}
+
+// filteredPairPaths are the paths of the pairs that are in the tree, as it's shown
+func (screen *BrowserScreen) filteredPairPaths() [][]string {
+	var ret [][]string
+	visPaths, _ := screen.db.buildVisiblePathSlice(screen.filter)
+	for _, p := range visPaths {
+		if _, err := screen.db.getPairFromPath(p); err == nil {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// searchResultPaths are the paths of the pairs from the last query or SQL query, whichever was run last
+func (screen *BrowserScreen) searchResultPaths() [][]string {
+	var ret [][]string
+	if screen.sqlLast && screen.sql != nil {
+		for _, k := range screen.sql.keys {
+			if k != nil {
+				ret = append(ret, append(append([]string{}, screen.sql.from...), string(k)))
+			}
+		}
+	} else if screen.query != nil {
+		for i, r := range screen.query.results {
+			// A pair can have more than one result
+			if i == 0 || r.key != screen.query.results[i-1].key {
+				ret = append(ret, append(append([]string{}, screen.query.path...), r.key))
+			}
+		}
+	}
+	return ret
+}
+
+// extractTo copies 'paths' to a new DB file and says how it went
+func (screen *BrowserScreen) extractTo(paths [][]string, fName string) {
+	report, err := extractToFile(paths, fName)
+	if err != nil {
+		screen.setMessage(err.Error())
+		return
+	}
+	screen.setMessage(fmt.Sprintf("Extracted %d buckets and %d pairs to file: %s", report.Buckets, report.Pairs, fName))
+}
+
+// copyToClipboard copies 'data' with whichever clipboard command there is
+func copyToClipboard(data []byte) error {
+	for _, c := range [][]string{
+		{"pbcopy"},
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+		{"clip.exe"},
+	} {
+		if _, err := exec.LookPath(c[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		return cmd.Run()
+	}
+	return errors.New("no clipboard command, expected one of pbcopy, wl-copy, xclip, xsel or clip.exe")
+}
+
+func cmdExportData(opts map[string]string, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("expected <db file> [path]")
+	}
+	format := opts["-format"]
+	if format == "" {
+		format = "ndjson"
+	}
+	var path []string
+	if len(args) == 2 && args[1] != "" && args[1] != "/" {
+		path = splitPathArg(args[1])
+	}
+	bdb, err := openSubCommandDB(args[0], true)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	var n int
+	switch format {
+	case "ndjson":
+		n, err = model.New(bdb).ExportNDJSON(os.Stdout, path)
+	case "csv":
+		if len(path) == 0 {
+			return errors.New("expected the path of the bucket to export as csv")
+		}
+		n, err = model.New(bdb).ExportCSV(os.Stdout, path, model.CSVOptions{Raw: opts["-raw"] == "true"})
+	default:
+		return fmt.Errorf("unknown format %q, expected ndjson or csv", format)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d pairs\n", n)
+	return nil
+}
+
+func cmdExtractData(opts map[string]string, args []string) error {
+	if len(args) < 3 {
+		return errors.New("expected <db file> <new db file> <path>...")
+	}
+	var paths [][]string
+	for _, p := range args[2:] {
+		paths = append(paths, splitPathArg(strings.TrimPrefix(p, "/")))
+	}
+	bdb, err := openSubCommandDB(args[0], true)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	report, err := model.New(bdb).Extract(args[1], paths)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Extracted %d buckets and %d pairs into %s\n", report.Buckets, report.Pairs, args[1])
+	return nil
+}
+
+func cmdImportData(opts map[string]string, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("expected <db file> [file]")
+	}
+	format := opts["-format"]
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		return fmt.Errorf("unknown format %q, expected ndjson or csv", format)
+	}
+	var bucket []string
+	if format == "csv" {
+		if opts["-bucket"] == "" {
+			return errors.New("csv needs the bucket to import into, with -bucket=path")
+		}
+		bucket = splitPathArg(opts["-bucket"])
+	}
+	batch := model.DefaultBatchSize
+	if v, ok := opts["-batch"]; ok {
+		var err error
+		if batch, err = strconv.Atoi(v); err != nil || batch <= 0 {
+			return fmt.Errorf("invalid batch size %q", v)
+		}
+	}
+	var in io.Reader = os.Stdin
+	if len(args) == 2 && args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	// Importing into a new file creates it
+	if _, err := os.Stat(args[0]); os.IsNotExist(err) {
+		if err = createDBFile(args[0]); err != nil {
+			return err
+		}
+	}
+	bdb, err := openSubCommandDB(args[0], false)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	var n int
+	if format == "csv" {
+		n, err = model.New(bdb).ImportCSV(in, bucket, model.CSVImportOptions{
+			KeyColumn:   opts["-key"],
+			ValueColumn: opts["-value"],
+			BatchSize:   batch,
+		})
+	} else {
+		n, err = model.New(bdb).ImportNDJSON(in, batch)
+	}
+	fmt.Printf("Imported %d pairs into %s\n", n, args[0])
+	return err
+}
+
+// createDBFile makes an empty DB file
+func createDBFile(fn string) error {
+	b, err := model.Open(fn, model.Options{Timeout: AppArgs.DBOpenTimeout})
+	if err != nil {
+		return err
+	}
+	return b.Close()
+}
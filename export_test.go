@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/br0xen/boltbrowser/model"
+)
+
+func TestBoltExportWriter(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "out.db")
+	w, err := newBoltExportWriter(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.pair([]string{"a"}, []byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.pair(nil, []byte("k"), []byte("v")); err != model.ErrRootPair {
+		t.Fatalf("expected %v, got %v", model.ErrRootPair, err)
+	}
+	w.abort()
+	if _, err = os.Stat(fn); !os.IsNotExist(err) {
+		t.Errorf("a failed export left %s behind: %v", fn, err)
+	}
+
+	w, err = newBoltExportWriter(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.pair([]string{"a"}, []byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newBoltExportWriter(fn); err == nil {
+		t.Error("expected an error exporting into a DB that's there")
+	}
+}
@@ -2,12 +2,18 @@ module github.com/br0xen/boltbrowser
 
 require (
 	github.com/br0xen/termbox-util v0.0.0-20170904143325-de1d4c83380e
+	github.com/itchyny/gojq v0.12.13
+	github.com/mattn/go-runewidth v0.0.14
 	github.com/nsf/termbox-go v1.1.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	github.com/yuin/gopher-lua v1.1.1
 	go.etcd.io/bbolt v1.3.7
 )
 
 require (
-	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/itchyny/timefmt-go v0.1.5 // indirect
+	github.com/rivo/uniseg v0.4.4 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
 )
 
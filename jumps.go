@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// How many places the jump list remembers
+const maxJumps = 100
+
+/*
+recordJump remembers where the cursor is before a big move (like g/G, :cd or
+going to a mark), so jumpBack can return to it. Anything that had been
+gone back over is forgotten, like in vim.
+*/
+func (screen *BrowserScreen) recordJump() {
+	if len(screen.currentPath) == 0 {
+		return
+	}
+	if screen.jumpIdx < len(screen.jumps) {
+		screen.jumps = screen.jumps[:screen.jumpIdx]
+	}
+	if n := len(screen.jumps); n == 0 || !comparePaths(screen.jumps[n-1], screen.currentPath) {
+		screen.jumps = append(screen.jumps, append([]string{}, screen.currentPath...))
+	}
+	if len(screen.jumps) > maxJumps {
+		screen.jumps = screen.jumps[len(screen.jumps)-maxJumps:]
+	}
+	screen.jumpIdx = len(screen.jumps)
+}
+
+func (screen *BrowserScreen) jumpBack() bool {
+	if screen.jumpIdx >= len(screen.jumps) {
+		// Remember where we are so jumpForward can come back here
+		screen.recordJump()
+		screen.jumpIdx = len(screen.jumps) - 1
+	}
+	for screen.jumpIdx > 0 {
+		screen.jumpIdx--
+		if screen.goToPath(screen.jumps[screen.jumpIdx]) == nil {
+			return true
+		}
+	}
+	screen.setMessage("Already at the oldest jump")
+	return false
+}
+
+func (screen *BrowserScreen) jumpForward() bool {
+	for screen.jumpIdx < len(screen.jumps)-1 {
+		screen.jumpIdx++
+		if screen.goToPath(screen.jumps[screen.jumpIdx]) == nil {
+			return true
+		}
+	}
+	screen.setMessage("Already at the newest jump")
+	return false
+}
+
+/*
+goToPath moves the cursor to 'path', opening the buckets on the way
+to it. The path might not be there anymore if the db has changed.
+*/
+func (screen *BrowserScreen) goToPath(path []string) error {
+	if _, _, err := screen.db.getGenericFromPath(path); err != nil {
+		return fmt.Errorf("%s isn't there anymore", displayPath(path))
+	}
+	for i := 1; i < len(path); i++ {
+		screen.db.openBucket(path[:i])
+	}
+	screen.currentPath = append([]string{}, path...)
+	return nil
+}
+
+// startSetMark waits for the name of the mark to set at the cursor
+func (screen *BrowserScreen) startSetMark() bool {
+	screen.setMessageWithTimeout("Set mark:", -1)
+	screen.keyArgument = func(screen *BrowserScreen, key string) int {
+		screen.setMark(key)
+		return BrowserScreenIndex
+	}
+	return true
+}
+
+// startGotoMark waits for the name of the mark to go to
+func (screen *BrowserScreen) startGotoMark() bool {
+	screen.setMessageWithTimeout("Go to mark:", -1)
+	screen.keyArgument = func(screen *BrowserScreen, key string) int {
+		screen.gotoMark(key)
+		return BrowserScreenIndex
+	}
+	return true
+}
+
+// Marks are named with a single character, so they fit in a key press
+func validMarkName(name string) bool {
+	return utf8.RuneCountInString(name) == 1 && name != " "
+}
+
+func (screen *BrowserScreen) setMark(name string) bool {
+	if !validMarkName(name) {
+		screen.setMessage("Marks are named with a single character")
+		return false
+	}
+	if len(screen.currentPath) == 0 {
+		return false
+	}
+	state := screen.dbState()
+	if state.Marks == nil {
+		state.Marks = make(map[string]statePath)
+	}
+	state.Marks[name] = append([]string{}, screen.currentPath...)
+	if err := state.save(); err != nil {
+		screen.setMessage("Mark " + name + " set, but couldn't be saved: " + err.Error())
+		return false
+	}
+	screen.setMessage("Mark " + name + " set")
+	return true
+}
+
+func (screen *BrowserScreen) gotoMark(name string) bool {
+	path, ok := screen.dbState().Marks[name]
+	if !ok {
+		screen.setMessage("Mark " + name + " isn't set")
+		return false
+	}
+	prev := screen.currentPath
+	screen.recordJump()
+	if err := screen.goToPath(path); err != nil {
+		screen.currentPath = prev
+		screen.setMessage("Mark " + name + ": " + err.Error())
+		return false
+	}
+	return true
+}
+
+// markedPaths are the paths of all of the marks
+func (screen *BrowserScreen) markedPaths() [][]string {
+	var ret [][]string
+	for _, p := range screen.dbState().Marks {
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// markList describes all of the marks, for ':marks'
+func (screen *BrowserScreen) markList() string {
+	marks := screen.dbState().Marks
+	var names []string
+	for nm := range marks {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	var ret []string
+	for _, nm := range names {
+		ret = append(ret, nm+": "+displayPath(marks[nm]))
+	}
+	return strings.Join(ret, ", ")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+/*
+keyNames maps the names used in key bindings to termbox keys.
+Anything that isn't in here is taken as a sequence of plain characters,
+so "gg" is 'g' followed by 'g'.
+*/
+var keyNames = withCtrlKeyNames(map[string]termbox.Key{
+	"esc":       termbox.KeyEsc,
+	"enter":     termbox.KeyEnter,
+	"tab":       termbox.KeyTab,
+	"space":     termbox.KeySpace,
+	"backspace": termbox.KeyBackspace2,
+	"delete":    termbox.KeyDelete,
+	"insert":    termbox.KeyInsert,
+	"home":      termbox.KeyHome,
+	"end":       termbox.KeyEnd,
+	"pgup":      termbox.KeyPgup,
+	"pgdn":      termbox.KeyPgdn,
+	"up":        termbox.KeyArrowUp,
+	"down":      termbox.KeyArrowDown,
+	"left":      termbox.KeyArrowLeft,
+	"right":     termbox.KeyArrowRight,
+	"f1":        termbox.KeyF1,
+	"f2":        termbox.KeyF2,
+	"f3":        termbox.KeyF3,
+	"f4":        termbox.KeyF4,
+	"f5":        termbox.KeyF5,
+	"f6":        termbox.KeyF6,
+	"f7":        termbox.KeyF7,
+	"f8":        termbox.KeyF8,
+	"f9":        termbox.KeyF9,
+	"f10":       termbox.KeyF10,
+	"f11":       termbox.KeyF11,
+	"f12":       termbox.KeyF12,
+})
+
+// How key names are shown on the help screen, if not just the name
+var keyDisplayNames = map[string]string{
+	"up":    "↑",
+	"down":  "↓",
+	"left":  "←",
+	"right": "→",
+}
+
+// withCtrlKeyNames adds ctrl+a through ctrl+z to the key names
+func withCtrlKeyNames(names map[string]termbox.Key) map[string]termbox.Key {
+	for i := 0; i < 26; i++ {
+		names[fmt.Sprintf("ctrl+%c", 'a'+i)] = termbox.KeyCtrlA + termbox.Key(i)
+	}
+	// These share key codes with named keys, so we prefer the named versions
+	delete(names, "ctrl+h") // backspace
+	delete(names, "ctrl+i") // tab
+	delete(names, "ctrl+m") // enter
+	return names
+}
+
+/*
+eventKeyName converts a key event into the name we use for it in bindings
+*/
+func eventKeyName(event termbox.Event) string {
+	if event.Ch != 0 {
+		return string(event.Ch)
+	}
+	if event.Key == termbox.KeyBackspace {
+		return "backspace"
+	}
+	for nm, k := range keyNames {
+		if k == event.Key {
+			return nm
+		}
+	}
+	return ""
+}
+
+/*
+parseKeySequence turns a binding like "ctrl+f", "gg" or "g ctrl+f"
+into the list of key names that have to be pressed
+*/
+func parseKeySequence(binding string) ([]string, error) {
+	var ret []string
+	for _, tok := range strings.Fields(binding) {
+		if _, ok := keyNames[strings.ToLower(tok)]; ok {
+			ret = append(ret, strings.ToLower(tok))
+			continue
+		}
+		if strings.Contains(tok, "+") && len(tok) > 1 {
+			return nil, fmt.Errorf("unknown key %q", tok)
+		}
+		for _, r := range tok {
+			ret = append(ret, string(r))
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("empty key binding")
+	}
+	return ret, nil
+}
+
+// displayKeySequence formats a binding for the help screen
+func displayKeySequence(binding string) string {
+	seq, err := parseKeySequence(binding)
+	if err != nil {
+		return binding
+	}
+	sep := ""
+	for _, k := range seq {
+		if _, ok := keyDisplayNames[k]; !ok && len(k) > 1 && len(seq) > 1 {
+			// Named keys in a sequence need some space around them
+			sep = " "
+		}
+	}
+	var pts []string
+	for _, k := range seq {
+		if d, ok := keyDisplayNames[k]; ok {
+			pts = append(pts, d)
+		} else {
+			pts = append(pts, k)
+		}
+	}
+	return strings.Join(pts, sep)
+}
+
+/*
+keyBinding is a parsed key sequence and the action it triggers
+*/
+type keyBinding struct {
+	keys   []string
+	action *browserAction
+}
+
+// The active bindings for the browser, built from the action table
+var browserKeyBindings []keyBinding
+
+func buildKeyBindings() error {
+	browserKeyBindings = nil
+	for i := range browserActionTable {
+		for _, binding := range browserActionTable[i].keys {
+			seq, err := parseKeySequence(binding)
+			if err != nil {
+				return fmt.Errorf("action %s: %w", browserActionTable[i].name, err)
+			}
+			browserKeyBindings = append(browserKeyBindings, keyBinding{seq, &browserActionTable[i]})
+		}
+	}
+	return nil
+}
+
+/*
+applyKeyConfig replaces the default bindings of the actions named in 'keys'
+*/
+func applyKeyConfig(keys map[string][]string) error {
+	for name, bindings := range keys {
+		found := false
+		for i := range browserActionTable {
+			if browserActionTable[i].name == name {
+				browserActionTable[i].keys = bindings
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown action %q in key bindings", name)
+		}
+	}
+	return buildKeyBindings()
+}
+
+/*
+matchKeySequence looks up the keys pressed so far.
+It returns the action they trigger (if any) and whether they're the start
+of a longer binding, in which case we should wait for more keys.
+*/
+func matchKeySequence(keys []string) (*browserAction, bool) {
+	var exact *browserAction
+	partial := false
+	for _, kb := range browserKeyBindings {
+		if len(kb.keys) < len(keys) {
+			continue
+		}
+		match := true
+		for i := range keys {
+			if kb.keys[i] != keys[i] {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if len(kb.keys) == len(keys) {
+			if exact == nil {
+				exact = kb.action
+			}
+		} else {
+			partial = true
+		}
+	}
+	return exact, partial
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/br0xen/boltbrowser/model"
 	"github.com/nsf/termbox-go"
 	"go.etcd.io/bbolt"
 )
@@ -20,12 +21,20 @@ var memBolt *BoltDB
 
 var currentFilename string
 
+// exitError is set when something goes wrong that boltbrowser can't carry on from
+var exitError error
+
 const DefaultDBOpenTimeout = time.Second
 
 var AppArgs struct {
 	DBOpenTimeout time.Duration
 	ReadOnly      bool
+	Snapshot      bool
+	Salvage       bool
+	AutoBackup    bool
 	NoValue       bool
+	ConfigFile    string
+	Theme         string
 }
 
 func init() {
@@ -51,23 +60,36 @@ func parseArgs() {
 			key, val := pts[0], pts[1]
 			switch key {
 			case "-timeout":
-				AppArgs.DBOpenTimeout, err = time.ParseDuration(val)
-				if err != nil {
-					// See if we can successfully parse by adding a 's'
-					AppArgs.DBOpenTimeout, err = time.ParseDuration(val + "s")
-				}
-				// If err is still not nil, print usage
-				if err != nil {
+				// If we can't parse it, print usage
+				if err = parseTimeoutArg(val); err != nil {
 					printUsage(err)
 				}
 			case "-readonly", "-ro":
 				if val == "true" {
 					AppArgs.ReadOnly = true
 				}
+			case "-snapshot":
+				if val == "true" {
+					AppArgs.Snapshot = true
+					AppArgs.ReadOnly = true
+				}
+			case "-salvage":
+				if val == "true" {
+					AppArgs.Salvage = true
+					AppArgs.ReadOnly = true
+				}
+			case "-autobackup":
+				if val == "true" {
+					AppArgs.AutoBackup = true
+				}
 			case "-no-value":
 				if val == "true" {
 					AppArgs.NoValue = true
 				}
+			case "-config":
+				AppArgs.ConfigFile = val
+			case "-theme":
+				AppArgs.Theme = val
 			case "-help":
 				printUsage(nil)
 			default:
@@ -78,6 +100,14 @@ func parseArgs() {
 			switch parms[i] {
 			case "-readonly", "-ro":
 				AppArgs.ReadOnly = true
+			case "-snapshot":
+				AppArgs.Snapshot = true
+				AppArgs.ReadOnly = true
+			case "-salvage":
+				AppArgs.Salvage = true
+				AppArgs.ReadOnly = true
+			case "-autobackup":
+				AppArgs.AutoBackup = true
 			case "-no-value":
 				AppArgs.NoValue = true
 			case "-help":
@@ -89,35 +119,82 @@ func parseArgs() {
 	}
 }
 
+func parseTimeoutArg(val string) error {
+	var err error
+	AppArgs.DBOpenTimeout, err = time.ParseDuration(val)
+	if err != nil {
+		// See if we can successfully parse by adding a 's'
+		AppArgs.DBOpenTimeout, err = time.ParseDuration(val + "s")
+	}
+	return err
+}
+
 func printUsage(err error) {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
 	}
-	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] <filename(s)>\nOptions:\n", ProgramName)
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] <filename(s)>\n", ProgramName)
+	fmt.Fprintf(os.Stderr, "       %s <command> [COMMAND OPTIONS] <arguments>\n", ProgramName)
+	fmt.Fprintf(os.Stderr, "A DB file named like a command is opened, not run\n")
+	printSubCommandUsage()
+	fmt.Fprintf(os.Stderr, "Options:\n")
 	fmt.Fprintf(os.Stderr, "  -timeout=duration\n        DB file open timeout (default 1s)\n")
 	fmt.Fprintf(os.Stderr, "  -ro, -readonly   \n        Open the DB in read-only mode\n")
+	fmt.Fprintf(os.Stderr, "  -snapshot        \n        Browse a read-only copy of the file, for DBs locked by another app\n")
+	fmt.Fprintf(os.Stderr, "  -salvage         \n        Browse whatever can still be read from a damaged DB\n")
+	fmt.Fprintf(os.Stderr, "  -autobackup      \n        Back up the DB file before the first change in a session\n")
 	fmt.Fprintf(os.Stderr, "  -no-value        \n        Do not display a value in left pane\n")
+	fmt.Fprintf(os.Stderr, "  -theme=name      \n        Color theme: dark, light, solarized, high-contrast or one from the config\n")
+	fmt.Fprintf(os.Stderr, "  -config=file     \n        Config file to load (default %s)\n", configFilename())
 }
 
 func main() {
 	var err error
 
+	if cmd, ok := commandLineSubCommand(os.Args); ok {
+		runSubCommand(cmd, os.Args[2:])
+	}
 	parseArgs()
+	if err = loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err.Error())
+		os.Exit(1)
+	}
+	style, outputMode, err := loadStyle()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading theme: %s\n", err.Error())
+		os.Exit(1)
+	}
 
 	err = termbox.Init()
 	if err != nil {
 		panic(err)
 	}
 	defer termbox.Close()
-	style := defaultStyle()
-	termbox.SetOutputMode(termbox.Output256)
+	termbox.SetOutputMode(outputMode)
 
 	for _, databaseFile := range databaseFiles {
 		currentFilename = databaseFile
-		db, err = bbolt.Open(databaseFile, 0600, &bbolt.Options{Timeout: AppArgs.DBOpenTimeout})
+		autoBackupDone = false
+		openFilename := databaseFile
+		if AppArgs.Salvage {
+			openFilename, err = salvageToTempFile(databaseFile)
+			if err != nil {
+				termbox.Close()
+				fmt.Printf("Error salvaging file: %q\n", err.Error())
+				os.Exit(1)
+			}
+		} else if AppArgs.Snapshot {
+			openFilename, err = snapshotDatabase(databaseFile)
+			if err != nil {
+				termbox.Close()
+				fmt.Printf("Error taking snapshot: %q\n", err.Error())
+				os.Exit(1)
+			}
+		}
+		db, err = openDB(openFilename)
 		if err == bbolt.ErrTimeout {
 			termbox.Close()
-			fmt.Printf("File %s is locked. Make sure it's not used by another app and try again\n", databaseFile)
+			fmt.Printf("File %s is locked. Make sure it's not used by another app and try again, or use -snapshot to browse a copy\n", databaseFile)
 			os.Exit(1)
 		} else if err != nil {
 			if len(databaseFiles) > 1 {
@@ -125,20 +202,52 @@ func main() {
 				continue
 			} else {
 				termbox.Close()
-				fmt.Printf("Error reading file: %q\n", err.Error())
+				fmt.Printf("Error reading file: %q\nTry -salvage to browse what can still be read\n", err.Error())
 				os.Exit(1)
 			}
 		}
 
 		// First things first, load the database into memory
-		memBolt.refreshDatabase()
-		if AppArgs.ReadOnly {
-			// If we're opening it in readonly mode, close it now
+		if _, err = memBolt.refreshDatabase(); err != nil && len(databaseFiles) == 1 {
+			termbox.Close()
 			db.Close()
+			fmt.Printf("Error reading file: %q\nTry -salvage to browse what can still be read\n", err.Error())
+			os.Exit(1)
 		}
 
 		// Kick off the UI loop
+		// In read-only mode the handle stays open (with a shared lock) so
+		// that refreshes and exports keep working for the whole session
 		mainLoop(memBolt, style)
-		defer db.Close()
+		if exitError != nil {
+			termbox.Close()
+			if openFilename != databaseFile {
+				os.Remove(openFilename)
+			}
+			fmt.Printf("Error: %s\n", exitError.Error())
+			os.Exit(1)
+		}
+		db.Close()
+		if openFilename != databaseFile {
+			// Clean up the snapshot/salvage copy
+			os.Remove(openFilename)
+		}
+	}
+	if exitOutput.Len() > 0 {
+		// What was exported to stdout goes after the terminal is back to normal
+		termbox.Close()
+		os.Stdout.Write(exitOutput.Bytes())
+	}
+}
+
+// openDB opens 'fn' with the options from the command line
+func openDB(fn string) (*bbolt.DB, error) {
+	b, err := model.Open(fn, model.Options{
+		Timeout:  AppArgs.DBOpenTimeout,
+		ReadOnly: AppArgs.ReadOnly,
+	})
+	if err != nil {
+		return nil, err
 	}
+	return b.DB(), nil
 }
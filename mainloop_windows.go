@@ -8,22 +8,18 @@ package main
 import "github.com/nsf/termbox-go"
 
 func mainLoop(memBolt *BoltDB, style Style) {
-	screens := defaultScreensForData(memBolt)
+	screens := defaultScreensForData(memBolt, style)
 	displayScreen := screens[BrowserScreenIndex]
+	termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
 	layoutAndDrawScreen(displayScreen, style)
 	for {
-		event := termbox.PollEvent()
-		if event.Type == termbox.EventKey {
-			newScreenIndex := displayScreen.handleKeyEvent(event)
-			if newScreenIndex < len(screens) {
-				displayScreen = screens[newScreenIndex]
-				layoutAndDrawScreen(displayScreen, style)
-			} else {
-				break
-			}
-		}
-		if event.Type == termbox.EventResize {
-			layoutAndDrawScreen(displayScreen, style)
+		var ok bool
+		if displayScreen, ok = handleEvent(screens, displayScreen, termbox.PollEvent(), style); !ok {
+			break
 		}
 	}
+	if browserScreen, ok := screens[BrowserScreenIndex].(*BrowserScreen); ok {
+		// The UI state is a convenience, not being able to save it isn't worth stopping for
+		browserScreen.saveState()
+	}
 }
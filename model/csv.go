@@ -0,0 +1,325 @@
+package model
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// DefaultCSVSample is how many values are read to work out the CSV columns
+const DefaultCSVSample = 100
+
+/*
+CSVOptions are how a bucket is exported as CSV
+*/
+type CSVOptions struct {
+	// Raw always exports a key and a value column, even for json values
+	Raw bool
+	// Sample is how many values are read for the header, 0 is DefaultCSVSample
+	Sample int
+}
+
+/*
+CSVImportOptions are how CSV rows are turned into pairs
+*/
+type CSVImportOptions struct {
+	// KeyColumn is the column with the keys, "key" (or the first column) by default
+	KeyColumn string
+	// ValueColumn is the column to use as the value as it is. If it isn't set
+	// the value is a json object of the other columns, unless the only
+	// other column is "value"
+	ValueColumn string
+	// BatchSize is how many pairs go in each transaction, 0 is DefaultBatchSize
+	BatchSize int
+}
+
+/*
+CSVColumns works out the columns to export the bucket at 'path' with, from
+the first values in it. If any of them are json objects it's "key" and
+their fields, with nested objects flattened to "a.b" and sorted, otherwise
+(or if one of the fields is "key" too) it's "key" and "value".
+*/
+func (b *Browser) CSVColumns(path []string, opts CSVOptions) ([]string, error) {
+	sample, err := b.csvSample(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	columns, _ := csvColumns(sample, opts)
+	return columns, nil
+}
+
+// csvSample is the values the columns are worked out from, the first in the bucket
+func (b *Browser) csvSample(path []string, opts CSVOptions) ([][]byte, error) {
+	n := opts.Sample
+	if n <= 0 {
+		n = DefaultCSVSample
+	}
+	var sample [][]byte
+	err := b.ForEachPair(path, false, func(_ []string, _, v []byte) error {
+		if len(sample) == n {
+			return errSampled
+		}
+		sample = append(sample, append([]byte{}, v...))
+		return nil
+	})
+	if err == errSampled {
+		err = nil
+	}
+	return sample, err
+}
+
+var errSampled = errors.New("sampled")
+
+// csvColumns is the columns for the values in 'sample', and whether they're the raw "key" and "value"
+func csvColumns(sample [][]byte, opts CSVOptions) ([]string, bool) {
+	fields := make(map[string]bool)
+	for _, v := range sample {
+		if opts.Raw {
+			break
+		}
+		if obj, ok := jsonObject(v); ok {
+			flattenJSON("", obj, func(nm string, _ interface{}) { fields[nm] = true })
+		}
+	}
+	// A field called "key" would have the same column as the keys,
+	// the values are kept whole instead
+	if len(fields) == 0 || fields["key"] {
+		return []string{"key", "value"}, true
+	}
+	var ret []string
+	for nm := range fields {
+		ret = append(ret, nm)
+	}
+	sort.Strings(ret)
+	return append([]string{"key"}, ret...), false
+}
+
+/*
+CSVWriter writes pairs as CSV rows, with the columns CSVColumns would
+come up with for the sample values it's made with. Fields that aren't in
+the sample aren't written, and values that aren't json objects have
+empty fields.
+*/
+type CSVWriter struct {
+	out     *csv.Writer
+	raw     bool
+	columns map[string]int
+	row     []string
+}
+
+// NewCSVWriter writes the header for the columns of 'sample' to 'w'
+func NewCSVWriter(w io.Writer, sample [][]byte, opts CSVOptions) (*CSVWriter, error) {
+	columns, raw := csvColumns(sample, opts)
+	ret := &CSVWriter{out: csv.NewWriter(w), raw: raw, columns: make(map[string]int), row: make([]string, len(columns))}
+	for i, nm := range columns[1:] {
+		ret.columns[nm] = i + 1
+	}
+	return ret, ret.out.Write(columns)
+}
+
+// Write writes the row for a pair
+func (c *CSVWriter) Write(k, v []byte) error {
+	c.row[0] = string(k)
+	if c.raw {
+		c.row[1] = string(v)
+		return c.out.Write(c.row)
+	}
+	for i := 1; i < len(c.row); i++ {
+		c.row[i] = ""
+	}
+	if obj, ok := jsonObject(v); ok {
+		flattenJSON("", obj, func(nm string, val interface{}) {
+			if i, ok := c.columns[nm]; ok {
+				c.row[i] = csvField(val)
+			}
+		})
+	}
+	return c.out.Write(c.row)
+}
+
+// Flush writes out anything that's buffered
+func (c *CSVWriter) Flush() error {
+	c.out.Flush()
+	return c.out.Error()
+}
+
+/*
+ExportCSV writes the pairs in the bucket at 'path' to 'w' as CSV, with a
+header row, see CSVWriter. Buckets in it are left out, CSV is for flat
+buckets. It returns the number of pairs.
+*/
+func (b *Browser) ExportCSV(w io.Writer, path []string, opts CSVOptions) (int, error) {
+	sample, err := b.csvSample(path, opts)
+	if err != nil {
+		return 0, err
+	}
+	out, err := NewCSVWriter(w, sample, opts)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = b.ForEachPair(path, false, func(_ []string, k, v []byte) error {
+		count++
+		return out.Write(k, v)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, out.Flush()
+}
+
+/*
+ImportCSV puts a pair in the bucket at 'path' for each row of CSV in 'r',
+creating the bucket if it isn't there. The first row is the header.
+When the value is built from the columns, "a.b" columns are nested
+objects, empty fields are left out, and fields that are json numbers,
+booleans, arrays or objects are kept as json, anything else is a string.
+*/
+func (b *Browser) ImportCSV(r io.Reader, path []string, opts CSVImportOptions) (int, error) {
+	if len(path) == 0 || (len(path) == 1 && path[0] == "") {
+		return 0, ErrRootPair
+	}
+	in := csv.NewReader(r)
+	header, err := in.Read()
+	if err != nil {
+		return 0, fmt.Errorf("reading the header: %w", err)
+	}
+	header = append([]string{}, header...)
+	keyCol, valCol := 0, -1
+	if opts.KeyColumn != "" {
+		if keyCol = indexOf(header, opts.KeyColumn); keyCol < 0 {
+			return 0, fmt.Errorf("there's no column %q", opts.KeyColumn)
+		}
+	} else if i := indexOf(header, "key"); i >= 0 {
+		keyCol = i
+	}
+	if opts.ValueColumn != "" {
+		if valCol = indexOf(header, opts.ValueColumn); valCol < 0 {
+			return 0, fmt.Errorf("there's no column %q", opts.ValueColumn)
+		}
+	} else if len(header) == 2 && header[1-keyCol] == "value" {
+		valCol = 1 - keyCol
+	}
+	in.ReuseRecord = true
+
+	return b.importBatches(opts.BatchSize, func(tx *bbolt.Tx) (bool, error) {
+		row, err := in.Read()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if row[keyCol] == "" {
+			return false, errors.New("empty key")
+		}
+		var v []byte
+		if valCol >= 0 {
+			v = []byte(row[valCol])
+		} else {
+			obj := make(map[string]interface{})
+			for i := range row {
+				if i != keyCol && row[i] != "" {
+					setJSONField(obj, header[i], row[i])
+				}
+			}
+			if v, err = json.Marshal(obj); err != nil {
+				return false, err
+			}
+		}
+		bkt, err := tx.CreateBucketIfNotExists([]byte(path[0]))
+		for i := 1; err == nil && i < len(path); i++ {
+			bkt, err = bkt.CreateBucketIfNotExists([]byte(path[i]))
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, bkt.Put([]byte(row[keyCol]), v)
+	})
+}
+
+// jsonObject decodes 'v' if it's a json object
+func jsonObject(v []byte) (map[string]interface{}, bool) {
+	if t := bytes.TrimSpace(v); len(t) == 0 || t[0] != '{' {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(v))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if dec.Decode(&obj) != nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+// flattenJSON calls 'fn' with each field in 'obj', nested objects' fields are "a.b"
+func flattenJSON(prefix string, obj map[string]interface{}, fn func(nm string, val interface{})) {
+	for k, v := range obj {
+		if child, ok := v.(map[string]interface{}); ok && len(child) > 0 {
+			flattenJSON(prefix+k+".", child, fn)
+		} else {
+			fn(prefix+k, v)
+		}
+	}
+}
+
+// csvField is how a json value is written in a CSV field
+func csvField(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return fmt.Sprint(t)
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+// setJSONField sets the field 'nm' ("a.b" is nested) of 'obj' from a CSV field
+func setJSONField(obj map[string]interface{}, nm, field string) {
+	parts := strings.Split(nm, ".")
+	for _, p := range parts[:len(parts)-1] {
+		child, ok := obj[p].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			obj[p] = child
+		}
+		obj = child
+	}
+	var val interface{} = field
+	if isJSONField(field) {
+		val = json.RawMessage(field)
+	}
+	obj[parts[len(parts)-1]] = val
+}
+
+// isJSONField is whether a CSV field is a json number, boolean, array or object
+func isJSONField(field string) bool {
+	if field == "true" || field == "false" {
+		return true
+	}
+	switch field[0] {
+	case '[', '{', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return json.Valid([]byte(field))
+	}
+	return false
+}
+
+func indexOf(list []string, s string) int {
+	for i := range list {
+		if list[i] == s {
+			return i
+		}
+	}
+	return -1
+}
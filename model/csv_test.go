@@ -0,0 +1,105 @@
+package model
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	src := openTestDB(t, "src.db")
+	err := src.DB().Update(func(tx *bbolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		users.Put([]byte("u1"), []byte(`{"name":"alice","age":30,"address":{"city":"Oslo"}}`))
+		users.Put([]byte("u2"), []byte(`{"name":"bob, jr","tags":["a","b"],"zip":"007"}`))
+		_, err = users.CreateBucket([]byte("skipped"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	n, err := src.ExportCSV(&out, []string{"users"}, CSVOptions{})
+	if err != nil || n != 2 {
+		t.Fatalf("exported %d pairs, %v", n, err)
+	}
+	want := `key,address.city,age,name,tags,zip
+u1,Oslo,30,alice,,
+u2,,,"bob, jr","[""a"",""b""]",007
+`
+	if out.String() != want {
+		t.Errorf("exported:\n%s", out.String())
+	}
+
+	dst := openTestDB(t, "dst.db")
+	if n, err = dst.ImportCSV(&out, []string{"users"}, CSVImportOptions{}); err != nil || n != 2 {
+		t.Fatalf("imported %d pairs, %v", n, err)
+	}
+	for k, want := range map[string]string{
+		"u1": `{"address":{"city":"Oslo"},"age":30,"name":"alice"}`,
+		"u2": `{"name":"bob, jr","tags":["a","b"],"zip":"007"}`,
+	} {
+		if v, err := dst.Value([]string{"users", k}); err != nil || string(v) != want {
+			t.Errorf("users/%s is %s, %v", k, v, err)
+		}
+	}
+}
+
+func TestCSVRawValues(t *testing.T) {
+	dst := openTestDB(t, "dst.db")
+	in := "id,value\n1,plain text\n2,\"{\"\"not\"\":\"\"parsed\"\"}\"\n"
+	n, err := dst.ImportCSV(strings.NewReader(in), []string{"raw"}, CSVImportOptions{KeyColumn: "id"})
+	if err != nil || n != 2 {
+		t.Fatalf("imported %d pairs, %v", n, err)
+	}
+	if v, _ := dst.Value([]string{"raw", "2"}); string(v) != `{"not":"parsed"}` {
+		t.Errorf("raw/2 is %s", v)
+	}
+	var out bytes.Buffer
+	if _, err = dst.ExportCSV(&out, []string{"raw"}, CSVOptions{Raw: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "key,value\n1,plain text\n") {
+		t.Errorf("exported:\n%s", out.String())
+	}
+}
+
+func TestCSVKeyField(t *testing.T) {
+	src := openTestDB(t, "src.db")
+	err := src.DB().Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("items"))
+		if err != nil {
+			return err
+		}
+		b.Put([]byte("i1"), []byte(`{"key":"k1","n":1}`))
+		return b.Put([]byte("i2"), []byte(`{"n":2}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err = src.ExportCSV(&out, []string{"items"}, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `key,value
+i1,"{""key"":""k1"",""n"":1}"
+i2,"{""n"":2}"
+`
+	if out.String() != want {
+		t.Errorf("exported:\n%s", out.String())
+	}
+
+	dst := openTestDB(t, "dst.db")
+	if _, err = dst.ImportCSV(&out, []string{"items"}, CSVImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Value([]string{"items", "i1"}); string(v) != `{"key":"k1","n":1}` {
+		t.Errorf("items/i1 is %s", v)
+	}
+}
@@ -0,0 +1,237 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+/*
+Decoder turns the raw bytes of a value into something readable.
+boltbrowser picks them per bucket with ':set decoder=name'.
+*/
+type Decoder struct {
+	Name        string
+	Description string
+	Decode      func(v []byte) (string, error)
+}
+
+// DefaultDecoder is the decoder used for buckets that haven't had one set
+const DefaultDecoder = "auto"
+
+// Decoders are all of the decoders, by name
+var Decoders = []Decoder{
+	{"auto", "json if it parses, otherwise text or hex", func(v []byte) (string, error) {
+		return string(FormatValue(v)), nil
+	}},
+	{"string", "text, or hex if it isn't printable", func(v []byte) (string, error) {
+		return Stringify(v), nil
+	}},
+	{"json", "indented json", func(v []byte) (string, error) {
+		out, err := FormatValueJSON(v)
+		return string(out), err
+	}},
+	{"hex", "hex dump", func(v []byte) (string, error) {
+		return strings.TrimRight(hex.Dump(v), "\n"), nil
+	}},
+	{"int", "big endian integer", DecodeBigEndianInt},
+	{"timestamp", "a time, as text, a unix time or in a json field", func(v []byte) (string, error) {
+		t, err := DecodeTimestamp(v)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s (%s ago)", t.UTC().Format(time.RFC3339Nano), time.Since(t).Round(time.Second)), nil
+	}},
+	{"msgpack", "messagepack, shown as json", func(v []byte) (string, error) {
+		var val interface{}
+		if err := msgpack.Unmarshal(v, &val); err != nil {
+			return "", err
+		}
+		out, err := json.MarshalIndent(JSONSafe(val), "", "  ")
+		return string(out), err
+	}},
+}
+
+// FindDecoder looks up a decoder by name
+func FindDecoder(name string) (*Decoder, bool) {
+	for i := range Decoders {
+		if Decoders[i].Name == name {
+			return &Decoders[i], true
+		}
+	}
+	return nil, false
+}
+
+// DecoderNames lists the names of all of the decoders, for completion and errors
+func DecoderNames() []string {
+	var ret []string
+	for _, d := range Decoders {
+		ret = append(ret, d.Name)
+	}
+	return ret
+}
+
+// DecodeBigEndianInt reads a 1, 2, 4 or 8 byte big endian unsigned integer
+func DecodeBigEndianInt(v []byte) (string, error) {
+	switch len(v) {
+	case 1:
+		return fmt.Sprintf("%d", v[0]), nil
+	case 2:
+		return fmt.Sprintf("%d", binary.BigEndian.Uint16(v)), nil
+	case 4:
+		return fmt.Sprintf("%d", binary.BigEndian.Uint32(v)), nil
+	case 8:
+		u := binary.BigEndian.Uint64(v)
+		if int64(u) < 0 {
+			return fmt.Sprintf("%d (signed %d)", u, int64(u)), nil
+		}
+		return fmt.Sprintf("%d", u), nil
+	}
+	return "", errors.New("not 1, 2, 4 or 8 bytes long")
+}
+
+// The fields that are looked at for a time when a value is a json object
+var timestampFields = []string{"updated_at", "updatedAt", "modified", "modified_at", "modifiedAt", "mtime", "timestamp", "time", "created_at", "createdAt"}
+
+/*
+DecodeTimestamp reads a time out of a value. It can be RFC 3339 text,
+a unix time as text or an 8 byte big endian number (in seconds, milli-,
+micro- or nanoseconds, guessed from how big it is), or a json object
+with one of those in one of the timestampFields.
+*/
+func DecodeTimestamp(v []byte) (time.Time, error) {
+	if len(v) == 8 && Stringify(v) != string(v) {
+		return UnixTime(float64(int64(binary.BigEndian.Uint64(v)))), nil
+	}
+	text := strings.TrimSpace(string(v))
+	if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseFloat(text, 64); err == nil {
+		return UnixTime(n), nil
+	}
+	var obj map[string]interface{}
+	if json.Unmarshal(v, &obj) == nil {
+		for _, f := range timestampFields {
+			switch fv := obj[f].(type) {
+			case string:
+				return DecodeTimestamp([]byte(fv))
+			case float64:
+				return UnixTime(fv), nil
+			}
+		}
+	}
+	return time.Time{}, errors.New("not a time")
+}
+
+// UnixTime turns a unix time in seconds, milli-, micro- or nanoseconds into a time
+func UnixTime(n float64) time.Time {
+	abs := math.Abs(n)
+	switch {
+	case abs < 1e11:
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9))
+	case abs < 1e14:
+		return time.UnixMilli(int64(n))
+	case abs < 1e17:
+		return time.UnixMicro(int64(n))
+	}
+	return time.Unix(0, int64(n))
+}
+
+/*
+JSONSafe converts what msgpack decodes into something encoding/json takes,
+msgpack maps can have keys that aren't strings
+*/
+func JSONSafe(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = JSONSafe(val)
+		}
+		return m
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = JSONSafe(val)
+		}
+		return t
+	case []interface{}:
+		for i := range t {
+			t[i] = JSONSafe(t[i])
+		}
+		return t
+	case []byte:
+		return hex.EncodeToString(t)
+	}
+	return v
+}
+
+/*
+FormatValue is how a value is shown by default: indented
+json if it parses, otherwise text, or hex if it isn't text
+*/
+func FormatValue(val []byte) []byte {
+	// Attempt JSON parsing and formatting
+	out, err := FormatValueJSON(val)
+	if err == nil {
+		return out
+	}
+	return []byte(Stringify([]byte(val)))
+}
+
+// FormatValueJSON indents a json value, or returns it as it is with the error
+func FormatValueJSON(val []byte) ([]byte, error) {
+	var jsonOut interface{}
+	err := json.Unmarshal(val, &jsonOut)
+	if err != nil {
+		return val, err
+	}
+	out, err := json.MarshalIndent(jsonOut, "", "  ")
+	if err != nil {
+		return val, err
+	}
+	return out, nil
+}
+
+// Stringify ensures that we can print only valid characters.
+// It's wrong to assume that everything is a string, since BoltDB is typeless.
+func Stringify(v []byte) string {
+	if utf8.Valid(v) {
+		ok := true
+		for _, r := range string(v) {
+			if r < 0x20 {
+				ok = false
+				break
+			} else if r >= 0x7f && r <= 0x9f {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return string(v)
+		}
+	}
+	if len(v) == 8 {
+		return fmt.Sprintf("%v", binary.BigEndian.Uint64(v))
+	}
+
+	return fmt.Sprintf("%x", v)
+}
+
+// StringifyPath runs Stringify on each part of 'path', in place
+func StringifyPath(path []string) []string {
+	for k, v := range path {
+		path[k] = Stringify([]byte(v))
+	}
+	return path
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+/*
+ExtractReport is what Extract copied
+*/
+type ExtractReport struct {
+	Buckets int
+	Pairs   int
+}
+
+/*
+Extract copies the buckets and pairs at 'paths' into the new DB file 'fn',
+with the buckets they're in, so they're at the same paths in it. Buckets
+are copied with everything in them. Keys and values are copied byte for
+byte, and the sequences of the buckets (and the ones they're in) go too.
+It's for handing a small piece of a DB to someone else, so 'fn' can't
+already be there. It's all done in one transaction on each DB.
+*/
+func (b *Browser) Extract(fn string, paths [][]string) (ExtractReport, error) {
+	var report ExtractReport
+	if _, err := os.Stat(fn); err == nil {
+		return report, fmt.Errorf("%s already exists", fn)
+	}
+	// Parents sort before what's in them, so anything in a bucket
+	// that's already been copied can be skipped
+	paths = append([][]string{}, paths...)
+	sort.Slice(paths, func(i, j int) bool { return orderPaths(paths[i], paths[j]) < 0 })
+
+	dst, err := bbolt.Open(fn, 0600, nil)
+	if err != nil {
+		return report, err
+	}
+	err = b.db.View(func(src *bbolt.Tx) error {
+		return dst.Update(func(tx *bbolt.Tx) error {
+			var copied [][]string
+			for i, path := range paths {
+				if len(path) == 0 {
+					return invalidPath(path)
+				} else if path[0] == "" {
+					// The root pairs can't go anywhere but a root bucket
+					return ErrRootPair
+				}
+				if hasPrefixPath(copied, path) || (i > 0 && orderPaths(paths[i-1], path) == 0) {
+					continue
+				}
+				srcParent, dstParent, err := extractParents(src, tx, path)
+				if err != nil {
+					return err
+				}
+				name := []byte(path[len(path)-1])
+				if bkt := srcParent.Bucket(name); bkt != nil {
+					child, err := dstParent.CreateBucket(name)
+					if err != nil {
+						return err
+					}
+					if err = copyBucketCounting(child, bkt, &report); err != nil {
+						return err
+					}
+					report.Buckets++
+					copied = append(copied, path)
+				} else if v := srcParent.Get(name); v != nil {
+					if err = dstParent.Put(name, v); err != nil {
+						return err
+					}
+					report.Pairs++
+				} else {
+					return invalidPath(path)
+				}
+			}
+			return nil
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fn)
+	}
+	return report, err
+}
+
+/*
+extractParents finds the bucket the item at 'path' is in, in 'src', and
+makes the same buckets in 'dst' (with the same sequences) if they aren't
+there yet
+*/
+func extractParents(src, dst *bbolt.Tx, path []string) (*bbolt.Bucket, *bbolt.Bucket, error) {
+	srcBkt, dstBkt := src.Cursor().Bucket(), dst.Cursor().Bucket()
+	for _, nm := range path[:len(path)-1] {
+		if srcBkt = srcBkt.Bucket([]byte(nm)); srcBkt == nil {
+			return nil, nil, invalidPath(path)
+		}
+		var err error
+		if dstBkt, err = dstBkt.CreateBucketIfNotExists([]byte(nm)); err != nil {
+			return nil, nil, err
+		}
+		if err = dstBkt.SetSequence(srcBkt.Sequence()); err != nil {
+			return nil, nil, err
+		}
+	}
+	return srcBkt, dstBkt, nil
+}
+
+// copyBucketCounting is CopyBucket, counting what it copies in 'report'
+func copyBucketCounting(dst, src *bbolt.Bucket, report *ExtractReport) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			report.Pairs++
+			return dst.Put(k, v)
+		}
+		child, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		report.Buckets++
+		return copyBucketCounting(child, src.Bucket(k), report)
+	})
+}
+
+// orderPaths compares paths by their parts like strings.Compare, so a path comes right before the ones under it
+func orderPaths(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// hasPrefixPath is whether 'path' is one of 'prefixes', or under one of them
+func hasPrefixPath(prefixes [][]string, path []string) bool {
+	for _, p := range prefixes {
+		if len(p) <= len(path) && orderPaths(p, path[:len(p)]) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestExtract(t *testing.T) {
+	src := openTestDB(t, "src.db")
+	err := src.DB().Update(func(tx *bbolt.Tx) error {
+		tenants, err := tx.CreateBucket([]byte("tenants"))
+		if err != nil {
+			return err
+		}
+		tenants.SetSequence(7)
+		acme, err := tenants.CreateBucket([]byte{0xff, 'a'})
+		if err != nil {
+			return err
+		}
+		acme.SetSequence(3)
+		acme.Put([]byte{0, 1}, []byte{0xde, 0xad})
+		users, err := acme.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		users.Put([]byte("u1"), []byte("alice"))
+		tenants.Put([]byte("other"), []byte("left out"))
+		_, err = tx.CreateBucket([]byte("unrelated"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := filepath.Join(t.TempDir(), "extract.db")
+	acme := []string{"tenants", "\xffa"}
+	// The pair is in the bucket, so it's only copied once
+	report, err := src.Extract(fn, [][]string{append(acme, "users", "u1"), acme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Buckets != 2 || report.Pairs != 2 {
+		t.Errorf("copied %+v", report)
+	}
+	if _, err = src.Extract(fn, [][]string{acme}); err == nil {
+		t.Error("extracted over a file that was there")
+	}
+
+	dst, err := Open(fn, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if v, err := dst.Value(append(acme, "\x00\x01")); err != nil || string(v) != "\xde\xad" {
+		t.Errorf("the binary pair is %q, %v", v, err)
+	}
+	if seq, _ := dst.Sequence([]string{"tenants"}); seq != 7 {
+		t.Errorf("the parent's sequence is %d", seq)
+	}
+	if seq, _ := dst.Sequence(acme); seq != 3 {
+		t.Errorf("the bucket's sequence is %d", seq)
+	}
+	if _, err = dst.Value([]string{"tenants", "other"}); err == nil {
+		t.Error("a pair next to the bucket was copied")
+	}
+	if _, err = dst.Sequence([]string{"unrelated"}); err == nil {
+		t.Error("an unrelated bucket was copied")
+	}
+}
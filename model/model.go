@@ -0,0 +1,380 @@
+/*
+Package model is boltbrowser's view of a bolt DB, without any of the
+UI: reading it into a tree, finding buckets and pairs by path, making
+changes, exporting and decoding values. The boltbrowser command (the
+browser and its sub commands) is built on it.
+
+Paths are slices of bucket names, with the key last for a pair. The
+pairs in the root (which bolt doesn't really allow, but some DBs have)
+are in a bucket called "", so [""] is the root and ["", "k"] is a pair
+in it.
+*/
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrReadOnly is returned for changes to a DB that was opened read-only
+	ErrReadOnly = errors.New("DB is in Read-Only Mode")
+	// ErrInvalidPath is returned (wrapped, with the path) when a path doesn't lead anywhere
+	ErrInvalidPath = errors.New("Invalid Path")
+	// ErrRootPair is returned when putting a pair in the root, without a root bucket
+	ErrRootPair = errors.New("Cannot insert pair at root")
+)
+
+/*
+Browser wraps a bolt DB, everything it does is in its own transaction.
+It's safe to use from more than one goroutine, like the bolt DB is.
+*/
+type Browser struct {
+	db *bbolt.DB
+}
+
+/*
+Options are how Open opens a DB file
+*/
+type Options struct {
+	// ReadOnly takes a shared lock, and every change returns ErrReadOnly
+	ReadOnly bool
+	// Timeout is how long to wait for another process's lock, 0 waits forever
+	Timeout time.Duration
+}
+
+// New wraps a DB that's already open, Close still closes it
+func New(db *bbolt.DB) *Browser {
+	return &Browser{db: db}
+}
+
+/*
+Open opens the DB file 'fn'. bbolt panics if the meta or freelist pages
+are badly damaged, so that's caught and returned as an error. A file
+that's locked by another process returns bbolt.ErrTimeout.
+*/
+func Open(fn string, opts Options) (b *Browser, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b, err = nil, fmt.Errorf("DB is damaged: %v", r)
+		}
+	}()
+	db, err := bbolt.Open(fn, 0600, &bbolt.Options{Timeout: opts.Timeout, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	return New(db), nil
+}
+
+// DB is the bolt DB, for anything the Browser doesn't do
+func (b *Browser) DB() *bbolt.DB {
+	return b.db
+}
+
+// Close closes the DB
+func (b *Browser) Close() error {
+	return b.db.Close()
+}
+
+// ReadOnly is whether the DB was opened read-only
+func (b *Browser) ReadOnly() bool {
+	return b.db.IsReadOnly()
+}
+
+// update runs 'fn' in a write transaction, unless the DB is read-only
+func (b *Browser) update(fn func(tx *bbolt.Tx) error) error {
+	if b.db.IsReadOnly() {
+		return ErrReadOnly
+	}
+	return b.db.Update(fn)
+}
+
+func invalidPath(path []string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPath, strings.Join(path, "/"))
+}
+
+/*
+TxBucket follows 'path' down from the root of 'tx' to a bucket,
+an empty path (or the root pairs' "" bucket) is the root itself.
+*/
+func TxBucket(tx *bbolt.Tx, path []string) (*bbolt.Bucket, error) {
+	if len(path) == 0 || (len(path) == 1 && path[0] == "") {
+		return tx.Cursor().Bucket(), nil
+	}
+	b := tx.Bucket([]byte(path[0]))
+	for i := 1; b != nil && i < len(path); i++ {
+		b = b.Bucket([]byte(path[i]))
+	}
+	if b == nil {
+		return nil, invalidPath(path)
+	}
+	return b, nil
+}
+
+// parentBucket is the bucket that the item at 'path' is in, and the item's name
+func parentBucket(tx *bbolt.Tx, path []string) (*bbolt.Bucket, []byte, error) {
+	if len(path) == 0 {
+		return nil, nil, invalidPath(path)
+	}
+	b, err := TxBucket(tx, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, []byte(path[len(path)-1]), nil
+}
+
+// View runs 'fn' with the bucket at 'path' in a read transaction
+func (b *Browser) View(path []string, fn func(bkt *bbolt.Bucket) error) error {
+	return b.db.View(func(tx *bbolt.Tx) error {
+		bkt, err := TxBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		return fn(bkt)
+	})
+}
+
+/*
+ForEachPair calls 'fn' with each pair in the bucket at 'path' in one read
+transaction, and the pairs in the buckets under it if 'recursive' is set.
+If 'path' is a pair it's called for just that pair. 'bucket' is the path
+to the bucket the pair is in, from the root (without the root pairs' ""),
+it and the key and value are only good until 'fn' returns.
+*/
+func (b *Browser) ForEachPair(path []string, recursive bool, fn func(bucket []string, k, v []byte) error) error {
+	return b.db.View(func(tx *bbolt.Tx) error {
+		bucket := path
+		if len(bucket) > 0 && bucket[0] == "" {
+			bucket = bucket[1:]
+		}
+		if bkt, err := TxBucket(tx, path); err == nil {
+			return forEachPair(bkt, append([]string{}, bucket...), recursive, false, fn)
+		}
+		bkt, key, err := parentBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		v := bkt.Get(key)
+		if v == nil {
+			return invalidPath(path)
+		}
+		return fn(bucket[:len(bucket)-1], key, v)
+	})
+}
+
+/*
+Walk is ForEachPair for everything under the bucket at 'path', but the
+buckets are passed to 'fn' too, with a nil value, before what's in them
+(like bolt's ForEach does), so empty ones aren't left out
+*/
+func (b *Browser) Walk(path []string, fn func(bucket []string, k, v []byte) error) error {
+	return b.db.View(func(tx *bbolt.Tx) error {
+		bkt, err := TxBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		bucket := path
+		if len(bucket) > 0 && bucket[0] == "" {
+			bucket = bucket[1:]
+		}
+		return forEachPair(bkt, append([]string{}, bucket...), true, true, fn)
+	})
+}
+
+func forEachPair(bkt *bbolt.Bucket, path []string, recursive, buckets bool, fn func(bucket []string, k, v []byte) error) error {
+	c := bkt.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			if err := fn(path, k, v); err != nil {
+				return err
+			}
+		} else if recursive {
+			if buckets {
+				if err := fn(path, k, nil); err != nil {
+					return err
+				}
+			}
+			child := append(path[:len(path):len(path)], string(k))
+			if err := forEachPair(bkt.Bucket(k), child, true, buckets, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// InsertBucket creates the bucket 'name' in the bucket at 'path'
+func (b *Browser) InsertBucket(path []string, name string) error {
+	return b.update(func(tx *bbolt.Tx) error {
+		bkt, err := TxBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		_, err = bkt.CreateBucket([]byte(name))
+		return err
+	})
+}
+
+// InsertPair creates (or updates) the pair 'key' in the bucket at 'path'
+func (b *Browser) InsertPair(path []string, key, value string) error {
+	if len(path) == 0 {
+		return ErrRootPair
+	}
+	return b.update(func(tx *bbolt.Tx) error {
+		bkt, err := TxBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(key), []byte(value))
+	})
+}
+
+// SetValue sets the value of the pair at 'path', creating it if it isn't there
+func (b *Browser) SetValue(path []string, value []byte) error {
+	return b.update(func(tx *bbolt.Tx) error {
+		bkt, key, err := parentBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(key, value)
+	})
+}
+
+// RenamePair changes the key of the pair at 'path' to 'key'
+func (b *Browser) RenamePair(path []string, key string) error {
+	return b.update(func(tx *bbolt.Tx) error {
+		bkt, old, err := parentBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		v := bkt.Get(old)
+		if v == nil {
+			return invalidPath(path)
+		}
+		// v is only good until the pair is deleted
+		v = append([]byte{}, v...)
+		if err = bkt.Delete(old); err != nil {
+			return err
+		}
+		return bkt.Put([]byte(key), v)
+	})
+}
+
+/*
+RenameBucket changes the name of the bucket at 'path' to 'name'. Bolt
+can't rename buckets, so it's copied (pairs, buckets and sequences) to
+the new name and the old one is deleted, all in one transaction.
+*/
+func (b *Browser) RenameBucket(path []string, name string) error {
+	if len(path) == 0 || name == path[len(path)-1] {
+		return nil
+	}
+	return b.update(func(tx *bbolt.Tx) error {
+		src, err := TxBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		parent, old, err := parentBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		dst, err := parent.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		if err = CopyBucket(dst, src); err != nil {
+			return err
+		}
+		return parent.DeleteBucket(old)
+	})
+}
+
+// CopyBucket copies everything in 'src' into 'dst', including the sequences
+func CopyBucket(dst, src *bbolt.Bucket) error {
+	return copyBucketCounting(dst, src, &ExtractReport{})
+}
+
+// Delete deletes the pair or bucket at 'path'
+func (b *Browser) Delete(path []string) error {
+	return b.update(func(tx *bbolt.Tx) error {
+		bkt, key, err := parentBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		if bkt.Bucket(key) != nil {
+			return bkt.DeleteBucket(key)
+		}
+		return bkt.Delete(key)
+	})
+}
+
+// Value is the value of the pair at 'path'
+func (b *Browser) Value(path []string) ([]byte, error) {
+	var ret []byte
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bkt, key, err := parentBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		v := bkt.Get(key)
+		if v == nil {
+			return invalidPath(path)
+		}
+		ret = append([]byte{}, v...)
+		return nil
+	})
+	return ret, err
+}
+
+/*
+JSON is the bucket at 'path' as a json object, with its buckets as objects
+and its values as strings, or the pair at 'path' as a one field object
+*/
+func (b *Browser) JSON(path []string) (string, error) {
+	var ret string
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if bkt, err := TxBucket(tx, path); err == nil {
+			ret = BucketJSON(bkt)
+			return nil
+		}
+		bkt, key, err := parentBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		v := bkt.Get(key)
+		if v == nil {
+			return invalidPath(path)
+		}
+		ret = "{" + jsonString(key) + ":" + jsonString(v) + "}"
+		return nil
+	})
+	return ret, err
+}
+
+// Sequence is the sequence of the bucket at 'path'
+func (b *Browser) Sequence(path []string) (uint64, error) {
+	var seq uint64
+	err := b.View(path, func(bkt *bbolt.Bucket) error {
+		seq = bkt.Sequence()
+		return nil
+	})
+	return seq, err
+}
+
+// SetSequence sets the sequence of the bucket at 'path'
+func (b *Browser) SetSequence(path []string, seq uint64) error {
+	if len(path) == 0 || (len(path) == 1 && path[0] == "") {
+		return errors.New("The root doesn't have a sequence")
+	}
+	return b.update(func(tx *bbolt.Tx) error {
+		bkt, err := TxBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		return bkt.SetSequence(seq)
+	})
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// fillBrowserTestDB has users (with a sequence) with pairs, an empty bucket and orders (with a sequence too), and config
+func fillBrowserTestDB(t *testing.T) *Browser {
+	t.Helper()
+	b := openTestDB(t, "test.db")
+	err := b.DB().Update(func(tx *bbolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		users.SetSequence(5)
+		users.Put([]byte("u1"), []byte("a"))
+		users.Put([]byte("u2"), []byte("b"))
+		if _, err = users.CreateBucket([]byte("empty")); err != nil {
+			return err
+		}
+		orders, err := users.CreateBucket([]byte("orders"))
+		if err != nil {
+			return err
+		}
+		orders.SetSequence(9)
+		orders.Put([]byte("o1"), []byte("x"))
+		config, err := tx.CreateBucket([]byte("config"))
+		if err != nil {
+			return err
+		}
+		return config.Put([]byte("theme"), []byte("dark"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// walkString is what 'walk' passes to its function, buckets end in a / and pairs have their value
+func walkString(t *testing.T, walk func(fn func(bucket []string, k, v []byte) error) error) string {
+	t.Helper()
+	var ret []string
+	err := walk(func(bucket []string, k, v []byte) error {
+		s := strings.Join(append(bucket[:len(bucket):len(bucket)], string(k)), "/")
+		if v == nil {
+			s += "/"
+		} else {
+			s += "=" + string(v)
+		}
+		ret = append(ret, s)
+		return nil
+	})
+	if err != nil {
+		return err.Error()
+	}
+	return strings.Join(ret, " ")
+}
+
+func TestTxBucketRoot(t *testing.T) {
+	b := fillBrowserTestDB(t)
+	for _, path := range [][]string{nil, {}, {""}} {
+		err := b.View(path, func(bkt *bbolt.Bucket) error {
+			if bkt.Bucket([]byte("users")) == nil || bkt.Bucket([]byte("config")) == nil {
+				t.Errorf("%q isn't the root", path)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%q: %v", path, err)
+		}
+	}
+	for _, path := range [][]string{{"nope"}, {"users", "u1"}, {"", "users"}} {
+		if err := b.View(path, func(*bbolt.Bucket) error { return nil }); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("%q: expected an invalid path, got %v", path, err)
+		}
+	}
+}
+
+func TestRenameBucket(t *testing.T) {
+	b := fillBrowserTestDB(t)
+	if err := b.RenameBucket([]string{"users"}, "people"); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]uint64{"people": 5, "people/orders": 9, "people/empty": 0} {
+		if seq, err := b.Sequence(strings.Split(path, "/")); err != nil || seq != want {
+			t.Errorf("%s has the sequence %d, %v, expected %d", path, seq, err, want)
+		}
+	}
+	if v, err := b.Value([]string{"people", "orders", "o1"}); err != nil || string(v) != "x" {
+		t.Errorf("people/orders/o1 is %q, %v", v, err)
+	}
+	if _, err := b.Sequence([]string{"users"}); err == nil {
+		t.Error("users is still there")
+	}
+
+	// A name that's taken fails and changes nothing
+	before := walkString(t, func(fn func([]string, []byte, []byte) error) error { return b.Walk(nil, fn) })
+	if err := b.RenameBucket([]string{"people"}, "config"); err == nil {
+		t.Error("expected an error renaming to a bucket that's there")
+	}
+	if after := walkString(t, func(fn func([]string, []byte, []byte) error) error { return b.Walk(nil, fn) }); after != before {
+		t.Errorf("the DB is %s, it was %s", after, before)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	b := fillBrowserTestDB(t)
+	if err := b.Delete([]string{"users", "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete([]string{"users", "orders"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(nil); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("deleting the root: expected an invalid path, got %v", err)
+	}
+	if err := b.Delete([]string{"nope", "k"}); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("expected an invalid path, got %v", err)
+	}
+	got := walkString(t, func(fn func([]string, []byte, []byte) error) error { return b.Walk(nil, fn) })
+	if want := "config/ config/theme=dark users/ users/empty/ users/u2=b"; got != want {
+		t.Errorf("the DB is %s, expected %s", got, want)
+	}
+}
+
+func TestForEachPairAndWalk(t *testing.T) {
+	b := fillBrowserTestDB(t)
+	for _, tc := range []struct {
+		name string
+		walk func(fn func(bucket []string, k, v []byte) error) error
+		want string
+	}{
+		{"users", func(fn func([]string, []byte, []byte) error) error {
+			return b.ForEachPair([]string{"users"}, false, fn)
+		}, "users/u1=a users/u2=b"},
+		{"users recursive", func(fn func([]string, []byte, []byte) error) error {
+			return b.ForEachPair([]string{"users"}, true, fn)
+		}, "users/orders/o1=x users/u1=a users/u2=b"},
+		{"a pair", func(fn func([]string, []byte, []byte) error) error {
+			return b.ForEachPair([]string{"users", "u2"}, true, fn)
+		}, "users/u2=b"},
+		{"the root", func(fn func([]string, []byte, []byte) error) error {
+			return b.ForEachPair([]string{""}, true, fn)
+		}, "config/theme=dark users/orders/o1=x users/u1=a users/u2=b"},
+		{"not there", func(fn func([]string, []byte, []byte) error) error {
+			return b.ForEachPair([]string{"users", "nope"}, false, fn)
+		}, invalidPath([]string{"users", "nope"}).Error()},
+		{"walk users", func(fn func([]string, []byte, []byte) error) error {
+			return b.Walk([]string{"users"}, fn)
+		}, "users/empty/ users/orders/ users/orders/o1=x users/u1=a users/u2=b"},
+		{"walk the root", func(fn func([]string, []byte, []byte) error) error {
+			return b.Walk([]string{""}, fn)
+		}, "config/ config/theme=dark users/ users/empty/ users/orders/ users/orders/o1=x users/u1=a users/u2=b"},
+	} {
+		if got := walkString(t, tc.walk); got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.name, got, tc.want)
+		}
+	}
+}
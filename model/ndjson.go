@@ -0,0 +1,171 @@
+package model
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"go.etcd.io/bbolt"
+)
+
+// DefaultBatchSize is how many pairs ImportNDJSON writes in each transaction
+const DefaultBatchSize = 1000
+
+/*
+Record is a line of NDJSON, one pair. Path is the bucket the pair is in,
+from the root. If any of the path, key or value isn't valid utf-8 they're
+all base64 encoded, and Encoding is "base64".
+*/
+type Record struct {
+	Path     []string `json:"path"`
+	Key      string   `json:"key"`
+	Value    string   `json:"value"`
+	Encoding string   `json:"encoding,omitempty"`
+}
+
+// NewRecord makes the record for a pair, base64 encoding it if it's binary
+func NewRecord(bucket []string, k, v []byte) Record {
+	binary := !utf8.Valid(k) || !utf8.Valid(v)
+	for i := range bucket {
+		binary = binary || !utf8.ValidString(bucket[i])
+	}
+	ret := Record{Path: make([]string, len(bucket)), Key: string(k), Value: string(v)}
+	copy(ret.Path, bucket)
+	if binary {
+		enc := base64.StdEncoding.EncodeToString
+		for i := range bucket {
+			ret.Path[i] = enc([]byte(bucket[i]))
+		}
+		ret.Key, ret.Value, ret.Encoding = enc(k), enc(v), "base64"
+	}
+	return ret
+}
+
+// decode is the raw path, key and value of the record
+func (r Record) decode() ([][]byte, []byte, []byte, error) {
+	dec := func(s string) ([]byte, error) { return []byte(s), nil }
+	switch r.Encoding {
+	case "":
+	case "base64":
+		dec = base64.StdEncoding.DecodeString
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown encoding %q", r.Encoding)
+	}
+	path := make([][]byte, len(r.Path))
+	var err error
+	for i := range r.Path {
+		if path[i], err = dec(r.Path[i]); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	k, err := dec(r.Key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	v, err := dec(r.Value)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return path, k, v, nil
+}
+
+/*
+ExportNDJSON writes every pair in the bucket at 'path' (and the buckets
+under it) to 'w', a Record on each line, or just the pair if 'path' is
+a pair. It walks the DB with cursors (see ForEachPair), so it doesn't
+matter how big the bucket is. It returns the number of pairs.
+*/
+func (b *Browser) ExportNDJSON(w io.Writer, path []string) (int, error) {
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	var count int
+	err := b.ForEachPair(path, true, func(bucket []string, k, v []byte) error {
+		count++
+		return enc.Encode(NewRecord(bucket, k, v))
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, out.Flush()
+}
+
+/*
+ImportNDJSON reads Records from 'r' and puts each pair in the DB, creating
+its buckets if they aren't there. The pairs are written 'batchSize' at a
+time, each batch in its own transaction, so a failed import keeps the
+batches before it. It returns the number of pairs that were written.
+*/
+func (b *Browser) ImportNDJSON(r io.Reader, batchSize int) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	return b.importBatches(batchSize, func(tx *bbolt.Tx) (bool, error) {
+		var rec Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return true, putRecord(tx, rec)
+	})
+}
+
+/*
+importBatches calls 'next' until it returns false, to put a pair in the DB
+each time, with a new transaction every 'batchSize' pairs. It returns the
+number of pairs in the batches that were committed.
+*/
+func (b *Browser) importBatches(batchSize int, next func(tx *bbolt.Tx) (bool, error)) (int, error) {
+	if b.db.IsReadOnly() {
+		return 0, ErrReadOnly
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	var count int
+	for done := false; !done; {
+		var n int
+		err := b.db.Update(func(tx *bbolt.Tx) error {
+			for n = 0; n < batchSize; n++ {
+				more, err := next(tx)
+				if err != nil {
+					return fmt.Errorf("record %d: %w", count+n+1, err)
+				} else if !more {
+					done = true
+					return nil
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// putRecord puts the pair in 'rec' in its bucket, creating the buckets on the way
+func putRecord(tx *bbolt.Tx, rec Record) error {
+	path, k, v, err := rec.decode()
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		return ErrRootPair
+	}
+	bkt, err := tx.CreateBucketIfNotExists(path[0])
+	for i := 1; err == nil && i < len(path); i++ {
+		bkt, err = bkt.CreateBucketIfNotExists(path[i])
+	}
+	if err != nil {
+		return err
+	}
+	if len(k) == 0 {
+		return errors.New("empty key")
+	}
+	return bkt.Put(k, v)
+}
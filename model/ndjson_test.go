@@ -0,0 +1,85 @@
+package model
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func openTestDB(t *testing.T, name string) *Browser {
+	t.Helper()
+	b, err := Open(filepath.Join(t.TempDir(), name), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestNDJSONRoundTrip(t *testing.T) {
+	src := openTestDB(t, "src.db")
+	err := src.DB().Update(func(tx *bbolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c", "d", "e"} {
+			users.Put([]byte(k), []byte(`{"name":"`+k+`"}`))
+		}
+		ids, err := users.CreateBucket([]byte{0xff, 0x01})
+		if err != nil {
+			return err
+		}
+		return ids.Put([]byte{0, 0, 0, 42}, []byte("binary bucket"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	n, err := src.ExportNDJSON(&out, []string{"users"})
+	if err != nil || n != 6 {
+		t.Fatalf("exported %d pairs, %v", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if lines[0] != `{"path":["users"],"key":"a","value":"{\"name\":\"a\"}"}` {
+		t.Errorf("first line is %s", lines[0])
+	}
+	if !strings.Contains(lines[len(lines)-1], `"encoding":"base64"`) {
+		t.Errorf("the binary pair isn't base64: %s", lines[len(lines)-1])
+	}
+
+	// A batch size that doesn't divide the pairs evenly
+	dst := openTestDB(t, "dst.db")
+	if n, err = dst.ImportNDJSON(&out, 4); err != nil || n != 6 {
+		t.Fatalf("imported %d pairs, %v", n, err)
+	}
+	if v, err := dst.Value([]string{"users", "c"}); err != nil || string(v) != `{"name":"c"}` {
+		t.Errorf("users/c is %q, %v", v, err)
+	}
+	if v, err := dst.Value([]string{"users", "\xff\x01", "\x00\x00\x00\x2a"}); err != nil || string(v) != "binary bucket" {
+		t.Errorf("the binary pair is %q, %v", v, err)
+	}
+}
+
+func TestNDJSONImportKeepsEarlierBatches(t *testing.T) {
+	dst := openTestDB(t, "dst.db")
+	in := `{"path":["b"],"key":"1","value":"x"}
+{"path":["b"],"key":"2","value":"x"}
+{"path":["b"],"key":"3","value":"x"}
+{"path":["b"],"key":"4","value":"x","encoding":"rot13"}
+`
+	n, err := dst.ImportNDJSON(strings.NewReader(in), 2)
+	if err == nil || n != 2 {
+		t.Fatalf("imported %d pairs, %v", n, err)
+	}
+	if _, err = dst.Value([]string{"b", "2"}); err != nil {
+		t.Error("the first batch wasn't kept")
+	}
+	if _, err = dst.Value([]string{"b", "3"}); err == nil {
+		t.Error("the failed batch was kept")
+	}
+}
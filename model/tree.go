@@ -0,0 +1,197 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+/*
+Tree is a whole DB read into memory, basically a collection of buckets
+*/
+type Tree struct {
+	Buckets []Bucket
+}
+
+/*
+Bucket is a bucket read into memory, with everything in it
+*/
+type Bucket struct {
+	Name string
+	// Path is the path to the bucket, the last part is Name
+	Path     []string
+	Pairs    []Pair
+	Buckets  []Bucket
+	Sequence uint64
+	// IsRoot is set on the "" bucket that holds the pairs in the root
+	IsRoot bool
+}
+
+/*
+Pair is a key and value read into memory
+*/
+type Pair struct {
+	Key   string
+	Value string
+	// Bucket is the path to the bucket the pair is in
+	Bucket []string
+}
+
+var errNoBucket = errors.New("No bucket passed")
+
+/*
+ReadTree reads the whole DB into memory. If there are pairs in the root
+they're all put in one root bucket, named "". A DB that's damaged can
+make bbolt panic, that's returned as an error, with what was read so far.
+*/
+func (b *Browser) ReadTree() (*Tree, error) {
+	ret := new(Tree)
+	err := b.db.View(func(tx *bbolt.Tx) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("DB is damaged: %v", r)
+			}
+		}()
+		err = tx.ForEach(func(nm []byte, bkt *bbolt.Bucket) error {
+			bb, err := ReadBucket(bkt, []string{string(nm)})
+			if err != nil {
+				return err
+			}
+			ret.Buckets = append(ret.Buckets, *bb)
+			return nil
+		})
+		if err == errNoBucket {
+			// There are key/values directly in the root
+			ret = new(Tree)
+			bb, err := ReadBucket(tx.Cursor().Bucket(), []string{""})
+			if err != nil {
+				return err
+			}
+			bb.IsRoot = true
+			ret.Buckets = append(ret.Buckets, *bb)
+			return nil
+		}
+		return err
+	})
+	return ret, err
+}
+
+// ReadBucket reads 'bkt', which is at 'path', and everything under it into memory
+func ReadBucket(bkt *bbolt.Bucket, path []string) (*Bucket, error) {
+	if bkt == nil {
+		return nil, errNoBucket
+	}
+	ret := &Bucket{Name: path[len(path)-1], Path: path, Sequence: bkt.Sequence()}
+	err := bkt.ForEach(func(k, v []byte) error {
+		if v == nil {
+			child, err := ReadBucket(bkt.Bucket(k), append(append([]string{}, path...), string(k)))
+			if err != nil {
+				return err
+			}
+			ret.Buckets = append(ret.Buckets, *child)
+		} else {
+			ret.Pairs = append(ret.Pairs, Pair{Key: string(k), Value: string(v), Bucket: path})
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// Bucket finds the bucket at 'path'
+func (t *Tree) Bucket(path []string) (*Bucket, error) {
+	if len(path) == 0 {
+		return nil, invalidPath(path)
+	}
+	var b *Bucket
+	for i := range t.Buckets {
+		if t.Buckets[i].Name == path[0] {
+			b = &t.Buckets[i]
+			break
+		}
+	}
+	for i := 1; b != nil && i < len(path); i++ {
+		b = b.Bucket(path[i])
+	}
+	if b == nil {
+		return nil, invalidPath(path)
+	}
+	return b, nil
+}
+
+// Pair finds the pair at 'path'
+func (t *Tree) Pair(path []string) (*Pair, error) {
+	if len(path) == 0 {
+		return nil, invalidPath(path)
+	}
+	b, err := t.Bucket(path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	if p := b.Pair(path[len(path)-1]); p != nil {
+		return p, nil
+	}
+	return nil, invalidPath(path)
+}
+
+// Item finds the bucket or the pair at 'path', one of them is nil
+func (t *Tree) Item(path []string) (*Bucket, *Pair, error) {
+	if p, err := t.Pair(path); err == nil {
+		return nil, p, nil
+	}
+	b, err := t.Bucket(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, nil, nil
+}
+
+// Bucket is the bucket 'name' in this one, or nil
+func (b *Bucket) Bucket(name string) *Bucket {
+	for i := range b.Buckets {
+		if b.Buckets[i].Name == name {
+			return &b.Buckets[i]
+		}
+	}
+	return nil
+}
+
+// Pair is the pair 'key' in this bucket, or nil
+func (b *Bucket) Pair(key string) *Pair {
+	for i := range b.Pairs {
+		if b.Pairs[i].Key == key {
+			return &b.Pairs[i]
+		}
+	}
+	return nil
+}
+
+// Path is the path to this pair
+func (p *Pair) Path() []string {
+	return append(append([]string{}, p.Bucket...), p.Key)
+}
+
+/*
+BucketJSON is 'bkt' as a json object, with its buckets
+as objects and its values as strings
+*/
+func BucketJSON(bkt *bbolt.Bucket) string {
+	var parts []string
+	bkt.ForEach(func(k, v []byte) error {
+		if v == nil {
+			parts = append(parts, jsonString(k)+":"+BucketJSON(bkt.Bucket(k)))
+		} else {
+			parts = append(parts, jsonString(k)+":"+jsonString(v))
+		}
+		return nil
+	})
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// jsonString quotes 'v' as a json string, escaping whatever needs it
+func jsonString(v []byte) string {
+	out, _ := json.Marshal(string(v))
+	return string(out)
+}
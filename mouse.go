@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+// How many lines one tick of the mouse wheel moves
+const mouseWheelLines = 3
+
+func (screen *BrowserScreen) handleMouseEvent(event termbox.Event) int {
+	if screen.mode == 0 {
+		screen.mode = modeBrowse
+	}
+	if screen.mode != modeBrowse {
+		// Modals only know about keys, so turn clicks into the keys they'd mean
+		if keyEvent, ok := screen.modalMouseToKey(event); ok {
+			return screen.handleKeyEvent(keyEvent)
+		}
+		return BrowserScreenIndex
+	}
+	w, h := screenDisplay.Size()
+	tree, detail := screen.paneLayout(w, h)
+	inRightPane := detail.contains(event.MouseX, event.MouseY)
+	sideBySide := tree.rows > 0 && detail.rows > 0 && tree.y == detail.y
+	stacked := tree.rows > 0 && detail.rows > 0 && tree.y != detail.y
+
+	switch event.Key {
+	case termbox.MouseWheelUp:
+		for i := 0; i < mouseWheelLines; i++ {
+			if inRightPane {
+				screen.moveRightPaneUp()
+			} else {
+				screen.moveCursorUp()
+			}
+		}
+	case termbox.MouseWheelDown:
+		for i := 0; i < mouseWheelLines; i++ {
+			if inRightPane {
+				screen.moveRightPaneDown()
+			} else {
+				screen.moveCursorDown()
+			}
+		}
+	case termbox.MouseRelease:
+		screen.draggingSplit = false
+	case termbox.MouseLeft:
+		if screen.draggingSplit && sideBySide {
+			screen.setSplitX(event.MouseX, w)
+		} else if screen.draggingSplit && stacked {
+			screen.setSplitY(event.MouseY, h)
+		} else if event.Mod&termbox.ModMotion == termbox.ModMotion {
+			// Dragging something other than the separator
+		} else if sideBySide && event.MouseX == tree.w && event.MouseY >= tree.y {
+			screen.draggingSplit = true
+		} else if stacked && event.MouseY == tree.y+tree.rows {
+			screen.draggingSplit = true
+		} else if tree.contains(event.MouseX, event.MouseY) {
+			screen.clickLeftPane(event.MouseX, event.MouseY)
+		}
+	}
+	return BrowserScreenIndex
+}
+
+/*
+clickLeftPane selects the row that was clicked in the tree,
+clicking on a bucket's +/- marker opens or closes it
+*/
+func (screen *BrowserScreen) clickLeftPane(x, y int) {
+	row := screen.leftViewPort.topRow + y - screen.leftViewPort.firstRow
+	if y < screen.leftViewPort.firstRow || row < 0 {
+		return
+	}
+	// The buffer lines and the visible paths are built in the same order
+	visPaths, err := screen.db.buildVisiblePathSlice(screen.filter)
+	if err != nil || row >= len(visPaths) {
+		return
+	}
+	path := visPaths[row]
+	screen.currentPath = path
+	b, _, err := screen.db.getGenericFromPath(path)
+	if err == nil && b != nil {
+		markerX := len(path)*2 - screen.leftViewPort.leftCol
+		if x == markerX || x == markerX+1 {
+			screen.db.toggleOpenBucket(path)
+		}
+	}
+}
+
+/*
+modalMouseToKey turns a left click on the open modal into a key event:
+clicking the accept/cancel part of the help line means that key,
+and clicking outside the modal cancels it.
+*/
+func (screen *BrowserScreen) modalMouseToKey(event termbox.Event) (termbox.Event, bool) {
+	if event.Key != termbox.MouseLeft || event.Mod&termbox.ModMotion == termbox.ModMotion {
+		return event, false
+	}
+	if screen.mode == modeCommand {
+		// Clicking anywhere leaves the command line, like it would a modal
+		return termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}, true
+	}
+	var x, y, width, height, helpY, acceptW int
+	var accept, cancel termbox.Event
+	if screen.mode == modeDelete {
+		if screen.confirmModal == nil {
+			return event, false
+		}
+		x, y = screen.confirmModal.GetX(), screen.confirmModal.GetY()
+		width, height = screen.confirmModal.GetWidth(), screen.confirmModal.GetHeight()
+		// See ConfirmModal.Draw, help is " (Y/y) Confirm. (N/n) Reject. "
+		helpY, acceptW = y+5, len(" (Y/y) Confirm.")
+		accept = termbox.Event{Type: termbox.EventKey, Ch: 'y'}
+		cancel = termbox.Event{Type: termbox.EventKey, Ch: 'n'}
+	} else {
+		if screen.inputModal == nil {
+			return event, false
+		}
+		x, y = screen.inputModal.GetX(), screen.inputModal.GetY()
+		width, height = screen.inputModal.GetWidth(), screen.inputModal.GetHeight()
+		// See InputModal.Draw, help is " (ENTER) to Accept. (ESC) to Cancel. "
+		helpY, acceptW = y+6, len(" (ENTER) to Accept.")
+		accept = termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter}
+		cancel = termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}
+	}
+	if event.MouseX < x || event.MouseX > x+width || event.MouseY < y || event.MouseY > y+height {
+		return cancel, true
+	}
+	if event.MouseY == helpY {
+		helpLen := acceptW + len(" (N/n) Reject. ")
+		if screen.mode != modeDelete {
+			helpLen = acceptW + len(" (ESC) to Cancel. ")
+		}
+		helpX := x + width - helpLen - 1
+		if event.MouseX >= helpX && event.MouseX < helpX+acceptW {
+			return accept, true
+		} else if event.MouseX >= helpX+acceptW && event.MouseX < helpX+helpLen {
+			return cancel, true
+		}
+	}
+	return event, false
+}
+
+func (screen *AboutScreen) handleMouseEvent(event termbox.Event) int {
+	if event.Key == termbox.MouseLeft {
+		return BrowserScreenIndex
+	}
+	return AboutScreenIndex
+}
+
+func (screen *InspectorScreen) handleMouseEvent(event termbox.Event) int {
+	switch event.Key {
+	case termbox.MouseWheelUp:
+		screen.scrollRow -= mouseWheelLines
+		if screen.scrollRow < 0 {
+			screen.scrollRow = 0
+		}
+	case termbox.MouseWheelDown:
+		screen.scrollRow += mouseWheelLines
+	}
+	return InspectorScreenIndex
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/br0xen/boltbrowser/model"
+	termboxUtil "github.com/br0xen/termbox-util"
+	"github.com/itchyny/gojq"
+)
+
+// How long a query can run for before it's given up on
+const queryTimeout = 10 * time.Second
+
+// queryResult is something a query came up with for the pair 'key'
+type queryResult struct {
+	key    string
+	output string
+}
+
+/*
+queryResults are the results of the last query, they stay
+around so they can be gone through with n and N in the browser
+*/
+type queryResults struct {
+	path    []string
+	expr    string
+	results []queryResult
+	// How many pairs there were, and how many of them weren't json
+	pairs   int
+	notJSON int
+	// How many of the pairs the query failed on, and the first error
+	errors   int
+	firstErr error
+	// The selected result, n and N go on from it
+	idx int
+}
+
+/*
+runJSONQuery runs the jq expression 'expr' over the json values of 'pairs'.
+The key is in $key. An expression that comes out as true (like
+'.status == "failed"') matches the pair, false and null leave it out,
+and anything else (like '.user.email') is a result for the pair.
+*/
+func runJSONQuery(pairs []BoltPair, expr string) (*queryResults, error) {
+	q, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	code, err := gojq.Compile(q, gojq.WithVariables([]string{"$key"}))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	ret := &queryResults{expr: expr, pairs: len(pairs)}
+	for i := range pairs {
+		var val interface{}
+		// Same as formatValueJSON, values that don't parse aren't json
+		if json.Unmarshal([]byte(pairs[i].val), &val) != nil {
+			ret.notJSON++
+			continue
+		}
+		iter := code.RunWithContext(ctx, val, model.Stringify([]byte(pairs[i].key)))
+		for {
+			v, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, isErr := v.(error); isErr {
+				if ctx.Err() != nil {
+					return ret, fmt.Errorf("query took longer than %s", queryTimeout)
+				}
+				if ret.errors == 0 {
+					ret.firstErr = err
+				}
+				ret.errors++
+				break
+			}
+			switch t := v.(type) {
+			case nil:
+				continue
+			case bool:
+				if t {
+					ret.results = append(ret.results, queryResult{pairs[i].key, compactJSON(val)})
+				}
+			case string:
+				ret.results = append(ret.results, queryResult{pairs[i].key, t})
+			default:
+				ret.results = append(ret.results, queryResult{pairs[i].key, compactJSON(t)})
+			}
+		}
+	}
+	return ret, nil
+}
+
+func compactJSON(v interface{}) string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(out)
+}
+
+// summary describes how the query went, for the results screen and messages
+func (r *queryResults) summary() string {
+	ret := fmt.Sprintf("%d results from %d pairs", len(r.results), r.pairs)
+	if r.notJSON > 0 {
+		ret += fmt.Sprintf(", %d not json", r.notJSON)
+	}
+	if r.errors > 0 {
+		ret += fmt.Sprintf(", failed on %d: %s", r.errors, r.firstErr)
+	}
+	return ret
+}
+
+// startQuery asks for a jq expression to run over the bucket 'T' would show
+func (screen *BrowserScreen) startQuery() bool {
+	w, h := screenDisplay.Size()
+	inpW, inpH := (w / 2), 6
+	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+	mod.SetTitle(termboxUtil.AlignText("Query (jq)", inpW, termboxUtil.AlignCenter))
+	if screen.query != nil {
+		mod.SetValue(screen.query.expr)
+	}
+	mod.Show()
+	screen.inputModal = mod
+	screen.mode = modeQuery
+	return true
+}
+
+// runQuery runs 'expr' over the bucket and shows the results
+func (screen *BrowserScreen) runQuery(expr string) int {
+	path := screen.tableBucketPath()
+	b, err := screen.db.getBucketFromPath(path)
+	if err != nil {
+		screen.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+	results, err := runJSONQuery(b.pairs, expr)
+	if err != nil {
+		screen.setMessage("Query: " + err.Error())
+		return BrowserScreenIndex
+	}
+	results.path = path
+	screen.query = results
+	screen.sqlLast = false
+	return QueryScreenIndex
+}
+
+// queryStep goes to the next (or previous) query result in the tree
+func (screen *BrowserScreen) queryStep(dir int) bool {
+	r := screen.query
+	if r == nil || len(r.results) == 0 {
+		screen.setMessage("No query results, run a query with Q")
+		return false
+	}
+	// Go to the selected result first, if we're not already on it
+	selected := append(append([]string{}, r.path...), r.results[r.idx].key)
+	if comparePaths(screen.currentPath, selected) {
+		r.idx = (r.idx + dir + len(r.results)) % len(r.results)
+	}
+	return screen.goToQueryResult(r.idx)
+}
+
+func (screen *BrowserScreen) goToQueryResult(idx int) bool {
+	r := screen.query
+	prev := screen.currentPath
+	screen.recordJump()
+	if err := screen.goToPath(append(append([]string{}, r.path...), r.results[idx].key)); err != nil {
+		screen.currentPath = prev
+		screen.setMessage(err.Error())
+		return false
+	}
+	r.idx = idx
+	screen.setMessage(fmt.Sprintf("Result %d of %d: %s", idx+1, len(r.results), r.results[idx].output))
+	return true
+}
+
+func cmdQuery(screen *BrowserScreen, args []string) int {
+	if len(args) == 0 {
+		if screen.query == nil {
+			screen.setMessage("Usage: query <jq expression>")
+			return BrowserScreenIndex
+		}
+		// Show the last results again
+		return QueryScreenIndex
+	}
+	return screen.runQuery(strings.Join(args, " "))
+}
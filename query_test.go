@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// testPairs reads the pairs in the bucket 'name' of 'bdb', like the browser has them
+func testPairs(t *testing.T, bdb *bbolt.DB, name string) []BoltPair {
+	t.Helper()
+	var ret []BoltPair
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+			if v != nil {
+				ret = append(ret, BoltPair{key: string(k), val: string(v)})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestJSONQuery(t *testing.T) {
+	pairs := testPairs(t, openSQLTestDB(t), "users")
+	for _, tc := range []struct {
+		expr string
+		// The results, as key=output separated by ';'
+		want    string
+		summary string
+	}{
+		// Filters match the whole value
+		{`.status == "failed"`, `u2={"age":25,"name":"bob","status":"failed"}`, "1 results from 4 pairs, 1 not json"},
+		{`.age > 26 and .status == "active"`, "u1=" + `{"address":{"city":"Oslo"},"age":30,"name":"alice","status":"active"}` + ";u3=" + `{"age":35,"name":"carol","status":"active"}`, "2 results from 4 pairs, 1 not json"},
+		{`.missing`, "", "0 results from 4 pairs, 1 not json"},
+		// Projections are a result each, strings as they are
+		{`.name`, "u1=alice;u2=bob;u3=carol", "3 results from 4 pairs, 1 not json"},
+		{`.address.city`, "u1=Oslo", "1 results from 4 pairs, 1 not json"},
+		{`{n: .name, a: .age}`, `u1={"a":30,"n":"alice"};u2={"a":25,"n":"bob"};u3={"a":35,"n":"carol"}`, "3 results from 4 pairs, 1 not json"},
+		{`select(.age < 30) | $key`, "u2=u2", "1 results from 4 pairs, 1 not json"},
+		{`.name, .age`, "u1=alice;u1=30;u2=bob;u2=25;u3=carol;u3=35", "6 results from 4 pairs, 1 not json"},
+		// Errors on some pairs don't stop the query
+		{`.name + 1`, "", "0 results from 4 pairs, 1 not json, failed on 3: cannot add: string (\"alice\") and number (1)"},
+	} {
+		r, err := runJSONQuery(pairs, tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		var got []string
+		for _, res := range r.results {
+			got = append(got, res.key+"="+res.output)
+		}
+		if strings.Join(got, ";") != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.expr, strings.Join(got, ";"), tc.want)
+		}
+		if r.summary() != tc.summary {
+			t.Errorf("%s: the summary is %q, expected %q", tc.expr, r.summary(), tc.summary)
+		}
+	}
+
+	if _, err := runJSONQuery(pairs, `.name ==`); err == nil {
+		t.Error("expected an error for a query that doesn't parse")
+	}
+}
+
+func TestQueryResults(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("j l Q .name enter")
+	h.checkScreen()
+	h.press("j enter")
+	h.checkPath("users", "u2")
+	h.press("n")
+	h.checkPath("users", "u1")
+	h.press("N")
+	h.checkPath("users", "u2")
+
+	// A query that nothing matches leaves n with nowhere to go
+	h.press(": query space .nope enter q n")
+	h.checkPath("users", "u2")
+}
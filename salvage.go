@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+)
+
+// Paths of buckets that were only partially readable when we salvaged the open DB
+var salvagedBrokenPaths [][]string
+
+/*
+salvageReport is what we managed to get out of a damaged DB
+*/
+type salvageReport struct {
+	buckets int
+	pairs   int
+	broken  [][]string
+	errs    []error
+}
+
+/*
+salvager walks the pages of a damaged bolt file directly, skipping any
+branch it can't read, and copies everything it can into a fresh bolt DB
+*/
+type salvager struct {
+	pf      *boltPageFile
+	visited map[uint64]bool
+	report  salvageReport
+}
+
+// Both *bbolt.Tx and *bbolt.Bucket can have buckets created in them
+type bucketCreator interface {
+	CreateBucketIfNotExists(key []byte) (*bbolt.Bucket, error)
+}
+
+/*
+salvageDatabase copies everything readable from the damaged bolt file 'fn'
+into the new bolt file 'out', which must not already exist.
+*/
+func salvageDatabase(fn, out string) (*salvageReport, error) {
+	if _, err := os.Stat(out); err == nil {
+		return nil, fmt.Errorf("%s already exists", out)
+	}
+	pf, err := openBoltPageFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+	meta, err := pf.currentMeta()
+	if err != nil {
+		return nil, err
+	}
+	outDB, err := bbolt.Open(out, 0600, &bbolt.Options{Timeout: AppArgs.DBOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	defer outDB.Close()
+	s := &salvager{pf: pf, visited: make(map[uint64]bool)}
+	err = outDB.Update(func(tx *bbolt.Tx) error {
+		return s.copyBucket(nil, meta.root, nil, tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s.report, nil
+}
+
+/*
+salvageToTempFile salvages 'fn' into a temp file and remembers the broken
+paths so the browser can flag them. It returns the temp filename.
+*/
+func salvageToTempFile(fn string) (string, error) {
+	tmp, err := os.CreateTemp("", "boltbrowser-"+filepath.Base(fn)+"-*.salvage")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	report, err := salvageDatabase(fn, tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	salvagedBrokenPaths = report.broken
+	return tmp.Name(), nil
+}
+
+func (s *salvager) markBroken(path []string, err error) {
+	s.report.broken = append(s.report.broken, append([]string{}, path...))
+	s.report.errs = append(s.report.errs, fmt.Errorf("%v: %w", path, err))
+}
+
+/*
+copyBucket copies the bucket at 'path' (whose tree starts at page 'root',
+or is stored inline in 'value' when root is 0) into 'dst'.
+A nil path is the root of the DB, where only buckets can live.
+Only errors writing to the new DB are returned, read errors are recorded.
+*/
+func (s *salvager) copyBucket(path []string, root uint64, value []byte, dst bucketCreator) error {
+	var p *boltRawPage
+	var err error
+	if root == 0 {
+		p, err = inlineBucketPage(value)
+	} else {
+		p, err = s.readPage(root)
+	}
+	if err != nil {
+		s.markBroken(path, err)
+		return nil
+	}
+	return s.copyPage(path, p, dst)
+}
+
+func (s *salvager) readPage(id uint64) (*boltRawPage, error) {
+	if s.visited[id] {
+		return nil, fmt.Errorf("page %d is referenced more than once", id)
+	}
+	s.visited[id] = true
+	return s.pf.readPage(id)
+}
+
+func (s *salvager) copyPage(path []string, p *boltRawPage, dst bucketCreator) error {
+	if p.flags == boltBranchPageFlag {
+		elems, err := p.branchElements()
+		if err != nil {
+			s.markBroken(path, err)
+		}
+		for _, e := range elems {
+			child, err := s.readPage(e.pgid)
+			if err != nil {
+				s.markBroken(path, err)
+				continue
+			}
+			if err = s.copyPage(path, child, dst); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	elems, err := p.leafElements()
+	if err != nil {
+		s.markBroken(path, err)
+	}
+	for _, e := range elems {
+		if e.flags&boltBucketLeafFlag == boltBucketLeafFlag {
+			if err := s.copyNestedBucket(path, e, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		b, ok := dst.(*bbolt.Bucket)
+		if !ok {
+			s.markBroken(path, errors.New("skipped a pair stored directly in the root"))
+			continue
+		} else if len(e.key) == 0 {
+			// Bolt can't have one, so it's garbage
+			s.markBroken(path, errors.New("skipped a pair with an empty key"))
+			continue
+		}
+		if err := b.Put(e.key, e.value); err != nil {
+			return err
+		}
+		s.report.pairs++
+	}
+	return nil
+}
+
+func (s *salvager) copyNestedBucket(path []string, e boltLeafElement, dst bucketCreator) error {
+	bktPath := append(append([]string{}, path...), string(e.key))
+	root, seq, err := bucketHeader(e.value)
+	if err != nil {
+		s.markBroken(path, err)
+		return nil
+	} else if len(e.key) == 0 {
+		s.markBroken(path, errors.New("skipped a bucket with an empty name"))
+		return nil
+	}
+	b, err := dst.CreateBucketIfNotExists(e.key)
+	if err != nil {
+		return err
+	}
+	s.report.buckets++
+	if err = b.SetSequence(seq); err != nil {
+		return err
+	}
+	return s.copyBucket(bktPath, root, e.value, b)
+}
@@ -5,6 +5,7 @@ import "github.com/nsf/termbox-go"
 // Screen is a basic structure for all of the applications screens
 type Screen interface {
 	handleKeyEvent(event termbox.Event) int
+	handleMouseEvent(event termbox.Event) int
 	performLayout()
 	drawScreen(style Style)
 }
@@ -14,30 +15,83 @@ const (
 	BrowserScreenIndex = iota
 	// AboutScreenIndex The idx number for the 'About' Screen
 	AboutScreenIndex
+	// InspectorScreenIndex The idx number for the 'Page Inspector' Screen
+	InspectorScreenIndex
+	// TableScreenIndex The idx number for the 'Table' Screen
+	TableScreenIndex
+	// QueryScreenIndex The idx number for the 'Query Results' Screen
+	QueryScreenIndex
+	// SQLScreenIndex The idx number for the 'SQL Results' Screen
+	SQLScreenIndex
+	// ExportScreenIndex The idx number for the 'Export' dialog
+	ExportScreenIndex
 	// ExitScreenIndex The idx number for Exiting
 	ExitScreenIndex
 )
 
-func defaultScreensForData(db *BoltDB) []Screen {
-	browserScreen := BrowserScreen{db: db, rightViewPort: ViewPort{}, leftViewPort: ViewPort{}}
+func defaultScreensForData(db *BoltDB, style Style) []Screen {
+	browserScreen := BrowserScreen{db: db, style: style, rightViewPort: ViewPort{}, leftViewPort: ViewPort{},
+		splitRatio: AppConfig.SplitRatio, narrowLayout: narrowLayoutNames[AppConfig.NarrowLayout]}
+	browserScreen.restoreState()
 	aboutScreen := AboutScreen(0)
+	inspectorScreen := InspectorScreen{browser: &browserScreen, style: style}
+	tableScreen := TableScreen{browser: &browserScreen, style: style}
+	queryScreen := QueryScreen{browser: &browserScreen, style: style}
+	sqlScreen := SQLScreen{browser: &browserScreen, style: style}
+	exportScreen := ExportScreen{browser: &browserScreen, style: style}
 	screens := [...]Screen{
 		&browserScreen,
 		&aboutScreen,
+		&inspectorScreen,
+		&tableScreen,
+		&queryScreen,
+		&sqlScreen,
+		&exportScreen,
 	}
 
 	return screens[:]
 }
 
 func drawBackground(bg termbox.Attribute) {
-	termbox.Clear(0, bg)
+	screenDisplay.Clear(0, bg)
 }
 
 func layoutAndDrawScreen(screen Screen, style Style) {
 	screen.performLayout()
 	drawBackground(style.defaultBg)
 	screen.drawScreen(style)
-	termbox.Flush()
+	screenDisplay.Flush()
+}
+
+/*
+handleEvent passes a key or mouse event to 'screen' and draws the screen
+that comes next, which is returned. It's false when it's time to exit.
+*/
+func handleEvent(screens []Screen, screen Screen, event termbox.Event, style Style) (Screen, bool) {
+	var next int
+	switch event.Type {
+	case termbox.EventKey:
+		next = screen.handleKeyEvent(event)
+	case termbox.EventMouse:
+		next = screen.handleMouseEvent(event)
+	case termbox.EventResize:
+		layoutAndDrawScreen(screen, style)
+		return screen, true
+	case termbox.EventInterrupt:
+		// A key sequence has been waiting, only the browser has those
+		browser, ok := screen.(*BrowserScreen)
+		if !ok {
+			return screen, true
+		}
+		next = browser.handleKeyTimeout()
+	default:
+		return screen, true
+	}
+	if next >= len(screens) {
+		return screen, false
+	}
+	layoutAndDrawScreen(screens[next], style)
+	return screens[next], true
 }
 
 type Line struct {
@@ -2,8 +2,9 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
-	termboxUtil "github.com/br0xen/termbox-util"
+	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 )
 
@@ -20,9 +21,45 @@ AboutScreen is just a basic 'int' type that we can extend to make this screen
 */
 type AboutScreen int
 
-func drawCommandAtPoint(cmd Command, xPos int, yPos int, style Style) {
-	termboxUtil.DrawStringAtPoint(fmt.Sprintf("%6s", cmd.key), xPos, yPos, style.defaultFg, style.defaultBg)
-	termboxUtil.DrawStringAtPoint(cmd.description, xPos+8, yPos, style.defaultFg, style.defaultBg)
+/*
+helpCommands builds the two columns of help from the browser's action table,
+with a blank line between each group, so they always match the real bindings
+*/
+func helpCommands() ([]Command, []Command) {
+	var columns [2][]Command
+	lastGroup := [2]int{-1, -1}
+	for _, action := range browserActionTable {
+		col := action.helpColumn
+		if lastGroup[col] != -1 && lastGroup[col] != action.helpGroup {
+			columns[col] = append(columns[col], Command{"", ""})
+		}
+		lastGroup[col] = action.helpGroup
+		var keys []string
+		for _, k := range action.keys {
+			keys = append(keys, displayKeySequence(k))
+		}
+		columns[col] = append(columns[col], Command{strings.Join(keys, ","), action.description})
+	}
+	return columns[0], columns[1]
+}
+
+func drawCommandAtPoint(cmd Command, xPos int, yPos int, keyWidth int, style Style) {
+	drawStringAtPoint(fmt.Sprintf("%*s", keyWidth, cmd.key), xPos, yPos, style.defaultFg, style.defaultBg)
+	drawStringAtPoint(cmd.description, xPos+keyWidth+2, yPos, style.defaultFg, style.defaultBg)
+}
+
+// commandWidths gets the widest key and the widest whole line in a column of help
+func commandWidths(cmds []Command) (int, int) {
+	keyWidth, descWidth := 6, 0
+	for k := range cmds {
+		if w := runewidth.StringWidth(cmds[k].key); w > keyWidth {
+			keyWidth = w
+		}
+		if w := runewidth.StringWidth(cmds[k].description); w > descWidth {
+			descWidth = w
+		}
+	}
+	return keyWidth, keyWidth + 2 + descWidth
 }
 
 func (screen *AboutScreen) handleKeyEvent(event termbox.Event) int {
@@ -34,7 +71,7 @@ func (screen *AboutScreen) performLayout() {}
 func (screen *AboutScreen) drawScreen(style Style) {
 	defaultFg := style.defaultFg
 	defaultBg := style.defaultBg
-	width, height := termbox.Size()
+	width, height := screenDisplay.Size()
 	template := [...]string{
 		" _______  _______  ___    _______  _______  ______    _______  _     _  _______  _______  ______   ",
 		"|  _    ||       ||   |  |       ||  _    ||    _ |  |       || | _ | ||       ||       ||    _ |  ",
@@ -65,7 +102,7 @@ func (screen *AboutScreen) drawScreen(style Style) {
 		title := "BoltBrowser"
 		startY = 0
 		yPos = 0
-		termboxUtil.DrawStringAtPoint(title, (width-len(title))/2, startY, style.titleFg, style.titleBg)
+		drawStringAtPoint(title, (width-len(title))/2, startY, style.titleFg, style.titleBg)
 	} else {
 		if height < 25 {
 			startY = 0
@@ -79,7 +116,7 @@ func (screen *AboutScreen) drawScreen(style Style) {
 				if runeValue != ' ' {
 					//bg = termbox.Attribute(125)
 					displayRune = runeValue
-					termbox.SetCell(xPos, yPos, displayRune, defaultFg, bg)
+					screenDisplay.SetCell(xPos, yPos, displayRune, defaultFg, bg)
 				}
 				xPos++
 			}
@@ -88,59 +125,21 @@ func (screen *AboutScreen) drawScreen(style Style) {
 	}
 	yPos++
 	versionString := fmt.Sprintf("Version: %0.1f", VersionNum)
-	termboxUtil.DrawStringAtPoint(versionString, (width-len(versionString))/2, yPos, style.defaultFg, style.defaultBg)
-
-	commands1 := []Command{
-		{"h,←", "close parent"},
-		{"j,↓", "down"},
-		{"k,↑", "up"},
-		{"l,→", "open item"},
-		{"J", "scroll right pane down"},
-		{"K", "scroll right pane up"},
-		{"", ""},
-		{"g", "goto top"},
-		{"G", "goto bottom"},
-		{"", ""},
-		{"ctrl+f", "jump down"},
-		{"ctrl+b", "jump up"},
-	}
+	drawStringAtPoint(versionString, (width-len(versionString))/2, yPos, style.defaultFg, style.defaultBg)
 
-	commands2 := []Command{
-		{"p,P", "create pair/at parent"},
-		{"b,B", "create bucket/at parent"},
-		{"e", "edit value of pair"},
-		{"r", "rename pair/bucket"},
-		{"", ""},
-		{"D", "delete item"},
-		{"x,X", "export as string/json to file"},
-		{"i", "import file to value of pair"},
-		{"", ""},
-		{"?", "this screen"},
-		{"q", "quit program"},
-	}
-	var maxCmd1 int
-	for k := range commands1 {
-		tst := len(commands1[k].key) + 1 + len(commands1[k].description)
-		if tst > maxCmd1 {
-			maxCmd1 = tst
-		}
-	}
-	var maxCmd2 int
-	for k := range commands2 {
-		tst := len(commands2[k].key) + 1 + len(commands2[k].description)
-		if tst > maxCmd2 {
-			maxCmd2 = tst
-		}
-	}
-	xPos = (width / 2) - ((maxCmd1 + maxCmd2) / 2)
+	commands1, commands2 := helpCommands()
+	keyWidth1, maxCmd1 := commandWidths(commands1)
+	keyWidth2, maxCmd2 := commandWidths(commands2)
+	colSpace := 4
+	xPos = (width / 2) - ((maxCmd1 + colSpace + maxCmd2) / 2)
 	yPos++
 
 	for k := range commands1 {
-		drawCommandAtPoint(commands1[k], xPos, yPos+1+k, style)
+		drawCommandAtPoint(commands1[k], xPos, yPos+1+k, keyWidth1, style)
 	}
 	for k := range commands2 {
-		drawCommandAtPoint(commands2[k], xPos+40, yPos+1+k, style)
+		drawCommandAtPoint(commands2[k], xPos+maxCmd1+colSpace, yPos+1+k, keyWidth2, style)
 	}
 	exitTxt := "Press any key to return to browser"
-	termboxUtil.DrawStringAtPoint(exitTxt, (width-len(exitTxt))/2, height-1, style.titleFg, style.titleBg)
+	drawStringAtPoint(exitTxt, (width-len(exitTxt))/2, height-1, style.titleFg, style.titleBg)
 }
@@ -1,13 +1,14 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/br0xen/boltbrowser/model"
 	termboxUtil "github.com/br0xen/termbox-util"
+	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 )
 
@@ -19,6 +20,10 @@ type ViewPort struct {
 	numberOfRows int
 	firstRow     int
 	scrollRow    int
+	// The first line of the buffer that's on the screen
+	topRow int
+	// How many cells the pane is scrolled to the right
+	leftCol int
 }
 
 /*
@@ -26,9 +31,11 @@ BrowserScreen holds all that's going on :D
 */
 type BrowserScreen struct {
 	db             *BoltDB
+	style          Style
 	leftViewPort   ViewPort
 	rightViewPort  ViewPort
-	queuedCommand  string
+	queuedKeys     []string
+	queuedTime     time.Time
 	currentPath    []string
 	currentType    int
 	message        string
@@ -41,8 +48,71 @@ type BrowserScreen struct {
 
 	leftPaneBuffer  []Line
 	rightPaneBuffer []Line
+
+	// Where the panes are split, as a fraction of the width (or height when
+	// they're stacked), 0 is the default half
+	splitRatio float64
+	// Set while the separator between the panes is being dragged
+	draggingSplit bool
+	// What's shown when the terminal is too narrow to split side by side
+	narrowLayout int
+	// Long lines in the right pane are cut off instead of wrapped
+	noWrap bool
+	// The ':' prompt
+	cmdLine commandLine
+	// Per bucket settings from ':set decoder' and ':sort', by pathKey
+	decoders  map[string]string
+	sortModes map[string]string
+	// Places we've jumped from, and where we are in them (see recordJump)
+	jumps   [][]string
+	jumpIdx int
+	// When set, the next key is passed to this instead of being an action (like the name after 'm')
+	keyArgument func(screen *BrowserScreen, key string) int
+	// What's remembered about this DB between runs, see dbState()
+	state *dbState
+	// The results of the last query, from 'Q' or ':query'
+	query *queryResults
+	// The results of the last SQL query, from 'S' or ':sql'
+	sql *sqlResults
+	// Whether the SQL query was run after the query, so its results are the search results
+	sqlLast bool
+	// The export dialog, from 'x' or 'X'
+	export *exportDialog
+}
+
+// The layouts for terminals that are narrower than splitMinWidth
+const (
+	narrowTree = iota
+	narrowDetail
+	narrowStacked
+)
+
+// Names for the narrow layouts, as they're given in the config
+var narrowLayoutNames = map[string]int{
+	"tree":    narrowTree,
+	"detail":  narrowDetail,
+	"stacked": narrowStacked,
 }
 
+// splitMinWidth is the narrowest terminal that gets the panes side by side
+const splitMinWidth = 80
+
+/*
+paneRect is the area of the screen that a pane is drawn in,
+a pane that's hidden has no rows
+*/
+type paneRect struct {
+	x, y, w, rows int
+}
+
+// contains checks if the screen cell x,y is inside the pane
+func (r paneRect) contains(x, y int) bool {
+	return r.rows > 0 && x >= r.x && x < r.x+r.w && y >= r.y && y < r.y+r.rows
+}
+
+// The message shown in the footer when there's nothing else to say
+const helpMessage = "Press '?' for help"
+
 /*
 BrowserMode is just for designating the mode that we're in
 */
@@ -54,6 +124,9 @@ const (
 	modeChangeKey     = 33  // 0000 0010 0001
 	modeChangeVal     = 34  // 0000 0010 0010
 	modeFilter        = 35  // 0100 0010 0011
+	modeCommand       = 36  // 0000 0010 0100
+	modeQuery         = 37  // 0000 0010 0101
+	modeSQL           = 38  // 0000 0010 0110
 	modeInsert        = 64  // 0000 0100 0000
 	modeInsertBucket  = 65  // 0000 0100 0001
 	modeInsertPair    = 68  // 0000 0100 0100
@@ -62,9 +135,10 @@ const (
 	modeDelete        = 256 // 0001 0000 0000
 	modeModToParent   = 8   // 0000 0000 1000
 	modeIO            = 512 // 0010 0000 0000
-	modeIOExportValue = 513 // 0010 0000 0001
-	modeIOExportJSON  = 514 // 0010 0000 0010
 	modeIOImportValue = 516 // 0010 0000 0100
+	modeIOBackup      = 520 // 0010 0000 1000
+	modeIORestore     = 528 // 0010 0001 0000
+	modeIOExtract     = 513 // 0010 0000 0001
 )
 
 /*
@@ -83,6 +157,8 @@ func (screen *BrowserScreen) handleKeyEvent(event termbox.Event) int {
 	}
 	if screen.mode == modeBrowse {
 		return screen.handleBrowseKeyEvent(event)
+	} else if screen.mode == modeCommand {
+		return screen.handleCommandKeyEvent(event)
 	} else if screen.mode&modeChange == modeChange {
 		return screen.handleInputKeyEvent(event)
 	} else if screen.mode&modeInsert == modeInsert {
@@ -95,124 +171,70 @@ func (screen *BrowserScreen) handleKeyEvent(event termbox.Event) int {
 	return BrowserScreenIndex
 }
 
+/*
+handleBrowseKeyEvent looks up the action for the keys pressed so far.
+Keys are queued while they're the start of a longer binding (like 'gg').
+*/
 func (screen *BrowserScreen) handleBrowseKeyEvent(event termbox.Event) int {
-	if event.Ch == '?' {
-		// About
-		return AboutScreenIndex
-
-	} else if event.Ch == 'q' || event.Key == termbox.KeyEsc || event.Key == termbox.KeyCtrlC {
-		// Quit
-		return ExitScreenIndex
-
-	} else if event.Ch == 'g' {
-		// Jump to Beginning
-		screen.currentPath = screen.db.getNextVisiblePath(nil, screen.filter)
-
-	} else if event.Ch == 'G' {
-		// Jump to End
-		screen.currentPath = screen.db.getPrevVisiblePath(nil, screen.filter)
-
-	} else if event.Key == termbox.KeyCtrlR {
-		screen.refreshDatabase()
-
-	} else if event.Key == termbox.KeyCtrlF {
-		// Jump forward half a screen
-		_, h := termbox.Size()
-		half := h / 2
-		screen.jumpCursorDown(half)
-
-	} else if event.Key == termbox.KeyCtrlB {
-		_, h := termbox.Size()
-		half := h / 2
-		screen.jumpCursorUp(half)
-
-	} else if event.Ch == 'j' || event.Key == termbox.KeyArrowDown {
-		screen.moveCursorDown()
-
-	} else if event.Ch == 'k' || event.Key == termbox.KeyArrowUp {
-		screen.moveCursorUp()
-
-	} else if event.Ch == 'J' {
-		screen.moveRightPaneDown()
-
-	} else if event.Ch == 'K' {
-		screen.moveRightPaneUp()
-
-	} else if event.Ch == 'p' {
-		// p creates a new pair at the current level
-		screen.startInsertItem(typePair)
-	} else if event.Ch == 'P' {
-		// P creates a new pair at the parent level
-		screen.startInsertItemAtParent(typePair)
-
-	} else if event.Ch == 'b' {
-		// b creates a new bucket at the current level
-		screen.startInsertItem(typeBucket)
-	} else if event.Ch == 'B' {
-		// B creates a new bucket at the parent level
-		screen.startInsertItemAtParent(typeBucket)
-
-	} else if event.Ch == 'e' {
-		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
-		if b != nil {
-			screen.setMessage("Cannot edit a bucket, did you mean to (r)ename?")
-		} else if p != nil {
-			screen.startEditItem()
-		}
-	} else if event.Ch == '/' {
-		screen.startFilter()
-
-	} else if event.Ch == 'r' {
-		screen.startRenameItem()
-
-	} else if event.Key == termbox.KeyEnter {
-		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
-		if b != nil {
-			screen.db.toggleOpenBucket(screen.currentPath)
-		} else if p != nil {
-			screen.startEditItem()
-		}
-
-	} else if event.Ch == 'l' || event.Key == termbox.KeyArrowRight {
-		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
-		// Select the current item
-		if b != nil {
-			screen.db.toggleOpenBucket(screen.currentPath)
-		} else if p != nil {
-			screen.startEditItem()
-		} else {
-			screen.setMessage("Not sure what to do here...")
+	key := eventKeyName(event)
+	if key == "" {
+		return BrowserScreenIndex
+	}
+	if screen.keyArgument != nil {
+		fn := screen.keyArgument
+		screen.keyArgument = nil
+		screen.clearMessage()
+		if key == "esc" {
+			return BrowserScreenIndex
 		}
-
-	} else if event.Ch == 'h' || event.Key == termbox.KeyArrowLeft {
-		// If we are _on_ a bucket that's open, close it
-		b, _, e := screen.db.getGenericFromPath(screen.currentPath)
-		if e == nil && b != nil && b.expanded {
-			screen.db.closeBucket(screen.currentPath)
-		} else {
-			if len(screen.currentPath) > 1 {
-				parentBucket, err := screen.db.getBucketFromPath(screen.currentPath[:len(screen.currentPath)-1])
-				if err == nil {
-					screen.db.closeBucket(parentBucket.GetPath())
-					// Figure out how far up we need to move the cursor
-					screen.currentPath = parentBucket.GetPath()
+		return fn(screen, key)
+	}
+	queued := append(screen.queuedKeys, key)
+	action, partial := matchKeySequence(queued)
+	if partial {
+		// Wait for the rest of the sequence, showing what's been pressed,
+		// until handleKeyTimeout gives up on it
+		screen.queuedKeys = queued
+		screen.queuedTime = time.Now()
+		screen.setMessageWithTimeout(strings.Join(queued, ""), -1)
+		time.AfterFunc(keyTimeout(), screenDisplay.Interrupt)
+		return BrowserScreenIndex
+	}
+	if len(screen.queuedKeys) > 0 {
+		screen.queuedKeys = nil
+		screen.clearMessage()
+		if action == nil {
+			// Not a sequence after all, run what was queued (if it was complete)
+			// and start over with this key
+			if queuedAction, _ := matchKeySequence(queued[:len(queued)-1]); queuedAction != nil {
+				if ret := queuedAction.run(screen); ret != BrowserScreenIndex || screen.mode != modeBrowse {
+					return ret
 				}
-			} else {
-				screen.db.closeBucket(screen.currentPath)
 			}
+			return screen.handleBrowseKeyEvent(event)
 		}
+	}
+	if action != nil {
+		return action.run(screen)
+	}
+	return BrowserScreenIndex
+}
 
-	} else if event.Ch == 'D' {
-		screen.startDeleteItem()
-	} else if event.Ch == 'x' {
-		// Export Value to a file
-		screen.startExportValue()
-	} else if event.Ch == 'X' {
-		// Export Key/Value (or Bucket) as JSON
-		screen.startExportJSON()
-	} else if event.Ch == 'i' {
-		// Import value from a file
-		screen.startImportValue()
+/*
+handleKeyTimeout is called when the main loop is woken up after a key
+sequence has been waiting for keyTimeout. Like vim's timeoutlen, the keys
+so far run their own binding if they have one, otherwise they're dropped.
+*/
+func (screen *BrowserScreen) handleKeyTimeout() int {
+	if len(screen.queuedKeys) == 0 || time.Since(screen.queuedTime) < keyTimeout() {
+		// It's for keys that were finished, or ones that have been pressed since
+		return BrowserScreenIndex
+	}
+	queued := screen.queuedKeys
+	screen.queuedKeys = nil
+	screen.clearMessage()
+	if action, _ := matchKeySequence(queued); action != nil {
+		return action.run(screen)
 	}
 	return BrowserScreenIndex
 }
@@ -223,11 +245,25 @@ func (screen *BrowserScreen) handleInputKeyEvent(event termbox.Event) int {
 		screen.inputModal.Clear()
 	} else {
 		screen.inputModal.HandleEvent(event)
+		if screen.inputModal.IsDone() && screen.mode == modeQuery {
+			expr := screen.inputModal.GetValue()
+			screen.mode = modeBrowse
+			screen.inputModal.Clear()
+			return screen.runQuery(expr)
+		}
+		if screen.inputModal.IsDone() && screen.mode == modeSQL {
+			text := screen.inputModal.GetValue()
+			screen.mode = modeBrowse
+			screen.inputModal.Clear()
+			return screen.runSQL(text)
+		}
 		if screen.inputModal.IsDone() {
 			b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
 			if screen.mode == modeFilter {
 				screen.filter = screen.inputModal.GetValue()
 				if !screen.db.isVisiblePath(screen.currentPath, screen.filter) {
+					// The filter moved the cursor, so going back goes to where it was
+					screen.recordJump()
 					screen.currentPath = screen.currentPath[:len(screen.currentPath)-1]
 				}
 			}
@@ -276,31 +312,8 @@ func (screen *BrowserScreen) handleDeleteKeyEvent(event termbox.Event) int {
 	screen.confirmModal.HandleEvent(event)
 	if screen.confirmModal.IsDone() {
 		if screen.confirmModal.IsAccepted() {
-			holdNextPath := screen.db.getNextVisiblePath(screen.currentPath, screen.filter)
-			holdPrevPath := screen.db.getPrevVisiblePath(screen.currentPath, screen.filter)
-			if deleteKey(screen.currentPath) == nil {
-				screen.refreshDatabase()
-				// Move the current path endpoint appropriately
-				//found_new_path := false
-				if holdNextPath != nil {
-					if len(holdNextPath) > 2 {
-						if holdNextPath[len(holdNextPath)-2] == screen.currentPath[len(screen.currentPath)-2] {
-							screen.currentPath = holdNextPath
-						} else if holdPrevPath != nil {
-							screen.currentPath = holdPrevPath
-						} else {
-							// Otherwise, go to the parent
-							screen.currentPath = screen.currentPath[:(len(holdNextPath) - 2)]
-						}
-					} else {
-						// Root bucket deleted, set to next
-						screen.currentPath = holdNextPath
-					}
-				} else if holdPrevPath != nil {
-					screen.currentPath = holdPrevPath
-				} else {
-					screen.currentPath = screen.currentPath[:0]
-				}
+			if err := screen.deleteCurrentItem(); err != nil {
+				screen.setMessage(err.Error())
 			}
 		}
 		screen.mode = modeBrowse
@@ -309,6 +322,37 @@ func (screen *BrowserScreen) handleDeleteKeyEvent(event termbox.Event) int {
 	return BrowserScreenIndex
 }
 
+// deleteCurrentItem deletes the selected item and moves the cursor to what's next to it
+func (screen *BrowserScreen) deleteCurrentItem() error {
+	holdNextPath := screen.db.getNextVisiblePath(screen.currentPath, screen.filter)
+	holdPrevPath := screen.db.getPrevVisiblePath(screen.currentPath, screen.filter)
+	if err := deleteKey(screen.currentPath); err != nil {
+		return err
+	}
+	screen.refreshDatabase()
+	// Move the current path endpoint appropriately
+	if holdNextPath != nil {
+		if len(holdNextPath) > 2 {
+			if holdNextPath[len(holdNextPath)-2] == screen.currentPath[len(screen.currentPath)-2] {
+				screen.currentPath = holdNextPath
+			} else if holdPrevPath != nil {
+				screen.currentPath = holdPrevPath
+			} else {
+				// Otherwise, go to the parent
+				screen.currentPath = screen.currentPath[:(len(holdNextPath) - 2)]
+			}
+		} else {
+			// Root bucket deleted, set to next
+			screen.currentPath = holdNextPath
+		}
+	} else if holdPrevPath != nil {
+		screen.currentPath = holdPrevPath
+	} else {
+		screen.currentPath = screen.currentPath[:0]
+	}
+	return nil
+}
+
 func (screen *BrowserScreen) handleInsertKeyEvent(event termbox.Event) int {
 	if event.Key == termbox.KeyEsc {
 		if len(screen.db.buckets) == 0 {
@@ -385,26 +429,27 @@ func (screen *BrowserScreen) handleIOKeyEvent(event termbox.Event) int {
 	} else {
 		screen.inputModal.HandleEvent(event)
 		if screen.inputModal.IsDone() {
-			b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
+			_, p, _ := screen.db.getGenericFromPath(screen.currentPath)
 			fileName := screen.inputModal.GetValue()
-			if screen.mode&modeIOExportValue == modeIOExportValue {
-				// Exporting the value
-				if p != nil {
-					if err := exportValue(screen.currentPath, fileName); err != nil {
-						//screen.setMessage("Error exporting to file " + fileName + ".")
-						screen.setMessage(err.Error())
-					} else {
-						screen.setMessage("Value exported to file: " + fileName)
-					}
+			if screen.mode&modeIOBackup == modeIOBackup {
+				if n, err := backupDatabase(db, fileName, strings.HasSuffix(fileName, ".gz")); err != nil {
+					screen.setMessage(err.Error())
+				} else {
+					screen.setMessage(fmt.Sprintf("Backed up %d bytes to file: %s", n, fileName))
 				}
-			} else if screen.mode&modeIOExportJSON == modeIOExportJSON {
-				if b != nil || p != nil {
-					if exportJSON(screen.currentPath, fileName) != nil {
-						screen.setMessage("Error exporting to file " + fileName + ".")
-					} else {
-						screen.setMessage("Value exported to file: " + fileName)
+			} else if screen.mode&modeIOExtract == modeIOExtract {
+				screen.extractTo([][]string{screen.currentPath}, fileName)
+			} else if screen.mode&modeIORestore == modeIORestore {
+				if err := restoreOpenDatabase(fileName); err != nil {
+					if _, ok := err.(errReopen); ok {
+						exitError = err
+						return ExitScreenIndex
 					}
+					screen.setMessage(err.Error())
+				} else {
+					screen.setMessage("DB restored from file: " + fileName)
 				}
+				screen.refreshDatabase()
 			} else if screen.mode&modeIOImportValue == modeIOImportValue {
 				if p != nil {
 					if err := importValue(screen.currentPath, fileName); err != nil {
@@ -423,69 +468,31 @@ func (screen *BrowserScreen) handleIOKeyEvent(event termbox.Event) int {
 }
 
 func (screen *BrowserScreen) jumpCursorUp(distance int) bool {
-	// Jump up 'distance' lines
-	visPaths, err := screen.db.buildVisiblePathSlice(screen.filter)
-	if err == nil {
-		findPath := screen.currentPath
-		for idx, pth := range visPaths {
-			startJump := true
-			for i := range pth {
-				if len(screen.currentPath) > i && pth[i] != screen.currentPath[i] {
-					startJump = false
-				}
-			}
-			if startJump {
-				distance--
-				if distance == 0 {
-					screen.currentPath = visPaths[len(visPaths)-1-idx]
-					break
-				}
-			}
-		}
-		isCurPath := true
-		for i := range screen.currentPath {
-			if screen.currentPath[i] != findPath[i] {
-				isCurPath = false
-				break
-			}
-		}
-		if isCurPath {
-			screen.currentPath = screen.db.getNextVisiblePath(nil, screen.filter)
-		}
-	}
-	return true
+	return screen.jumpCursor(-distance)
 }
 func (screen *BrowserScreen) jumpCursorDown(distance int) bool {
-	visPaths, err := screen.db.buildVisiblePathSlice(screen.filter)
-	if err == nil {
-		findPath := screen.currentPath
-		for idx, pth := range visPaths {
-			startJump := true
+	return screen.jumpCursor(distance)
+}
 
-			for i := range pth {
-				if len(screen.currentPath) > i && pth[i] != screen.currentPath[i] {
-					startJump = false
-				}
-			}
-			if startJump {
-				distance--
-				if distance == 0 {
-					screen.currentPath = visPaths[idx]
-					break
-				}
-			}
-		}
-		isCurPath := true
-		for i := range screen.currentPath {
-			if screen.currentPath[i] != findPath[i] {
-				isCurPath = false
-				break
-			}
-		}
-		if isCurPath {
-			screen.currentPath = screen.db.getNextVisiblePath(nil, screen.filter)
+// jumpCursor moves the cursor 'distance' visible lines, stopping at the top and bottom
+func (screen *BrowserScreen) jumpCursor(distance int) bool {
+	visPaths, err := screen.db.buildVisiblePathSlice(screen.filter)
+	if err != nil || len(visPaths) == 0 {
+		return false
+	}
+	idx := 0
+	for i := range visPaths {
+		if comparePaths(visPaths[i], screen.currentPath) {
+			idx = i + distance
+			break
 		}
 	}
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(visPaths) {
+		idx = len(visPaths) - 1
+	}
+	screen.currentPath = visPaths[idx]
 	return true
 }
 
@@ -517,6 +524,25 @@ func (screen *BrowserScreen) moveRightPaneDown() bool {
 	return true
 }
 
+// How many cells the tree moves when it's scrolled sideways
+const treeScrollCols = 8
+
+func (screen *BrowserScreen) scrollTreeLeft() bool {
+	if screen.leftViewPort.leftCol > 0 {
+		screen.leftViewPort.leftCol -= treeScrollCols
+		if screen.leftViewPort.leftCol < 0 {
+			screen.leftViewPort.leftCol = 0
+		}
+		return true
+	}
+	return false
+}
+func (screen *BrowserScreen) scrollTreeRight() bool {
+	// drawLeftPane stops it at the end of the longest line
+	screen.leftViewPort.leftCol += treeScrollCols
+	return true
+}
+
 func (screen *BrowserScreen) performLayout() {}
 
 func (screen *BrowserScreen) drawScreen(style Style) {
@@ -527,11 +553,15 @@ func (screen *BrowserScreen) drawScreen(style Style) {
 		return
 	}
 	if len(screen.db.buckets) == 0 && screen.mode&modeInsertBucket != modeInsertBucket {
-		// Force a bucket insert
-		screen.startInsertItemAtParent(typeBucket)
+		if AppArgs.ReadOnly {
+			screen.setMessageWithTimeout("DB is empty and opened Read-Only. Press 'q' to quit", -1)
+		} else {
+			// Force a bucket insert
+			screen.startInsertItemAtParent(typeBucket)
+		}
 	}
 	if screen.message == "" {
-		screen.setMessageWithTimeout("Press '?' for help", -1)
+		screen.setMessageWithTimeout(helpMessage, -1)
 	}
 	screen.drawLeftPane(style)
 	screen.drawRightPane(style)
@@ -539,37 +569,71 @@ func (screen *BrowserScreen) drawScreen(style Style) {
 	screen.drawFooter(style)
 
 	if screen.inputModal != nil {
-		screen.inputModal.Draw()
+		drawInputModal(screen.inputModal)
 	}
 	if screen.mode == modeDelete {
-		screen.confirmModal.Draw()
+		drawConfirmModal(screen.confirmModal)
 	}
 }
 
 func (screen *BrowserScreen) drawHeader(style Style) {
-	width, _ := termbox.Size()
+	width, _ := screenDisplay.Size()
+	headerFlags := ""
+	if AppArgs.Salvage {
+		headerFlags = " [RO SALVAGE]"
+	} else if AppArgs.Snapshot {
+		headerFlags = " [RO SNAPSHOT]"
+	} else if AppArgs.ReadOnly {
+		headerFlags = " [RO]"
+	}
 	headerStringLen := func(fileName string) int {
-		return len(ProgramName) + len(fileName) + 1
+		return len(ProgramName) + len(fileName) + len(headerFlags) + 1
 	}
 	headerFileName := currentFilename
 	if headerStringLen(headerFileName) > width {
 		headerFileName = filepath.Base(headerFileName)
 	}
-	headerString := ProgramName + ": " + headerFileName
+	headerString := ProgramName + ": " + headerFileName + headerFlags
 	count := ((width - len(headerString)) / 2) + 1
 	if count < 0 {
 		count = 0
 	}
 	spaces := strings.Repeat(" ", count)
-	termboxUtil.DrawStringAtPoint(fmt.Sprintf("%s%s%s", spaces, headerString, spaces), 0, 0, style.titleFg, style.titleBg)
+	drawStringAtPoint(fmt.Sprintf("%s%s%s", spaces, headerString, spaces), 0, 0, style.titleFg, style.titleBg)
 }
 
 func (screen *BrowserScreen) drawFooter(style Style) {
 	if screen.messageTimeout > 0 && time.Since(screen.messageTime) > screen.messageTimeout {
 		screen.clearMessage()
 	}
-	_, height := termbox.Size()
-	termboxUtil.DrawStringAtPoint(screen.message, 0, height-1, style.defaultFg, style.defaultBg)
+	width, height := screenDisplay.Size()
+	fillWithChar(' ', 0, height-1, width, height-1, style.footerFg, style.footerBg)
+	if screen.mode == modeCommand {
+		screen.drawCommandLine(style)
+		return
+	}
+	msg := screen.message
+	// The selected key goes on the right, it's more use than the help hint
+	key := screen.selectedKeyText()
+	if msg == helpMessage && runewidth.StringWidth(msg)+runewidth.StringWidth(key)+2 > width {
+		msg = ""
+	}
+	msgW := drawStringClipped(msg, 0, height-1, 0, width, style.footerFg, style.footerBg)
+	if msgW > 0 {
+		msgW += 2
+	}
+	if key != "" && width-msgW > 1 {
+		key = truncateLeft(key, width-msgW-1)
+		drawStringClipped(key, width-runewidth.StringWidth(key)-1, height-1, 0, width, style.footerFg, style.footerBg)
+	}
+}
+
+// selectedKeyText is the whole key (or bucket name) of the item under the cursor
+func (screen *BrowserScreen) selectedKeyText() string {
+	if len(screen.currentPath) == 0 {
+		return ""
+	}
+	return model.Stringify([]byte(screen.currentPath[len(screen.currentPath)-1]))
 }
 
 func (screen *BrowserScreen) buildLeftPane(style Style) {
@@ -582,7 +646,7 @@ func (screen *BrowserScreen) buildLeftPane(style Style) {
 	}
 	// Find the cursor in the leftPane
 	for k, v := range screen.leftPaneBuffer {
-		if v.Fg == style.cursorFg {
+		if v.Fg == style.cursorFg && v.Bg == style.cursorBg {
 			screen.leftViewPort.scrollRow = k
 			break
 		}
@@ -591,24 +655,38 @@ func (screen *BrowserScreen) buildLeftPane(style Style) {
 
 func (screen *BrowserScreen) drawLeftPane(style Style) {
 	screen.buildLeftPane(style)
-	w, h := termbox.Size()
-	if w > 80 {
-		w = w / 2
+	w, h := screenDisplay.Size()
+	tree, _ := screen.paneLayout(w, h)
+	fillWithChar('=', 0, 1, w, 1, style.treeFg, style.defaultBg)
+	screen.leftViewPort.bytesPerRow = tree.w
+	screen.leftViewPort.numberOfRows = tree.rows
+	screen.leftViewPort.firstRow = tree.y
+	if tree.rows <= 0 {
+		return
 	}
-	screen.leftViewPort.bytesPerRow = w
-	screen.leftViewPort.numberOfRows = h - 2
-	termboxUtil.FillWithChar('=', 0, 1, w, 1, style.defaultFg, style.defaultBg)
-	startX, startY := 0, 3
-	screen.leftViewPort.firstRow = startY
 	treeOffset := 0
 	maxCursor := screen.leftViewPort.numberOfRows * 2 / 3
 
 	if screen.leftViewPort.scrollRow > maxCursor {
 		treeOffset = screen.leftViewPort.scrollRow - maxCursor
 	}
+	screen.leftViewPort.topRow = treeOffset
+	// Don't let it scroll further right than the longest line needs
+	maxCol := 0
+	for _, v := range screen.leftPaneBuffer {
+		if lw := runewidth.StringWidth(v.Text) - tree.w + 1; lw > maxCol {
+			maxCol = lw
+		}
+	}
+	if screen.leftViewPort.leftCol > maxCol {
+		screen.leftViewPort.leftCol = maxCol
+	}
 	if len(screen.leftPaneBuffer) > 0 {
 		for k, v := range screen.leftPaneBuffer[treeOffset:] {
-			termboxUtil.DrawStringAtPoint(v.Text, startX, (startY + k - 1), v.Fg, v.Bg)
+			if k >= tree.rows {
+				break
+			}
+			drawStringClipped(v.Text, tree.x, tree.y+k, screen.leftViewPort.leftCol, tree.w, v.Fg, v.Bg)
 		}
 	}
 }
@@ -619,24 +697,42 @@ func (screen *BrowserScreen) buildRightPane(style Style) {
 	if err == nil {
 		if b != nil {
 			screen.rightPaneBuffer = append(screen.rightPaneBuffer,
-				Line{fmt.Sprintf("Path: %s", strings.Join(stringifyPath(b.GetPath()), " → ")), style.defaultFg, style.defaultBg})
+				Line{fmt.Sprintf("Path: %s", strings.Join(model.StringifyPath(b.GetPath()), " → ")), style.defaultFg, style.defaultBg})
 			screen.rightPaneBuffer = append(screen.rightPaneBuffer,
 				Line{fmt.Sprintf("Buckets: %d", len(b.buckets)), style.defaultFg, style.defaultBg})
 			screen.rightPaneBuffer = append(screen.rightPaneBuffer,
 				Line{fmt.Sprintf("Pairs: %d", len(b.pairs)), style.defaultFg, style.defaultBg})
+			if sortMode := screen.sortModeFor(b.GetPath()); sortMode != defaultSortMode {
+				screen.rightPaneBuffer = append(screen.rightPaneBuffer,
+					Line{fmt.Sprintf("Sorted by: %s", sortMode), style.defaultFg, style.defaultBg})
+			}
+			if b.errorFlag {
+				screen.rightPaneBuffer = append(screen.rightPaneBuffer,
+					Line{"Part of this bucket couldn't be read, what's shown is incomplete", style.errorFg, style.errorBg})
+			}
 		} else if p != nil {
 			screen.rightPaneBuffer = append(screen.rightPaneBuffer,
-				Line{fmt.Sprintf("Path: %s", strings.Join(stringifyPath(p.GetPath()), " → ")), style.defaultFg, style.defaultBg})
+				Line{fmt.Sprintf("Path: %s", strings.Join(model.StringifyPath(p.GetPath()), " → ")), style.defaultFg, style.defaultBg})
 			screen.rightPaneBuffer = append(screen.rightPaneBuffer,
-				Line{fmt.Sprintf("Key: %s", stringify([]byte(p.key))), style.defaultFg, style.defaultBg})
+				Line{fmt.Sprintf("Key: %s", model.Stringify([]byte(p.key))), style.defaultFg, style.defaultBg})
 
-			value := strings.Split(string(formatValue([]byte(p.val))), "\n")
+			label := "Value"
+			decoded, err := screen.decodeValue(p)
+			if name := screen.decoderFor(p.GetPath()); name != model.DefaultDecoder {
+				label = fmt.Sprintf("Value (%s)", name)
+			}
+			if err != nil {
+				screen.rightPaneBuffer = append(screen.rightPaneBuffer,
+					Line{fmt.Sprintf("Can't decode as %s: %s", screen.decoderFor(p.GetPath()), err), style.errorFg, style.errorBg})
+				label = "Value"
+			}
+			value := strings.Split(decoded, "\n")
 			if len(value) == 1 {
 				screen.rightPaneBuffer = append(screen.rightPaneBuffer,
-					Line{fmt.Sprintf("Value: %s", value[0]), style.defaultFg, style.defaultBg})
+					Line{fmt.Sprintf("%s: %s", label, value[0]), style.defaultFg, style.defaultBg})
 			} else {
 				screen.rightPaneBuffer = append(screen.rightPaneBuffer,
-					Line{"Value:", style.defaultFg, style.defaultBg})
+					Line{label + ":", style.defaultFg, style.defaultBg})
 				for _, v := range value {
 					screen.rightPaneBuffer = append(screen.rightPaneBuffer,
 						Line{v, style.defaultFg, style.defaultBg})
@@ -645,72 +741,146 @@ func (screen *BrowserScreen) buildRightPane(style Style) {
 		}
 	} else {
 		screen.rightPaneBuffer = append(screen.rightPaneBuffer,
-			Line{fmt.Sprintf("Path: %s", strings.Join(stringifyPath(screen.currentPath), " → ")), style.defaultFg, style.defaultBg})
+			Line{fmt.Sprintf("Path: %s", strings.Join(model.StringifyPath(screen.currentPath), " → ")), style.defaultFg, style.defaultBg})
 		screen.rightPaneBuffer = append(screen.rightPaneBuffer,
-			Line{err.Error(), termbox.ColorRed, termbox.ColorBlack})
+			Line{err.Error(), style.errorFg, style.errorBg})
 	}
 }
 
 func (screen *BrowserScreen) drawRightPane(style Style) {
 	screen.buildRightPane(style)
-	w, h := termbox.Size()
-	if w > 80 {
-		screen.rightViewPort.bytesPerRow = w / 2
-		screen.rightViewPort.numberOfRows = h - 2
-		// Screen is wide enough, split it
-		termboxUtil.FillWithChar('=', 0, 1, w, 1, style.defaultFg, style.defaultBg)
-		termboxUtil.FillWithChar('|', (w / 2), screen.rightViewPort.firstRow-1, (w / 2), h, style.defaultFg, style.defaultBg)
-		// Clear the right pane
-		termboxUtil.FillWithChar(' ', (w/2)+1, screen.rightViewPort.firstRow+2, w, h, style.defaultFg, style.defaultBg)
-
-		startX := (w / 2) + 2
-		startY := 3
-		maxScroll := len(screen.rightPaneBuffer) - screen.rightViewPort.numberOfRows
-		if maxScroll < 0 {
-			maxScroll = 0
-		}
-		if screen.rightViewPort.scrollRow > maxScroll {
-			screen.rightViewPort.scrollRow = maxScroll
-		}
-		if len(screen.rightPaneBuffer) > 0 {
-			for k, v := range screen.rightPaneBuffer[screen.rightViewPort.scrollRow:] {
-				termboxUtil.DrawStringAtPoint(v.Text, startX, (startY + k - 1), v.Fg, v.Bg)
+	w, h := screenDisplay.Size()
+	tree, detail := screen.paneLayout(w, h)
+	screen.rightViewPort.bytesPerRow = detail.w
+	screen.rightViewPort.numberOfRows = detail.rows
+	screen.rightViewPort.firstRow = detail.y
+	if detail.rows <= 0 {
+		return
+	}
+	if tree.rows > 0 && tree.y == detail.y {
+		// Side by side, the separator is just left of the detail pane
+		fillWithChar('|', detail.x-2, detail.y, detail.x-2, h-2, style.treeFg, style.defaultBg)
+	} else if tree.rows > 0 {
+		// Stacked, the separator is just above it
+		fillWithChar('=', 0, detail.y-1, w, detail.y-1, style.treeFg, style.defaultBg)
+	}
+	// Clear the right pane
+	fillWithChar(' ', detail.x-1, detail.y, w, detail.y+detail.rows-1, style.defaultFg, style.defaultBg)
+	if !screen.noWrap {
+		screen.rightPaneBuffer = wrapLines(screen.rightPaneBuffer, detail.w-1)
+	}
+
+	maxScroll := len(screen.rightPaneBuffer) - screen.rightViewPort.numberOfRows
+	if maxScroll < 0 {
+		maxScroll = 0
+	}
+	if screen.rightViewPort.scrollRow > maxScroll {
+		screen.rightViewPort.scrollRow = maxScroll
+	}
+	if len(screen.rightPaneBuffer) > 0 {
+		for k, v := range screen.rightPaneBuffer[screen.rightViewPort.scrollRow:] {
+			if k >= detail.rows {
+				break
 			}
+			drawStringClipped(v.Text, detail.x, detail.y+k, 0, detail.w-1, v.Fg, v.Bg)
 		}
 	}
 }
 
-func formatValue(val []byte) []byte {
-	// Attempt JSON parsing and formatting
-	out, err := formatValueJSON(val)
-	if err == nil {
-		return out
+/*
+paneLayout works out where the tree and the detail pane go.
+Wide terminals get them side by side, split at splitRatio. Narrow ones show
+either one of them full width or both stacked, depending on narrowLayout.
+The rows between the header line and the footer are what the panes can use.
+*/
+func (screen *BrowserScreen) paneLayout(width, height int) (paneRect, paneRect) {
+	top, rows := 2, height-3
+	if width > splitMinWidth {
+		split := screen.splitX(width)
+		return paneRect{0, top, split, rows}, paneRect{split + 2, top, width - split - 2, rows}
+	}
+	switch screen.narrowLayout {
+	case narrowDetail:
+		return paneRect{}, paneRect{1, top, width - 1, rows}
+	case narrowStacked:
+		treeRows := screen.splitY(height)
+		return paneRect{0, top, width, treeRows}, paneRect{1, top + treeRows + 1, width - 1, rows - treeRows - 1}
 	}
-	return []byte(stringify([]byte(val)))
+	return paneRect{0, top, width, rows}, paneRect{}
 }
 
-func formatValueJSON(val []byte) ([]byte, error) {
-	var jsonOut interface{}
-	err := json.Unmarshal(val, &jsonOut)
-	if err != nil {
-		return val, err
+// splitRatioOrDefault is the split ratio with 0 meaning half
+func (screen *BrowserScreen) splitRatioOrDefault() float64 {
+	if screen.splitRatio <= 0 {
+		return 0.5
 	}
-	out, err := json.MarshalIndent(jsonOut, "", "  ")
-	if err != nil {
-		return val, err
+	return screen.splitRatio
+}
+
+// splitX is the column of the separator between the panes when they're side by side
+func (screen *BrowserScreen) splitX(width int) int {
+	return int(float64(width) * screen.splitRatioOrDefault())
+}
+
+// splitY is how many rows the tree gets when the panes are stacked
+func (screen *BrowserScreen) splitY(height int) int {
+	rows := height - 3
+	treeRows := int(float64(rows) * screen.splitRatioOrDefault())
+	if treeRows < 1 {
+		treeRows = 1
+	} else if treeRows > rows-2 {
+		treeRows = rows - 2
 	}
-	return out, nil
+	return treeRows
+}
+
+// setSplitX moves the separator between side by side panes to column 'x'
+func (screen *BrowserScreen) setSplitX(x, width int) {
+	screen.setSplitRatio(float64(x) / float64(width))
+}
+
+// setSplitY moves the separator between stacked panes to row 'y'
+func (screen *BrowserScreen) setSplitY(y, height int) {
+	screen.setSplitRatio(float64(y-2) / float64(height-3))
+}
+
+// setSplitRatio sets where the panes are split, keeping both of them usable
+func (screen *BrowserScreen) setSplitRatio(ratio float64) {
+	if ratio < 0.1 {
+		ratio = 0.1
+	} else if ratio > 0.9 {
+		ratio = 0.9
+	}
+	screen.splitRatio = ratio
+}
+
+// moveSplit grows (or shrinks, with a negative 'by') the tree's share of the screen
+func (screen *BrowserScreen) moveSplit(by float64) bool {
+	w, _ := screenDisplay.Size()
+	if w <= splitMinWidth && screen.narrowLayout != narrowStacked {
+		screen.setMessage("The panes are only split on wide terminals, or when they're stacked")
+		return false
+	}
+	screen.setSplitRatio(screen.splitRatioOrDefault() + by)
+	return true
 }
 
 func (screen *BrowserScreen) bucketToLines(bkt *BoltBucket, style Style) []Line {
 	var ret []Line
-	bfg, bbg := style.defaultFg, style.defaultBg
+	bfg, bbg := style.bucketFg, style.defaultBg
+	if bkt.errorFlag {
+		bfg, bbg = style.errorFg, style.errorBg
+	}
 	if comparePaths(screen.currentPath, bkt.GetPath()) {
 		bfg, bbg = style.cursorFg, style.cursorBg
 	}
 	bktPrefix := strings.Repeat(" ", len(bkt.GetPath())*2)
+	bktName := model.Stringify([]byte(bkt.name))
+	if bkt.errorFlag {
+		bktName = bktName + " (!)"
+	}
 	if bkt.expanded {
-		ret = append(ret, Line{bktPrefix + "- " + stringify([]byte(bkt.name)), bfg, bbg})
+		ret = append(ret, Line{bktPrefix + "- " + bktName, bfg, bbg})
 		for i := range bkt.buckets {
 			ret = append(ret, screen.bucketToLines(&bkt.buckets[i], style)...)
 		}
@@ -718,32 +888,35 @@ func (screen *BrowserScreen) bucketToLines(bkt *BoltBucket, style Style) []Line
 			if screen.filter != "" && !strings.Contains(bp.key, screen.filter) {
 				continue
 			}
-			pfg, pbg := style.defaultFg, style.defaultBg
+			pfg, pbg := style.pairFg, style.defaultBg
 			if comparePaths(screen.currentPath, bp.GetPath()) {
 				pfg, pbg = style.cursorFg, style.cursorBg
 			}
 			prPrefix := strings.Repeat(" ", len(bp.GetPath())*2)
 			var pairString string
 			if AppArgs.NoValue {
-				pairString = fmt.Sprintf("%s%s", prPrefix, stringify([]byte(bp.key)))
+				pairString = fmt.Sprintf("%s%s", prPrefix, model.Stringify([]byte(bp.key)))
 			} else {
-				pairString = fmt.Sprintf("%s%s: %s", prPrefix, stringify([]byte(bp.key)), stringify([]byte(bp.val)))
+				pairString = fmt.Sprintf("%s%s: %s", prPrefix, model.Stringify([]byte(bp.key)), model.Stringify([]byte(bp.val)))
 			}
 			ret = append(ret, Line{pairString, pfg, pbg})
 		}
 	} else {
-		ret = append(ret, Line{bktPrefix + "+ " + stringify([]byte(bkt.name)), bfg, bbg})
+		ret = append(ret, Line{bktPrefix + "+ " + bktName, bfg, bbg})
 	}
 	return ret
 }
 
 func (screen *BrowserScreen) startDeleteItem() bool {
+	if screen.readOnlyBlocked() {
+		return false
+	}
 	b, p, e := screen.db.getGenericFromPath(screen.currentPath)
 	if e == nil {
-		w, h := termbox.Size()
+		w, h := screenDisplay.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateConfirmModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateConfirmModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		if b != nil {
 			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Delete Bucket '%s'?", b.name), inpW-1, termboxUtil.AlignCenter))
 		} else if p != nil {
@@ -761,10 +934,10 @@ func (screen *BrowserScreen) startDeleteItem() bool {
 func (screen *BrowserScreen) startFilter() bool {
 	_, _, e := screen.db.getGenericFromPath(screen.currentPath)
 	if e == nil {
-		w, h := termbox.Size()
+		w, h := screenDisplay.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		mod.SetTitle(termboxUtil.AlignText("Filter", inpW, termboxUtil.AlignCenter))
 		mod.SetValue(screen.filter)
 		mod.Show()
@@ -776,12 +949,15 @@ func (screen *BrowserScreen) startFilter() bool {
 }
 
 func (screen *BrowserScreen) startEditItem() bool {
+	if screen.readOnlyBlocked() {
+		return false
+	}
 	_, p, e := screen.db.getGenericFromPath(screen.currentPath)
 	if e == nil {
-		w, h := termbox.Size()
+		w, h := screenDisplay.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		if p != nil {
 			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Input new value for '%s'", p.key), inpW, termboxUtil.AlignCenter))
 			mod.SetValue(p.val)
@@ -795,12 +971,15 @@ func (screen *BrowserScreen) startEditItem() bool {
 }
 
 func (screen *BrowserScreen) startRenameItem() bool {
+	if screen.readOnlyBlocked() {
+		return false
+	}
 	b, p, e := screen.db.getGenericFromPath(screen.currentPath)
 	if e == nil {
-		w, h := termbox.Size()
+		w, h := screenDisplay.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		if b != nil {
 			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Rename Bucket '%s' to:", b.name), inpW, termboxUtil.AlignCenter))
 			mod.SetValue(b.name)
@@ -817,13 +996,16 @@ func (screen *BrowserScreen) startRenameItem() bool {
 }
 
 func (screen *BrowserScreen) startInsertItemAtParent(tp BoltType) bool {
-	w, h := termbox.Size()
+	if screen.readOnlyBlocked() {
+		return false
+	}
+	w, h := screenDisplay.Size()
 	inpW, inpH := w-1, 7
 	if w > 80 {
 		inpW, inpH = (w / 2), 7
 	}
 	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 	screen.inputModal = mod
 	if len(screen.currentPath) <= 0 {
 		// in the root directory
@@ -868,13 +1050,16 @@ func (screen *BrowserScreen) startInsertItemAtParent(tp BoltType) bool {
 }
 
 func (screen *BrowserScreen) startInsertItem(tp BoltType) bool {
-	w, h := termbox.Size()
+	if screen.readOnlyBlocked() {
+		return false
+	}
+	w, h := screenDisplay.Size()
 	inpW, inpH := w-1, 7
 	if w > 80 {
 		inpW, inpH = (w / 2), 7
 	}
 	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 	//mod.SetInputWrap(true)
 	screen.inputModal = mod
 	var insPath string
@@ -909,62 +1094,87 @@ func (screen *BrowserScreen) startInsertItem(tp BoltType) bool {
 	return false
 }
 
-func (screen *BrowserScreen) startExportValue() bool {
+func (screen *BrowserScreen) startImportValue() bool {
+	if screen.readOnlyBlocked() {
+		return false
+	}
 	_, p, e := screen.db.getGenericFromPath(screen.currentPath)
 	if e == nil && p != nil {
-		w, h := termbox.Size()
+		w, h := screenDisplay.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
-		mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export value of '%s' to:", p.key), inpW, termboxUtil.AlignCenter))
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+		mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Import value of '%s' from:", p.key), inpW, termboxUtil.AlignCenter))
 		mod.SetValue("")
 		mod.Show()
 		screen.inputModal = mod
-		screen.mode = modeIOExportValue
+		screen.mode = modeIOImportValue
 		return true
 	}
-	screen.setMessage("Couldn't do string export on " + screen.currentPath[len(screen.currentPath)-1] + "(did you mean 'X'?)")
+	screen.setMessage("Couldn't do import on " + screen.currentPath[len(screen.currentPath)-1] + ", must be a pair.")
 	return false
 }
 
-func (screen *BrowserScreen) startExportJSON() bool {
-	b, p, e := screen.db.getGenericFromPath(screen.currentPath)
-	if e == nil {
-		w, h := termbox.Size()
-		inpW, inpH := (w / 2), 6
-		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
-		if b != nil {
-			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export JSON of '%s' to:", b.name), inpW, termboxUtil.AlignCenter))
-			mod.SetValue("")
-		} else if p != nil {
-			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export JSON of '%s' to:", p.key), inpW, termboxUtil.AlignCenter))
-			mod.SetValue("")
-		}
-		mod.Show()
-		screen.inputModal = mod
-		screen.mode = modeIOExportJSON
+/* readOnlyBlocked checks if the DB was opened read-only, and if so
+ * sets a message so the user knows why their action didn't happen.
+ * Call it before opening any modal that would change the DB.
+ */
+func (screen *BrowserScreen) readOnlyBlocked() bool {
+	if AppArgs.ReadOnly {
+		screen.setMessage("DB is in Read-Only Mode")
 		return true
 	}
 	return false
 }
 
-func (screen *BrowserScreen) startImportValue() bool {
-	_, p, e := screen.db.getGenericFromPath(screen.currentPath)
-	if e == nil && p != nil {
-		w, h := termbox.Size()
-		inpW, inpH := (w / 2), 6
-		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
-		mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Import value of '%s' from:", p.key), inpW, termboxUtil.AlignCenter))
-		mod.SetValue("")
-		mod.Show()
-		screen.inputModal = mod
-		screen.mode = modeIOImportValue
-		return true
+func (screen *BrowserScreen) startBackup() bool {
+	w, h := screenDisplay.Size()
+	inpW, inpH := (w / 2), 6
+	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+	if AppArgs.Salvage {
+		mod.SetTitle(termboxUtil.AlignText("Save salvaged data to new DB file:", inpW, termboxUtil.AlignCenter))
+	} else {
+		mod.SetTitle(termboxUtil.AlignText("Back up DB to (.gz to compress):", inpW, termboxUtil.AlignCenter))
 	}
-	screen.setMessage("Couldn't do import on " + screen.currentPath[len(screen.currentPath)-1] + ", must be a pair.")
-	return false
+	mod.SetValue(autoBackupFilename(currentFilename))
+	mod.Show()
+	screen.inputModal = mod
+	screen.mode = modeIOBackup
+	return true
+}
+
+func (screen *BrowserScreen) startExtract() bool {
+	if len(screen.currentPath) == 0 {
+		return false
+	}
+	w, h := screenDisplay.Size()
+	inpW, inpH := (w / 2), 6
+	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+	name := model.Stringify([]byte(screen.currentPath[len(screen.currentPath)-1]))
+	mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Extract '%s' to new DB file:", name), inpW, termboxUtil.AlignCenter))
+	mod.SetValue(name + ".db")
+	mod.Show()
+	screen.inputModal = mod
+	screen.mode = modeIOExtract
+	return true
+}
+
+func (screen *BrowserScreen) startRestore() bool {
+	if screen.readOnlyBlocked() {
+		return false
+	}
+	w, h := screenDisplay.Size()
+	inpW, inpH := (w / 2), 6
+	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+	mod.SetTitle(termboxUtil.AlignText("Replace entire DB with backup from:", inpW, termboxUtil.AlignCenter))
+	mod.SetValue("")
+	mod.Show()
+	screen.inputModal = mod
+	screen.mode = modeIORestore
+	return true
 }
 
 func (screen *BrowserScreen) setMessage(msg string) {
@@ -989,8 +1199,21 @@ func (screen *BrowserScreen) clearMessage() {
 
 func (screen *BrowserScreen) refreshDatabase() {
 	shadowDB := screen.db
-	screen.db = screen.db.refreshDatabase()
+	var err error
+	screen.db, err = screen.db.refreshDatabase()
 	screen.db.syncOpenBuckets(shadowDB)
+	screen.applySortModes()
+	if err != nil {
+		screen.setMessage("Error reading DB: " + err.Error())
+	}
+}
+
+// dbState loads the state of the DB the first time it's needed
+func (screen *BrowserScreen) dbState() *dbState {
+	if screen.state == nil {
+		screen.state = loadDBState(currentFilename)
+	}
+	return screen.state
 }
 
 func comparePaths(p1, p2 []string) bool {
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"go.etcd.io/bbolt"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+/*
+harness runs the screens against a bolt file in a temp directory, drawn
+on a cellBuffer and driven with key events, like mainLoop does with the
+terminal's.
+*/
+type harness struct {
+	t       *testing.T
+	buf     *cellBuffer
+	style   Style
+	screens []Screen
+	screen  Screen
+	exited  bool
+}
+
+// The DB most of the scenarios start with
+func fillTestDB(tx *bbolt.Tx) error {
+	users, err := tx.CreateBucket([]byte("users"))
+	if err != nil {
+		return err
+	}
+	users.Put([]byte("u1"), []byte(`{"name":"alice","age":30}`))
+	users.Put([]byte("u2"), []byte(`{"name":"bob","age":25}`))
+	users.Put([]byte("u3"), []byte("carol"))
+	orders, err := users.CreateBucket([]byte("orders"))
+	if err != nil {
+		return err
+	}
+	orders.Put([]byte("o1"), []byte("12.50"))
+	config, err := tx.CreateBucket([]byte("config"))
+	if err != nil {
+		return err
+	}
+	return config.Put([]byte("theme"), []byte("dark"))
+}
+
+func newHarness(t *testing.T, fill func(tx *bbolt.Tx) error) *harness {
+	t.Helper()
+	// Keep the saved UI state and anything exported out of the real directories
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = bbolt.Open("test.db", 0600, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fill != nil {
+		if err = db.Update(fill); err != nil {
+			t.Fatal(err)
+		}
+	}
+	currentFilename = "test.db"
+	AppArgs.ReadOnly = false
+	t.Cleanup(func() {
+		db.Close()
+		os.Chdir(wd)
+		screenDisplay = termboxDisplay{}
+	})
+
+	h := &harness{t: t, buf: newCellBuffer(80, 20), style: defaultStyle()}
+	screenDisplay = h.buf
+	memBolt = new(BoltDB)
+	if _, err = memBolt.refreshDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	h.screens = defaultScreensForData(memBolt, h.style)
+	h.screen = h.screens[BrowserScreenIndex]
+	layoutAndDrawScreen(h.screen, h.style)
+	return h
+}
+
+/*
+press sends the keys in 'keys', separated by spaces. Key names (like
+"enter" or "ctrl+f") are sent as that key, anything else is typed.
+*/
+func (h *harness) press(keys string) {
+	h.t.Helper()
+	for _, name := range strings.Fields(keys) {
+		var events []termbox.Event
+		if k, ok := keyNames[name]; ok {
+			events = append(events, termbox.Event{Type: termbox.EventKey, Key: k})
+		} else {
+			for _, r := range name {
+				events = append(events, termbox.Event{Type: termbox.EventKey, Ch: r})
+			}
+		}
+		for _, ev := range events {
+			if h.exited {
+				h.t.Fatalf("key %q sent after exiting", name)
+			}
+			var ok bool
+			if h.screen, ok = handleEvent(h.screens, h.screen, ev, h.style); !ok {
+				h.exited = true
+			}
+		}
+	}
+}
+
+func (h *harness) browser() *BrowserScreen {
+	return h.screens[BrowserScreenIndex].(*BrowserScreen)
+}
+
+func (h *harness) checkPath(want ...string) {
+	h.t.Helper()
+	if got := h.browser().currentPath; !comparePaths(got, want) {
+		h.t.Errorf("cursor is on %q, expected %q", got, want)
+	}
+}
+
+// checkValue checks the value of the pair at 'path' in the DB, nil means it shouldn't be there
+func (h *harness) checkValue(path []string, want []byte) {
+	h.t.Helper()
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(path[0]))
+		for i := 1; b != nil && i < len(path)-1; i++ {
+			b = b.Bucket([]byte(path[i]))
+		}
+		var got []byte
+		if b != nil {
+			got = b.Get([]byte(path[len(path)-1]))
+		}
+		if (got == nil) != (want == nil) || string(got) != string(want) {
+			h.t.Errorf("%s is %q, expected %q", strings.Join(path, "/"), got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// checkScreen compares what's on the screen with testdata/<test name>.golden
+func (h *harness) checkScreen() {
+	h.t.Helper()
+	fn := filepath.Join(testdataDir, strings.ReplaceAll(h.t.Name(), "/", "_")+".golden")
+	got := h.buf.String()
+	if *updateGolden {
+		if err := os.WriteFile(fn, []byte(got), 0644); err != nil {
+			h.t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(fn)
+	if err != nil {
+		h.t.Fatalf("%s (run with -update to make it)", err)
+	}
+	if got != string(want) {
+		h.t.Errorf("the screen doesn't match %s\n--- got:\n%s--- expected:\n%s", fn, got, want)
+	}
+}
+
+// The tests change directory, so the golden files are found from where they started
+var testdataDir = func() string {
+	wd, _ := os.Getwd()
+	return filepath.Join(wd, "testdata")
+}()
+
+func TestNavigate(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.checkPath("config")
+	h.press("j l j j")
+	h.checkPath("users", "u1")
+	h.checkScreen()
+	h.press("h")
+	h.checkPath("users")
+	h.press("k")
+	h.checkPath("config")
+}
+
+func TestJumpCursor(t *testing.T) {
+	h := newHarness(t, func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("items"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 40; i++ {
+			b.Put([]byte{'k', byte('0' + i/10), byte('0' + i%10)}, []byte("v"))
+		}
+		return nil
+	})
+	h.press("l")
+	// Half of the 20 line screen, from the bucket
+	h.press("ctrl+f")
+	h.checkPath("items", "k09")
+	h.press("ctrl+f ctrl+f ctrl+f ctrl+f")
+	h.checkPath("items", "k39")
+	h.checkScreen()
+	h.press("ctrl+b")
+	h.checkPath("items", "k29")
+	h.press("ctrl+b ctrl+b ctrl+b ctrl+b")
+	h.checkPath("items")
+}
+
+func TestJumpList(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("G")
+	h.checkPath("users")
+	h.press("ctrl+o")
+	h.checkPath("config")
+	h.press("ctrl+n")
+	h.checkPath("users")
+}
+
+// No two actions have the same keys by default
+func TestDefaultKeyBindings(t *testing.T) {
+	seen := make(map[string]string)
+	for _, kb := range browserKeyBindings {
+		keys := strings.Join(kb.keys, " ")
+		if other, ok := seen[keys]; ok && other != kb.action.name {
+			t.Errorf("%s is bound to both %s and %s", keys, other, kb.action.name)
+		}
+		seen[keys] = kb.action.name
+	}
+}
+
+func TestFilter(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("j l / u2 enter")
+	h.checkScreen()
+	// Buckets are always shown, only the pairs are filtered
+	h.press("j j")
+	h.checkPath("users", "u2")
+	h.press("j")
+	h.checkPath("users", "u2")
+}
+
+func TestFilterJump(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("j l j j / u2 enter")
+	// u1 is filtered out, the cursor is moved up to its bucket
+	h.checkPath("users")
+	h.press("/ backspace backspace enter ctrl+o")
+	h.checkPath("users", "u1")
+}
+
+// A title wider than the modal is cut by how wide it is on the screen, not by bytes
+func TestModalWideTitle(t *testing.T) {
+	for name, keys := range map[string]string{"input": "l j r", "confirm": "l j D"} {
+		t.Run(name, func(t *testing.T) {
+			h := newHarness(t, func(tx *bbolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("wide"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte(strings.Repeat("键", 40)), []byte("v"))
+			})
+			h.press(keys)
+			h.checkScreen()
+		})
+	}
+}
+
+func TestInsert(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("p")
+	h.checkScreen()
+	h.press("newkey enter")
+	h.press("hello space world enter")
+	h.checkValue([]string{"config", "newkey"}, []byte("hello world"))
+	h.checkPath("config", "newkey")
+	// Next to the pair, in config
+	h.press("B archive enter")
+	h.checkPath("config", "archive")
+	if err := db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte("config")).Bucket([]byte("archive")) == nil {
+			t.Error("the bucket archive wasn't created")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("l j r backspace backspace backspace backspace backspace mode enter")
+	h.checkValue([]string{"config", "theme"}, nil)
+	h.checkValue([]string{"config", "mode"}, []byte("dark"))
+	h.checkPath("config", "mode")
+	h.checkScreen()
+}
+
+func TestDelete(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("j l j D")
+	h.checkScreen()
+	h.press("n")
+	h.checkValue([]string{"users", "orders", "o1"}, []byte("12.50"))
+	h.press("D y")
+	h.checkValue([]string{"users", "orders", "o1"}, nil)
+	h.checkPath("users", "u1")
+	h.press("j D y")
+	h.checkValue([]string{"users", "u2"}, nil)
+	h.checkValue([]string{"users", "u1"}, []byte(`{"name":"alice","age":30}`))
+}
+
+func TestExport(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("l X ctrl+u config.json enter")
+	out, err := os.ReadFile("config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"theme":"dark"}` {
+		t.Errorf("exported %s", out)
+	}
+	h.press("j x ctrl+u theme.txt enter")
+	if out, err = os.ReadFile("theme.txt"); err != nil || string(out) != "dark" {
+		t.Errorf("exported %q, %v", out, err)
+	}
+	h.checkScreen()
+}
+
+func TestExportDialog(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("j X")
+	h.checkScreen()
+	// Up to the format, json to yaml, then just the bucket without the ones in it
+	h.press("up up up right right right down left enter")
+	out, err := os.ReadFile("users.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"u1": "{\"name\":\"alice\",\"age\":30}"
+"u2": "{\"name\":\"bob\",\"age\":25}"
+"u3": "carol"
+`
+	if string(out) != want {
+		t.Errorf("exported:\n%s", out)
+	}
+
+	// bolt is before json, going left past raw
+	h.press("X up up up left left enter")
+	bdb, err := bbolt.Open("users.db", 0600, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	bdb.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte("users")); b == nil || b.Bucket([]byte("orders")).Get([]byte("o1")) == nil {
+			t.Error("users/orders/o1 wasn't exported")
+		}
+		if tx.Bucket([]byte("config")) != nil {
+			t.Error("config was exported")
+		}
+		return nil
+	})
+}
+
+func TestExportOverwrite(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	if err := os.WriteFile("config.json", []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h.press("l X ctrl+u config.json enter")
+	// It asks first
+	h.checkScreen()
+	if out, _ := os.ReadFile("config.json"); string(out) != "old" {
+		t.Errorf("overwrote the file without asking: %s", out)
+	}
+	h.press("enter")
+	if out, _ := os.ReadFile("config.json"); string(out) != `{"theme":"dark"}` {
+		t.Errorf("exported %s", out)
+	}
+
+	h.press("X ctrl+u test.db enter enter esc")
+	if msg := h.browser().message; msg != "Can't export over the DB itself" {
+		t.Errorf("the message is %q", msg)
+	}
+	h.checkValue([]string{"config", "theme"}, []byte("dark"))
+}
+
+func TestExportEmptyBuckets(t *testing.T) {
+	h := newHarness(t, func(tx *bbolt.Tx) error {
+		a, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		a.CreateBucket([]byte("empty"))
+		a.Put([]byte("k"), []byte("v"))
+		nested, err := a.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		_, err = nested.CreateBucket([]byte("inner"))
+		return err
+	})
+	for _, tc := range []struct {
+		keys, fn, want string
+	}{
+		{"X enter", "a.json", `{"empty":{},"k":"v","nested":{"inner":{}}}`},
+		{"X up up up right right right enter", "a.yaml", `"empty": {}
+"k": "v"
+"nested":
+  "inner": {}
+`},
+	} {
+		h.press(tc.keys)
+		out, err := os.ReadFile(tc.fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tc.want {
+			t.Errorf("%s is:\n%s\nexpected:\n%s", tc.fn, out, tc.want)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("j l j E enter")
+	h.checkScreen()
+	h.press("k k m a j j j m b : extract space -marks space marked.db enter")
+	for fn, want := range map[string][]string{
+		"orders.db": {"users/orders/o1"},
+		"marked.db": {"config/theme", "users/u1"},
+	} {
+		bdb, err := bbolt.Open(fn, 0600, &bbolt.Options{ReadOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		bdb.View(func(tx *bbolt.Tx) error {
+			return tx.ForEach(func(nm []byte, b *bbolt.Bucket) error {
+				var walk func(path string, b *bbolt.Bucket) error
+				walk = func(path string, b *bbolt.Bucket) error {
+					return b.ForEach(func(k, v []byte) error {
+						if v == nil {
+							return walk(path+"/"+string(k), b.Bucket(k))
+						}
+						got = append(got, path+"/"+string(k))
+						return nil
+					})
+				}
+				return walk(string(nm), b)
+			})
+		})
+		bdb.Close()
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s has %q, expected %q", fn, got, want)
+		}
+	}
+}
+
+// A bucket of json objects that don't all have the same fields, and a value that isn't json
+func fillTableDB(tx *bbolt.Tx) error {
+	people, err := tx.CreateBucket([]byte("people"))
+	if err != nil {
+		return err
+	}
+	people.Put([]byte("p1"), []byte(`{"name":"dave","age":41,"city":"Oslo"}`))
+	people.Put([]byte("p2"), []byte(`{"name":"erin","age":9}`))
+	people.Put([]byte("p3"), []byte(`{"name":"frank","age":100,"city":"Rome","tags":["a","b"]}`))
+	return people.Put([]byte("p4"), []byte("not json"))
+}
+
+func TestTable(t *testing.T) {
+	for _, tc := range []struct {
+		name, keys string
+	}{
+		{"columns", "T"},
+		// Numbers sort as numbers, rows without the field go last
+		{"sort", "T l l s"},
+		{"sort_desc", "T l l s s"},
+		{"filter", "T l l l / o enter"},
+		{"hide", "T l x l x"},
+		{"reorder", "T l l > > a"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newHarness(t, fillTableDB)
+			h.press(tc.keys)
+			h.checkScreen()
+		})
+	}
+
+	// enter goes to the pair in the tree
+	h := newHarness(t, fillTableDB)
+	h.press("T l l s j enter")
+	h.checkPath("people", "p1")
+}
+
+func TestKeyTimeout(t *testing.T) {
+	// 'g' is the start of 'gg' and a binding of its own, 'd' is only the start of 'dd'
+	keys := map[string][]string{"goto_top": {"gg"}, "goto_bottom": {"g"}, "delete": {"dd"}}
+	defaults := make(map[string][]string)
+	for i := range browserActionTable {
+		if _, ok := keys[browserActionTable[i].name]; ok {
+			defaults[browserActionTable[i].name] = browserActionTable[i].keys
+		}
+	}
+	if err := applyKeyConfig(keys); err != nil {
+		t.Fatal(err)
+	}
+	AppConfig.KeyTimeout = 50
+	t.Cleanup(func() {
+		applyKeyConfig(defaults)
+		AppConfig.KeyTimeout = 0
+	})
+
+	h := newHarness(t, fillTestDB)
+	// timeout is what the terminal sends when the key timeout's timer goes off
+	timeout := func() {
+		h.screen, _ = handleEvent(h.screens, h.screen, termbox.Event{Type: termbox.EventInterrupt}, h.style)
+	}
+	h.press("g")
+	// The keys so far are shown while it waits
+	h.checkScreen()
+	time.Sleep(60 * time.Millisecond)
+	timeout()
+	h.checkPath("users")
+	h.press("g g")
+	h.checkPath("config")
+
+	// A timer from before the last key doesn't cut it short
+	h.press("d")
+	timeout()
+	if len(h.browser().queuedKeys) != 1 {
+		t.Errorf("expected 'd' to still be waiting, the keys are %q", h.browser().queuedKeys)
+	}
+	time.Sleep(60 * time.Millisecond)
+	timeout()
+	if b := h.browser(); len(b.queuedKeys) != 0 || b.message == "d" {
+		t.Errorf("'d' is still waiting, the keys are %q and the message is %q", b.queuedKeys, b.message)
+	}
+	// So the next key isn't swallowed
+	h.press("j")
+	h.checkPath("users")
+}
+
+func TestQuit(t *testing.T) {
+	h := newHarness(t, fillTestDB)
+	h.press("q")
+	if !h.exited {
+		t.Error("q didn't exit")
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/br0xen/boltbrowser/model"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// How often the progress is redrawn while exporting, in pairs
+const exportProgressEvery = 1000
+
+// How many values csv works out its columns from
+const exportCSVSample = model.DefaultCSVSample
+
+// The rows of the export dialog
+const (
+	exportRowFormat = iota
+	exportRowScope
+	exportRowDest
+	exportRowFile
+)
+
+/*
+exportDialog is what's been picked in the export dialog so far
+*/
+type exportDialog struct {
+	format int
+	scope  int
+	dest   int
+	row    int
+	file   string
+	// The file name was typed in, so it isn't changed to match the format anymore
+	fileTyped bool
+	// The file that's already there, that enter has been pressed for once
+	overwrite string
+	// How many pairs have been written, while it's exporting
+	progress int
+	running  bool
+}
+
+/*
+startExport opens the export dialog for the selected item, starting
+with 'format'. A pair starts with just the pair, a bucket with everything in it.
+*/
+func (screen *BrowserScreen) startExport(format string) int {
+	b, p, err := screen.db.getGenericFromPath(screen.currentPath)
+	if err != nil {
+		return BrowserScreenIndex
+	}
+	d := &exportDialog{row: exportRowFile, scope: exportScopeRecursive}
+	for i := range exportFormats {
+		if exportFormats[i] == format {
+			d.format = i
+		}
+	}
+	if p != nil && b == nil {
+		d.scope = exportScopePair
+	}
+	screen.export = d
+	screen.suggestExportFile()
+	return ExportScreenIndex
+}
+
+// suggestExportFile names the file after the selected item, with the format's extension
+func (screen *BrowserScreen) suggestExportFile() {
+	d := screen.export
+	if d.fileTyped || len(screen.currentPath) == 0 {
+		return
+	}
+	nm := screen.currentPath[len(screen.currentPath)-1]
+	if d.scope != exportScopePair {
+		if bkt := screen.tableBucketPath(); len(bkt) > 0 {
+			nm = bkt[len(bkt)-1]
+		}
+	}
+	d.file = model.Stringify([]byte(nm)) + exportExtensions[exportFormats[d.format]]
+}
+
+// exportScopeOK is whether 'scope' can be exported from where the cursor is
+func (screen *BrowserScreen) exportScopeOK(scope int) bool {
+	switch scope {
+	case exportScopePair:
+		_, err := screen.db.getPairFromPath(screen.currentPath)
+		return err == nil
+	case exportScopeResults:
+		return len(screen.searchResultPaths()) > 0
+	}
+	return true
+}
+
+/*
+ExportScreen is the export dialog, drawn over the browser: what's
+exported (the format and the scope) and where to
+*/
+type ExportScreen struct {
+	browser *BrowserScreen
+	style   Style
+}
+
+func (screen *ExportScreen) dialog() *exportDialog {
+	if screen.browser.export == nil {
+		screen.browser.export = &exportDialog{}
+	}
+	return screen.browser.export
+}
+
+// rows is how many rows there are, the file name is only for files
+func (screen *ExportScreen) rows() int {
+	if screen.dialog().dest == exportToFile {
+		return exportRowFile + 1
+	}
+	return exportRowDest + 1
+}
+
+func (screen *ExportScreen) handleKeyEvent(event termbox.Event) int {
+	d := screen.dialog()
+	typing := d.row == exportRowFile
+	switch {
+	case event.Key == termbox.KeyEsc:
+		return BrowserScreenIndex
+	case event.Key == termbox.KeyEnter:
+		return screen.runExport()
+	case event.Key == termbox.KeyArrowUp || (!typing && event.Ch == 'k'):
+		d.row = (d.row + screen.rows() - 1) % screen.rows()
+	case event.Key == termbox.KeyArrowDown || event.Key == termbox.KeyTab || (!typing && event.Ch == 'j'):
+		d.row = (d.row + 1) % screen.rows()
+	case event.Key == termbox.KeyArrowLeft || (!typing && event.Ch == 'h'):
+		screen.choose(-1)
+	case event.Key == termbox.KeyArrowRight || (!typing && event.Ch == 'l'):
+		screen.choose(1)
+	case typing && (event.Key == termbox.KeyBackspace || event.Key == termbox.KeyBackspace2):
+		if r := []rune(d.file); len(r) > 0 {
+			d.file = string(r[:len(r)-1])
+		}
+		d.fileTyped = true
+	case typing && event.Key == termbox.KeyCtrlU:
+		d.file = ""
+		d.fileTyped = true
+	case typing && event.Key == termbox.KeySpace:
+		d.file += " "
+		d.fileTyped = true
+	case typing && event.Ch != 0:
+		d.file += string(event.Ch)
+		d.fileTyped = true
+	}
+	return ExportScreenIndex
+}
+
+// choose moves to the next (or previous) choice on the selected row, skipping the ones that can't be picked
+func (screen *ExportScreen) choose(dir int) {
+	d := screen.dialog()
+	switch d.row {
+	case exportRowFormat:
+		d.format = (d.format + dir + len(exportFormats)) % len(exportFormats)
+		if exportFormats[d.format] == "bolt" {
+			// A DB can only go in a file
+			d.dest = exportToFile
+		}
+	case exportRowScope:
+		for i := 0; i < len(exportScopeNames); i++ {
+			d.scope = (d.scope + dir + len(exportScopeNames)) % len(exportScopeNames)
+			if screen.browser.exportScopeOK(d.scope) {
+				break
+			}
+		}
+	case exportRowDest:
+		if exportFormats[d.format] != "bolt" {
+			d.dest = (d.dest + dir + len(exportDestNames)) % len(exportDestNames)
+		}
+	}
+	screen.browser.suggestExportFile()
+}
+
+/*
+runExport exports what's been picked, redrawing the progress as it goes,
+then goes back to the browser with how it went
+*/
+func (screen *ExportScreen) runExport() int {
+	d := screen.dialog()
+	b := screen.browser
+	format := exportFormats[d.format]
+	if d.dest == exportToFile && strings.TrimSpace(d.file) == "" {
+		b.setMessage("Export needs a file name")
+		return ExportScreenIndex
+	}
+	if !b.exportScopeOK(d.scope) {
+		b.setMessage("There's nothing to export in " + exportScopeNames[d.scope])
+		return BrowserScreenIndex
+	}
+
+	if format == "bolt" && (d.scope == exportScopePair || d.scope == exportScopeRecursive) {
+		// A whole bucket (or a pair) is extracted, which keeps the sequences
+		path := b.currentPath
+		if d.scope == exportScopeRecursive {
+			path = b.tableBucketPath()
+		}
+		b.extractTo([][]string{path}, d.file)
+		return BrowserScreenIndex
+	}
+
+	var out io.Writer
+	var buf strings.Builder
+	var f *os.File
+	if d.dest == exportToFile && format != "bolt" {
+		if sameFile(d.file, db.Path()) {
+			b.setMessage("Can't export over the DB itself")
+			return ExportScreenIndex
+		}
+		if _, err := os.Stat(d.file); err == nil && d.overwrite != d.file {
+			// Enter again to overwrite it
+			d.overwrite = d.file
+			return ExportScreenIndex
+		}
+		var err error
+		if f, err = os.OpenFile(d.file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660); err != nil {
+			b.setMessage(err.Error())
+			return BrowserScreenIndex
+		}
+		defer f.Close()
+		out = f
+	} else {
+		out = &buf
+	}
+	var sample [][]byte
+	if format == "csv" {
+		b.exportPairs(d.scope, func(_ []string, _, v []byte) error {
+			if v == nil {
+				return nil
+			} else if len(sample) == exportCSVSample {
+				return io.EOF
+			}
+			sample = append(sample, append([]byte{}, v...))
+			return nil
+		})
+	}
+	w, err := newExportWriter(format, out, d.file, b.exportBase(d.scope), d.scope == exportScopePair, sample)
+	if err != nil {
+		b.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+
+	d.running, d.progress = true, 0
+	err = b.exportPairs(d.scope, func(bucket []string, k, v []byte) error {
+		if v == nil {
+			return w.pair(bucket, k, v)
+		}
+		if d.progress++; d.progress%exportProgressEvery == 0 {
+			layoutAndDrawScreen(screen, screen.style)
+		}
+		return w.pair(bucket, k, v)
+	})
+	d.running = false
+	if bw, ok := w.(*boltExportWriter); ok && err != nil {
+		bw.abort()
+	} else if cerr := w.close(); err == nil {
+		err = cerr
+	}
+	if err == nil && f != nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		b.setMessage(fmt.Sprintf("Export failed after %d pairs: %s", d.progress, err.Error()))
+		return BrowserScreenIndex
+	}
+
+	done := fmt.Sprintf("Exported %d pairs as %s", d.progress, format)
+	switch d.dest {
+	case exportToFile:
+		b.setMessage(done + " to file: " + d.file)
+	case exportToStdout:
+		exitOutput.WriteString(buf.String())
+		b.setMessage(done + ", they'll be written to stdout on exit")
+	case exportToClipboard:
+		if err = copyToClipboard([]byte(buf.String())); err != nil {
+			b.setMessage(err.Error())
+		} else {
+			b.setMessage(done + " to the clipboard")
+		}
+	}
+	return BrowserScreenIndex
+}
+
+func (screen *ExportScreen) handleMouseEvent(event termbox.Event) int {
+	return ExportScreenIndex
+}
+
+func (screen *ExportScreen) performLayout() {
+	screen.browser.performLayout()
+}
+
+func (screen *ExportScreen) drawScreen(style Style) {
+	screen.browser.drawScreen(style)
+	d := screen.dialog()
+	width, height := screenDisplay.Size()
+	w := 72
+	if w > width-2 {
+		w = width - 2
+	}
+	h := screen.rows() + 5
+	x, y := (width-w)/2, (height-h)/2
+	fillWithChar(' ', x, y, x+w-1, y+h-1, style.modalFg, style.modalBg)
+	drawBorder(x, y, x+w-1, y+h-1, style.modalFg, style.modalBg)
+	title := " Export "
+	if len(screen.browser.currentPath) > 0 {
+		title = fmt.Sprintf(" Export '%s' ", model.Stringify([]byte(screen.browser.currentPath[len(screen.browser.currentPath)-1])))
+	}
+	if tw := runewidth.StringWidth(title); tw < w-2 {
+		drawStringClipped(title, x+(w-tw)/2, y, 0, w-2, style.modalFg, style.modalBg)
+	}
+
+	var scopes []string
+	for i := range exportScopeNames {
+		if screen.browser.exportScopeOK(i) {
+			scopes = append(scopes, exportScopeNames[i])
+		}
+	}
+	dests := exportDestNames
+	if exportFormats[d.format] == "bolt" {
+		dests = dests[:1]
+	}
+	rowY := y + 2
+	screen.drawChoices("Format", exportFormats, exportFormats[d.format], x+2, rowY, w-4, d.row == exportRowFormat, style)
+	screen.drawChoices("Scope", scopes, exportScopeNames[d.scope], x+2, rowY+1, w-4, d.row == exportRowScope, style)
+	screen.drawChoices("To", dests, exportDestNames[d.dest], x+2, rowY+2, w-4, d.row == exportRowDest, style)
+	if d.dest == exportToFile {
+		drawStringClipped("File", x+2, rowY+3, 0, 8, style.modalFg, style.modalBg)
+		// The end of the name, if it's too long
+		fx := drawStringClipped(truncateLeft(d.file, w-14), x+10, rowY+3, 0, w-14, style.modalFg, style.modalBg)
+		if d.row == exportRowFile {
+			screenDisplay.SetCell(x+10+fx, rowY+3, ' ', style.cursorFg, style.cursorBg)
+		}
+	}
+
+	help := "←/→ choose, ↑/↓ move, enter export, esc cancel"
+	if d.overwrite != "" && d.overwrite == d.file {
+		help = "The file is already there, enter overwrites it"
+	}
+	if d.running {
+		help = fmt.Sprintf("Exporting... %d pairs", d.progress)
+	}
+	drawStringClipped(help, x+2, y+h-2, 0, w-4, style.modalFg, style.modalBg)
+}
+
+// drawChoices draws a row of the dialog, the picked choice is in brackets and highlighted on the selected row
+func (screen *ExportScreen) drawChoices(label string, choices []string, picked string, x, y, w int, selected bool, style Style) {
+	drawStringClipped(label, x, y, 0, 8, style.modalFg, style.modalBg)
+	col := 8
+	for _, c := range choices {
+		fg, bg := style.modalFg, style.modalBg
+		text := " " + c + " "
+		if c == picked {
+			text = "[" + c + "]"
+			if selected {
+				fg, bg = style.cursorFg, style.cursorBg
+			}
+		}
+		if col >= w {
+			break
+		}
+		col += drawStringClipped(text, x+col, y, 0, w-col, fg, bg)
+	}
+}
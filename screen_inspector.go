@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/br0xen/boltbrowser/model"
+	"github.com/nsf/termbox-go"
+	"go.etcd.io/bbolt"
+)
+
+/*
+InspectorScreen shows the physical layout of the bolt file:
+the meta pages, the freelist, and the pages the selected item lives on
+*/
+type InspectorScreen struct {
+	browser   *BrowserScreen
+	style     Style
+	scrollRow int
+	buffer    []Line
+}
+
+func (screen *InspectorScreen) handleKeyEvent(event termbox.Event) int {
+	_, h := screenDisplay.Size()
+	if event.Ch == 'j' || event.Key == termbox.KeyArrowDown {
+		screen.scrollRow++
+	} else if event.Ch == 'k' || event.Key == termbox.KeyArrowUp {
+		if screen.scrollRow > 0 {
+			screen.scrollRow--
+		}
+	} else if event.Key == termbox.KeyCtrlF {
+		screen.scrollRow += h / 2
+	} else if event.Key == termbox.KeyCtrlB {
+		screen.scrollRow -= h / 2
+		if screen.scrollRow < 0 {
+			screen.scrollRow = 0
+		}
+	} else if event.Key == termbox.KeyCtrlR {
+		screen.buffer = nil
+	} else {
+		screen.buffer = nil
+		screen.scrollRow = 0
+		return BrowserScreenIndex
+	}
+	return InspectorScreenIndex
+}
+
+func (screen *InspectorScreen) performLayout() {
+	if screen.buffer == nil {
+		screen.buffer = screen.buildLines()
+	}
+}
+
+func (screen *InspectorScreen) drawScreen(style Style) {
+	width, height := screenDisplay.Size()
+	title := "Page Inspector: " + currentFilename
+	count := ((width - len(title)) / 2) + 1
+	if count < 0 {
+		count = 0
+	}
+	spaces := strings.Repeat(" ", count)
+	drawStringAtPoint(spaces+title+spaces, 0, 0, style.titleFg, style.titleBg)
+
+	maxScroll := len(screen.buffer) - (height - 3)
+	if maxScroll < 0 {
+		maxScroll = 0
+	}
+	if screen.scrollRow > maxScroll {
+		screen.scrollRow = maxScroll
+	}
+	for k, v := range screen.buffer[screen.scrollRow:] {
+		if k >= height-3 {
+			break
+		}
+		drawStringAtPoint(v.Text, 1, k+2, v.Fg, v.Bg)
+	}
+	exitTxt := "j/k to scroll, ctrl+r to reload, any other key to return to browser"
+	drawStringAtPoint(exitTxt, (width-len(exitTxt))/2, height-1, style.titleFg, style.titleBg)
+}
+
+func (screen *InspectorScreen) buildLines() []Line {
+	var ret []Line
+	style := screen.style
+	addLine := func(fg termbox.Attribute, format string, a ...interface{}) {
+		bg := style.defaultBg
+		if fg == style.errorFg {
+			bg = style.errorBg
+		}
+		ret = append(ret, Line{fmt.Sprintf(format, a...), fg, bg})
+	}
+	if db == nil {
+		addLine(style.errorFg, "No DB is open")
+		return ret
+	}
+	pf, err := openBoltPageFile(db.Path())
+	if err != nil {
+		addLine(style.errorFg, "Error reading file: %s", err.Error())
+		return ret
+	}
+	defer pf.Close()
+	addLine(style.defaultFg, "Page size: %d, %d pages in file", pf.pageSize, pf.pageCount())
+	addLine(style.defaultFg, "")
+
+	// Meta pages
+	metas := pf.metas()
+	current, _ := pf.currentMeta()
+	for _, m := range metas {
+		if m.err != nil {
+			addLine(style.errorFg, "Meta page %d: INVALID (%s)", m.pageID, m.err.Error())
+			continue
+		}
+		cur := ""
+		if m.pageID == current.pageID {
+			cur = " (current)"
+		}
+		addLine(style.defaultFg, "Meta page %d%s: txid %d, root pgid %d, freelist pgid %d, high water pgid %d, checksum ok",
+			m.pageID, cur, m.txid, m.root, m.freelist, m.pgid)
+	}
+	addLine(style.defaultFg, "")
+
+	// The freelist
+	free := make(map[uint64]bool)
+	if current.freelist == 0xFFFFFFFFFFFFFFFF {
+		addLine(style.defaultFg, "Freelist: not synced to disk")
+	} else if p, err := pf.readPage(current.freelist); err != nil {
+		addLine(style.errorFg, "Freelist: %s", err.Error())
+	} else if ids, err := p.freelistIDs(); err != nil {
+		addLine(style.errorFg, "Freelist: %s", err.Error())
+	} else {
+		for _, id := range ids {
+			free[id] = true
+		}
+		addLine(style.defaultFg, "Freelist (page %d, %d overflow): %d free pages", p.id, p.overflow, len(ids))
+		for _, l := range wrapIDs(ids, 16) {
+			addLine(style.defaultFg, "  %s", l)
+		}
+	}
+	addLine(style.defaultFg, "")
+
+	// The selected item
+	path := screen.browser.currentPath
+	addLine(style.defaultFg, "Pages for %s:", strings.Join(model.StringifyPath(append([]string{}, path...)), " → "))
+	chain, chainErr := pf.pageChain(path)
+	err = db.View(func(tx *bbolt.Tx) error {
+		for _, step := range chain {
+			loc := "bucket " + strings.Join(model.StringifyPath(step.bucket), " → ")
+			if len(step.bucket) == 0 {
+				loc = "root"
+			}
+			if step.inline {
+				addLine(style.defaultFg, "  inline %-8s %5d elements, fill %3d%%  [%s]",
+					step.page.typeString(), step.elements, step.fill(), loc)
+				continue
+			}
+			// Ask bolt what it thinks of the page, it knows if it's been freed
+			info, err := tx.Page(int(step.page.id))
+			if err == bbolt.ErrFreePagesNotLoaded {
+				// Read-only handles don't load the freelist, use what we read ourselves
+				info, err = rawPageInfo(step.page, free), nil
+			}
+			if err != nil {
+				return err
+			}
+			if info == nil {
+				addLine(style.errorFg, "  page %-6d past the high water mark", step.page.id)
+				continue
+			}
+			addLine(style.defaultFg, "  page %-6d %-8s %5d elements, %d overflow, fill %3d%%  [%s]",
+				info.ID, info.Type, info.Count, info.OverflowCount, step.fill(), loc)
+		}
+		return nil
+	})
+	if chainErr != nil {
+		addLine(style.errorFg, "  %s", chainErr.Error())
+	}
+	if err != nil {
+		addLine(style.errorFg, "  %s", err.Error())
+	}
+	return ret
+}
+
+// rawPageInfo builds the same info bolt's Tx.Page gives, from a page we read directly
+func rawPageInfo(p *boltRawPage, free map[uint64]bool) *bbolt.PageInfo {
+	info := &bbolt.PageInfo{
+		ID:            int(p.id),
+		Type:          p.typeString(),
+		Count:         int(p.count),
+		OverflowCount: int(p.overflow),
+	}
+	if free[p.id] {
+		info.Type = "free"
+	}
+	return info
+}
+
+// wrapIDs formats page ids into lines of 'perLine' ids each
+func wrapIDs(ids []uint64, perLine int) []string {
+	var ret []string
+	for i := 0; i < len(ids); i += perLine {
+		end := i + perLine
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var pts []string
+		for _, id := range ids[i:end] {
+			pts = append(pts, fmt.Sprintf("%d", id))
+		}
+		ret = append(ret, strings.Join(pts, ", "))
+	}
+	return ret
+}
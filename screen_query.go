@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/br0xen/boltbrowser/model"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+/*
+QueryScreen lists the results of the last query,
+picking one goes to its pair in the tree
+*/
+type QueryScreen struct {
+	browser   *BrowserScreen
+	style     Style
+	scrollRow int
+}
+
+// The first row that results are drawn on
+const queryFirstRow = 3
+
+func (screen *QueryScreen) results() *queryResults {
+	if screen.browser.query == nil {
+		return &queryResults{}
+	}
+	return screen.browser.query
+}
+
+func (screen *QueryScreen) handleKeyEvent(event termbox.Event) int {
+	r := screen.results()
+	_, h := screenDisplay.Size()
+	switch {
+	case event.Ch == 'j' || event.Key == termbox.KeyArrowDown:
+		r.idx++
+	case event.Ch == 'k' || event.Key == termbox.KeyArrowUp:
+		r.idx--
+	case event.Key == termbox.KeyCtrlF:
+		r.idx += h / 2
+	case event.Key == termbox.KeyCtrlB:
+		r.idx -= h / 2
+	case event.Ch == 'g':
+		r.idx = 0
+	case event.Ch == 'G':
+		r.idx = len(r.results) - 1
+	case event.Key == termbox.KeyEnter:
+		return screen.openResult()
+	case event.Ch == 'Q' || event.Ch == '/':
+		// Change the query
+		screen.browser.startQuery()
+		return BrowserScreenIndex
+	case event.Ch == 'q' || event.Key == termbox.KeyEsc:
+		return BrowserScreenIndex
+	}
+	screen.clampCursor()
+	return QueryScreenIndex
+}
+
+func (screen *QueryScreen) clampCursor() {
+	r := screen.results()
+	if r.idx >= len(r.results) {
+		r.idx = len(r.results) - 1
+	}
+	if r.idx < 0 {
+		r.idx = 0
+	}
+}
+
+func (screen *QueryScreen) openResult() int {
+	r := screen.results()
+	if r.idx >= len(r.results) {
+		return QueryScreenIndex
+	}
+	// If it's not there anymore the browser says so
+	screen.browser.goToQueryResult(r.idx)
+	return BrowserScreenIndex
+}
+
+func (screen *QueryScreen) performLayout() {
+	screen.clampCursor()
+}
+
+func (screen *QueryScreen) drawScreen(style Style) {
+	width, height := screenDisplay.Size()
+	r := screen.results()
+	title := fmt.Sprintf("Query: %s in %s", r.expr, displayPath(r.path))
+	fillWithChar(' ', 0, 0, width, 0, style.titleFg, style.titleBg)
+	titleX := (width - runewidth.StringWidth(title)) / 2
+	if titleX < 0 {
+		titleX = 0
+	}
+	drawStringClipped(title, titleX, 0, 0, width, style.titleFg, style.titleBg)
+	summaryFg := style.defaultFg
+	if r.errors > 0 {
+		summaryFg = style.errorFg
+	}
+	drawStringClipped(r.summary(), 1, 1, 0, width-1, summaryFg, style.defaultBg)
+
+	rows := height - queryFirstRow - 1
+	if r.idx < screen.scrollRow {
+		screen.scrollRow = r.idx
+	} else if rows > 0 && r.idx >= screen.scrollRow+rows {
+		screen.scrollRow = r.idx - rows + 1
+	}
+	// Keys get a column as wide as the widest one on the screen, up to half of it
+	keyW := 0
+	for k := 0; k < rows && screen.scrollRow+k < len(r.results); k++ {
+		if w := runewidth.StringWidth(model.Stringify([]byte(r.results[screen.scrollRow+k].key))); w > keyW {
+			keyW = w
+		}
+	}
+	if keyW > width/2 {
+		keyW = width / 2
+	}
+	for k := 0; k < rows && screen.scrollRow+k < len(r.results); k++ {
+		y := queryFirstRow + k
+		res := &r.results[screen.scrollRow+k]
+		keyFg, fg, bg := style.pairFg, style.defaultFg, style.defaultBg
+		if screen.scrollRow+k == r.idx {
+			keyFg, fg, bg = style.cursorFg, style.cursorFg, style.cursorBg
+			fillWithChar(' ', 0, y, width, y, fg, bg)
+		}
+		key := model.Stringify([]byte(res.key))
+		if runewidth.StringWidth(key) > keyW {
+			key = runewidth.Truncate(key, keyW, "…")
+		}
+		drawStringClipped(key, 1, y, 0, keyW, keyFg, bg)
+		drawStringClipped(tableCellText(res.output), keyW+3, y, 0, width-keyW-3, fg, bg)
+	}
+
+	footer := "enter go to pair, n/N in the tree for the next/previous, Q change query, q back"
+	fillWithChar(' ', 0, height-1, width, height-1, style.footerFg, style.footerBg)
+	drawStringClipped(footer, 0, height-1, 0, width, style.footerFg, style.footerBg)
+}
+
+func (screen *QueryScreen) handleMouseEvent(event termbox.Event) int {
+	r := screen.results()
+	switch event.Key {
+	case termbox.MouseWheelUp:
+		r.idx -= mouseWheelLines
+	case termbox.MouseWheelDown:
+		r.idx += mouseWheelLines
+	case termbox.MouseLeft:
+		if event.Mod&termbox.ModMotion == termbox.ModMotion || event.MouseY < queryFirstRow {
+			break
+		}
+		if row := screen.scrollRow + event.MouseY - queryFirstRow; row < len(r.results) {
+			if row == r.idx {
+				// Clicking the selected result again goes to it
+				return screen.openResult()
+			}
+			r.idx = row
+		}
+	}
+	screen.clampCursor()
+	return QueryScreenIndex
+}
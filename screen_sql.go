@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	termboxUtil "github.com/br0xen/termbox-util"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+	"go.etcd.io/bbolt"
+)
+
+// The most rows the SQL screen keeps, the query is stopped there
+const maxSQLScreenRows = 10000
+
+var errSQLRowLimit = errors.New("row limit")
+
+// sqlResults are the results of the last SQL query run in the browser
+type sqlResults struct {
+	text    string
+	from    []string
+	columns []string
+	widths  []int
+	rows    [][]string
+	// The key of the pair each row came from, nil for rows made from a group
+	keys      [][]byte
+	truncated bool
+
+	cursorRow int
+	scrollRow int
+	// The first column that's drawn
+	colOffset int
+}
+
+// startSQL asks for a SQL query, starting with a SELECT of the bucket 'T' would show
+func (screen *BrowserScreen) startSQL() bool {
+	w, h := screenDisplay.Size()
+	inpW, inpH := (w / 2), 6
+	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+	mod.SetTitle(termboxUtil.AlignText("SQL Query", inpW, termboxUtil.AlignCenter))
+	if screen.sql != nil {
+		mod.SetValue(screen.sql.text)
+	} else {
+		mod.SetValue("SELECT * FROM " + sqlBucketName(screen.tableBucketPath()) + " LIMIT 100")
+	}
+	mod.Show()
+	screen.inputModal = mod
+	screen.mode = modeSQL
+	return true
+}
+
+/*
+runSQL runs a query in a read transaction, the rows are gathered as they
+come out of the cursor until there are maxSQLScreenRows of them
+*/
+func (screen *BrowserScreen) runSQL(text string) int {
+	q, err := parseSQL(text)
+	if err != nil {
+		screen.setMessage("SQL: " + err.Error())
+		return BrowserScreenIndex
+	}
+	res := &sqlResults{text: text, from: q.from, columns: q.columnNames()}
+	for _, c := range res.columns {
+		res.widths = append(res.widths, runewidth.StringWidth(c))
+	}
+	err = db.View(func(tx *bbolt.Tx) error {
+		return q.run(tx, func(row []interface{}, key []byte) error {
+			if len(res.rows) == maxSQLScreenRows {
+				res.truncated = true
+				return errSQLRowLimit
+			}
+			cells := make([]string, len(row))
+			for i, v := range row {
+				cells[i] = tableCellText(sqlText(v))
+				if w := runewidth.StringWidth(cells[i]); w > res.widths[i] {
+					res.widths[i] = w
+				}
+			}
+			res.rows = append(res.rows, cells)
+			if key != nil {
+				key = append([]byte{}, key...)
+			}
+			res.keys = append(res.keys, key)
+			return nil
+		})
+	})
+	if err != nil && err != errSQLRowLimit {
+		screen.setMessage("SQL: " + err.Error())
+		return BrowserScreenIndex
+	}
+	for i := range res.widths {
+		if res.widths[i] > maxTableColumnWidth {
+			res.widths[i] = maxTableColumnWidth
+		}
+	}
+	screen.sql = res
+	screen.sqlLast = true
+	return SQLScreenIndex
+}
+
+func cmdSQLQuery(screen *BrowserScreen, args []string) int {
+	if len(args) == 0 {
+		if screen.sql == nil {
+			screen.startSQL()
+			return BrowserScreenIndex
+		}
+		return SQLScreenIndex
+	}
+	return screen.runSQL(args[0])
+}
+
+/*
+SQLScreen shows the results of a SQL query, rows that
+came from a pair can be picked to go to it in the tree
+*/
+type SQLScreen struct {
+	browser *BrowserScreen
+	style   Style
+}
+
+// The first row that results are drawn on, under the title, the summary and the header
+const sqlFirstRow = 3
+
+func (screen *SQLScreen) results() *sqlResults {
+	if screen.browser.sql == nil {
+		return &sqlResults{}
+	}
+	return screen.browser.sql
+}
+
+func (screen *SQLScreen) handleKeyEvent(event termbox.Event) int {
+	r := screen.results()
+	_, h := screenDisplay.Size()
+	switch {
+	case event.Ch == 'j' || event.Key == termbox.KeyArrowDown:
+		r.cursorRow++
+	case event.Ch == 'k' || event.Key == termbox.KeyArrowUp:
+		r.cursorRow--
+	case event.Key == termbox.KeyCtrlF:
+		r.cursorRow += h / 2
+	case event.Key == termbox.KeyCtrlB:
+		r.cursorRow -= h / 2
+	case event.Ch == 'g':
+		r.cursorRow = 0
+	case event.Ch == 'G':
+		r.cursorRow = len(r.rows) - 1
+	case event.Ch == 'h' || event.Key == termbox.KeyArrowLeft:
+		if r.colOffset > 0 {
+			r.colOffset--
+		}
+	case event.Ch == 'l' || event.Key == termbox.KeyArrowRight:
+		if r.colOffset < len(r.columns)-1 {
+			r.colOffset++
+		}
+	case event.Key == termbox.KeyEnter:
+		return screen.openRow()
+	case event.Ch == 'S' || event.Ch == ':':
+		// Change the query
+		screen.browser.startSQL()
+		return BrowserScreenIndex
+	case event.Key == termbox.KeyCtrlR:
+		if r.text != "" {
+			return screen.browser.runSQL(r.text)
+		}
+	case event.Ch == 'q' || event.Key == termbox.KeyEsc:
+		return BrowserScreenIndex
+	}
+	screen.clampCursor()
+	return SQLScreenIndex
+}
+
+func (screen *SQLScreen) clampCursor() {
+	r := screen.results()
+	if r.cursorRow >= len(r.rows) {
+		r.cursorRow = len(r.rows) - 1
+	}
+	if r.cursorRow < 0 {
+		r.cursorRow = 0
+	}
+}
+
+func (screen *SQLScreen) openRow() int {
+	r := screen.results()
+	if r.cursorRow >= len(r.keys) || r.keys[r.cursorRow] == nil {
+		return SQLScreenIndex
+	}
+	browser := screen.browser
+	prev := browser.currentPath
+	browser.recordJump()
+	if err := browser.goToPath(append(append([]string{}, r.from...), string(r.keys[r.cursorRow]))); err != nil {
+		browser.currentPath = prev
+		browser.setMessage(err.Error())
+	}
+	return BrowserScreenIndex
+}
+
+func (screen *SQLScreen) performLayout() {
+	screen.clampCursor()
+}
+
+func (screen *SQLScreen) drawScreen(style Style) {
+	width, height := screenDisplay.Size()
+	r := screen.results()
+	title := "SQL: " + r.text
+	fillWithChar(' ', 0, 0, width, 0, style.titleFg, style.titleBg)
+	titleX := (width - runewidth.StringWidth(title)) / 2
+	if titleX < 0 {
+		titleX = 0
+	}
+	drawStringClipped(title, titleX, 0, 0, width, style.titleFg, style.titleBg)
+	summary := fmt.Sprintf("%d rows", len(r.rows))
+	if r.truncated {
+		summary = fmt.Sprintf("The first %d rows", len(r.rows))
+	}
+	drawStringClipped(summary, 1, 1, 0, width-1, style.defaultFg, style.defaultBg)
+
+	rows := height - sqlFirstRow - 1
+	if r.cursorRow < r.scrollRow {
+		r.scrollRow = r.cursorRow
+	} else if rows > 0 && r.cursorRow >= r.scrollRow+rows {
+		r.scrollRow = r.cursorRow - rows + 1
+	}
+	// Where each column from colOffset on starts
+	xs := make(map[int]int)
+	for i, x := r.colOffset, 1; i < len(r.columns) && x < width; i++ {
+		xs[i] = x
+		x += r.widths[i] + 3
+	}
+	for i, x := range xs {
+		fillWithChar(' ', x, 2, x+r.widths[i]-1, 2, style.titleFg, style.titleBg)
+		drawStringClipped(r.columns[i], x, 2, 0, clipWidth(r.widths[i], x, width), style.titleFg, style.titleBg)
+	}
+	for k := 0; k < rows && r.scrollRow+k < len(r.rows); k++ {
+		y := sqlFirstRow + k
+		fg, bg := style.defaultFg, style.defaultBg
+		if r.scrollRow+k == r.cursorRow {
+			fg, bg = style.cursorFg, style.cursorBg
+			fillWithChar(' ', 0, y, width, y, fg, bg)
+		}
+		row := r.rows[r.scrollRow+k]
+		for i, x := range xs {
+			text, cw := row[i], clipWidth(r.widths[i], x, width)
+			if runewidth.StringWidth(text) > cw {
+				text = runewidth.Truncate(text, cw, "…")
+			}
+			drawStringClipped(text, x, y, 0, cw, fg, bg)
+			if sep := x + r.widths[i] + 1; sep < width {
+				screenDisplay.SetCell(sep, y, '|', style.treeFg, bg)
+			}
+		}
+	}
+
+	footer := "enter go to pair, h/l scroll columns, S change query, ctrl+r run again, q back"
+	fillWithChar(' ', 0, height-1, width, height-1, style.footerFg, style.footerBg)
+	drawStringClipped(footer, 0, height-1, 0, width, style.footerFg, style.footerBg)
+}
+
+func (screen *SQLScreen) handleMouseEvent(event termbox.Event) int {
+	r := screen.results()
+	switch event.Key {
+	case termbox.MouseWheelUp:
+		r.cursorRow -= mouseWheelLines
+	case termbox.MouseWheelDown:
+		r.cursorRow += mouseWheelLines
+	case termbox.MouseLeft:
+		if event.Mod&termbox.ModMotion == termbox.ModMotion || event.MouseY < sqlFirstRow {
+			break
+		}
+		if row := r.scrollRow + event.MouseY - sqlFirstRow; row < len(r.rows) {
+			if row == r.cursorRow {
+				// Clicking the selected row again goes to its pair
+				return screen.openRow()
+			}
+			r.cursorRow = row
+		}
+	}
+	screen.clampCursor()
+	return SQLScreenIndex
+}
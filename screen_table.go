@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/br0xen/boltbrowser/model"
+	termboxUtil "github.com/br0xen/termbox-util"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// How many pairs are looked at to work out a table's columns and their widths
+const tableSampleSize = 500
+
+// The widest a column is made, longer values are cut off
+const maxTableColumnWidth = 30
+
+// What's in a table column
+const (
+	columnKey = iota
+	columnField
+	// For values that aren't json objects
+	columnValue
+)
+
+type tableColumn struct {
+	name   string
+	kind   int
+	hidden bool
+	width  int
+}
+
+// tableRow is one pair of the bucket, with its value's top level fields
+type tableRow struct {
+	// The key as it is in the bucket, and as it's shown
+	rawKey string
+	key    string
+	value  string
+	// The fields as they're shown, and which of them are numbers
+	cells   map[string]string
+	numbers map[string]float64
+	object  bool
+}
+
+/*
+TableScreen shows a bucket of json objects as a table,
+one row per key and one column per top level field
+*/
+type TableScreen struct {
+	browser *BrowserScreen
+	style   Style
+	path    []string
+	loaded  bool
+
+	columns []tableColumn
+	rows    []tableRow
+	// The indexes of the rows that are shown, filtered and sorted
+	visible []int
+
+	cursorRow int
+	scrollRow int
+	// The selected column, and the first column that's drawn
+	cursorCol int
+	colOffset int
+
+	sortCol    int
+	sortDesc   bool
+	filterCol  int
+	filterText string
+
+	inputModal *termboxUtil.InputModal
+	message    string
+}
+
+// tableBucketPath is the bucket 'T' shows, the one under the cursor or the one the cursor is in
+func (screen *BrowserScreen) tableBucketPath() []string {
+	b, _, err := screen.db.getGenericFromPath(screen.currentPath)
+	if err == nil && b != nil {
+		return append([]string{}, screen.currentPath...)
+	}
+	if len(screen.currentPath) > 0 {
+		return append([]string{}, screen.currentPath[:len(screen.currentPath)-1]...)
+	}
+	return nil
+}
+
+// startTable shows the table screen if there's a bucket with pairs in it to show
+func (screen *BrowserScreen) startTable() int {
+	path := screen.tableBucketPath()
+	b, err := screen.db.getBucketFromPath(path)
+	if err != nil || len(b.pairs) == 0 {
+		screen.setMessage("There are no pairs in " + displayPath(path) + " to show as a table")
+		return BrowserScreenIndex
+	}
+	return TableScreenIndex
+}
+
+func (screen *TableScreen) handleKeyEvent(event termbox.Event) int {
+	if screen.inputModal != nil {
+		return screen.handleFilterKeyEvent(event)
+	}
+	screen.message = ""
+	_, h := screenDisplay.Size()
+	switch {
+	case event.Ch == 'j' || event.Key == termbox.KeyArrowDown:
+		screen.cursorRow++
+	case event.Ch == 'k' || event.Key == termbox.KeyArrowUp:
+		screen.cursorRow--
+	case event.Key == termbox.KeyCtrlF:
+		screen.cursorRow += h / 2
+	case event.Key == termbox.KeyCtrlB:
+		screen.cursorRow -= h / 2
+	case event.Ch == 'g':
+		screen.cursorRow = 0
+	case event.Ch == 'G':
+		screen.cursorRow = len(screen.visible) - 1
+	case event.Ch == 'h' || event.Key == termbox.KeyArrowLeft:
+		screen.moveCursorCol(-1)
+	case event.Ch == 'l' || event.Key == termbox.KeyArrowRight:
+		screen.moveCursorCol(1)
+	case event.Ch == 's':
+		screen.cycleSort()
+	case event.Ch == '/':
+		screen.startFilter()
+	case event.Ch == 'x':
+		screen.hideColumn()
+	case event.Ch == 'a':
+		for i := range screen.columns {
+			screen.columns[i].hidden = false
+		}
+	case event.Ch == '<':
+		screen.moveColumn(-1)
+	case event.Ch == '>':
+		screen.moveColumn(1)
+	case event.Key == termbox.KeyEnter:
+		return screen.openRow()
+	case event.Key == termbox.KeyCtrlR:
+		screen.loaded = false
+	case event.Ch == 'q' || event.Key == termbox.KeyEsc:
+		return BrowserScreenIndex
+	}
+	screen.clampCursor()
+	return TableScreenIndex
+}
+
+func (screen *TableScreen) handleFilterKeyEvent(event termbox.Event) int {
+	if event.Key == termbox.KeyEsc {
+		screen.inputModal = nil
+		return TableScreenIndex
+	}
+	screen.inputModal.HandleEvent(event)
+	if screen.inputModal.IsDone() {
+		screen.filterCol = screen.cursorCol
+		screen.filterText = screen.inputModal.GetValue()
+		screen.inputModal = nil
+		screen.applyView()
+		screen.cursorRow = 0
+	}
+	return TableScreenIndex
+}
+
+func (screen *TableScreen) performLayout() {
+	path := screen.browser.tableBucketPath()
+	if screen.loaded && comparePaths(path, screen.path) {
+		return
+	}
+	if !comparePaths(path, screen.path) {
+		// A different bucket, start over
+		screen.columns = nil
+		screen.cursorRow, screen.scrollRow, screen.cursorCol, screen.colOffset = 0, 0, 0, 0
+		screen.sortCol, screen.sortDesc = -1, false
+		screen.filterCol, screen.filterText = -1, ""
+	}
+	screen.load(path)
+}
+
+/*
+load reads the rows of the bucket at 'path'. The columns are the union of
+the top level fields of the first tableSampleSize values, in the order
+they're first seen. Columns that were already there keep their place.
+*/
+func (screen *TableScreen) load(path []string) {
+	screen.path = path
+	screen.loaded = true
+	screen.rows = nil
+	b, err := screen.browser.db.getBucketFromPath(path)
+	if err != nil {
+		screen.message = err.Error()
+		screen.applyView()
+		return
+	}
+	var names []string
+	seen := make(map[string]bool)
+	anyValues := false
+	for i := range b.pairs {
+		row := newTableRow(b.pairs[i].key, []byte(b.pairs[i].val))
+		screen.rows = append(screen.rows, row)
+		if i >= tableSampleSize {
+			continue
+		}
+		if !row.object {
+			anyValues = true
+		}
+		var fields []string
+		for f := range row.cells {
+			if !seen[f] {
+				fields = append(fields, f)
+			}
+		}
+		// Maps don't keep the order the fields were in
+		sort.Slice(fields, func(x, y int) bool {
+			return row.fieldOrder(fields[x]) < row.fieldOrder(fields[y])
+		})
+		for _, f := range fields {
+			seen[f] = true
+			names = append(names, f)
+		}
+	}
+
+	cols := []tableColumn{{name: "key", kind: columnKey}}
+	for _, nm := range names {
+		cols = append(cols, tableColumn{name: nm, kind: columnField})
+	}
+	if anyValues {
+		cols = append(cols, tableColumn{name: "(value)", kind: columnValue})
+	}
+	screen.columns = mergeColumns(screen.columns, cols)
+	for i := range screen.columns {
+		screen.columns[i].width = screen.columnWidth(&screen.columns[i])
+	}
+	screen.applyView()
+	screen.clampCursor()
+}
+
+// mergeColumns keeps the order and hidden columns from 'old' for the columns that are still in 'cols'
+func mergeColumns(old, cols []tableColumn) []tableColumn {
+	if len(old) == 0 {
+		return cols
+	}
+	var ret []tableColumn
+	used := make(map[string]bool)
+	for _, o := range old {
+		for _, c := range cols {
+			if c.kind == o.kind && c.name == o.name {
+				c.hidden = o.hidden
+				ret = append(ret, c)
+				used[fmt.Sprint(c.kind, c.name)] = true
+			}
+		}
+	}
+	for _, c := range cols {
+		if !used[fmt.Sprint(c.kind, c.name)] {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func newTableRow(key string, val []byte) tableRow {
+	row := tableRow{rawKey: key, key: model.Stringify([]byte(key)), value: model.Stringify(val)}
+	dec := json.NewDecoder(bytes.NewReader(val))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if dec.Decode(&obj) != nil || obj == nil {
+		return row
+	}
+	row.object = true
+	row.cells = make(map[string]string, len(obj))
+	row.numbers = make(map[string]float64)
+	for f, v := range obj {
+		row.cells[f] = tableCellText(v)
+		if n, ok := v.(json.Number); ok {
+			if fl, err := n.Float64(); err == nil {
+				row.numbers[f] = fl
+			}
+		}
+	}
+	// Keep the json, fieldOrder looks for the fields in it
+	row.value = string(val)
+	return row
+}
+
+// fieldOrder is where field 'f' is in the row's json, so columns can be put in the same order
+func (row *tableRow) fieldOrder(f string) int {
+	quoted, _ := json.Marshal(f)
+	if i := strings.Index(row.value, string(quoted)); i >= 0 {
+		return i
+	}
+	return len(row.value)
+}
+
+// tableCellText is how a json value is shown in a cell, on one line
+func tableCellText(v interface{}) string {
+	var text string
+	switch t := v.(type) {
+	case nil:
+		text = "null"
+	case string:
+		text = t
+	case json.Number:
+		text = t.String()
+	case bool:
+		text = strconv.FormatBool(t)
+	default:
+		out, _ := json.Marshal(t)
+		text = string(out)
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || (r >= 0x7f && r <= 0x9f) {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// cell is the text of 'col' for 'row', and whether the row has anything there
+func (screen *TableScreen) cell(row *tableRow, col *tableColumn) (string, bool) {
+	switch col.kind {
+	case columnKey:
+		return row.key, true
+	case columnValue:
+		if row.object {
+			return "", false
+		}
+		return row.value, true
+	}
+	text, ok := row.cells[col.name]
+	return text, ok
+}
+
+// columnWidth fits the column to its header and the values in the sample
+func (screen *TableScreen) columnWidth(col *tableColumn) int {
+	w := runewidth.StringWidth(col.name) + 2
+	for i := range screen.rows {
+		if i >= tableSampleSize || w >= maxTableColumnWidth {
+			break
+		}
+		text, _ := screen.cell(&screen.rows[i], col)
+		if cw := runewidth.StringWidth(text); cw > w {
+			w = cw
+		}
+	}
+	if w > maxTableColumnWidth {
+		w = maxTableColumnWidth
+	}
+	return w
+}
+
+// applyView works out which rows are shown, and in what order
+func (screen *TableScreen) applyView() {
+	screen.visible = screen.visible[:0]
+	filter := strings.ToLower(screen.filterText)
+	for i := range screen.rows {
+		if filter != "" && screen.filterCol >= 0 && screen.filterCol < len(screen.columns) {
+			text, _ := screen.cell(&screen.rows[i], &screen.columns[screen.filterCol])
+			if !strings.Contains(strings.ToLower(text), filter) {
+				continue
+			}
+		}
+		screen.visible = append(screen.visible, i)
+	}
+	if screen.sortCol < 0 || screen.sortCol >= len(screen.columns) {
+		return
+	}
+	col := &screen.columns[screen.sortCol]
+	sort.SliceStable(screen.visible, func(i, j int) bool {
+		a, b := &screen.rows[screen.visible[i]], &screen.rows[screen.visible[j]]
+		at, aok := screen.cell(a, col)
+		bt, bok := screen.cell(b, col)
+		if aok != bok {
+			// Rows without the field go last, whichever way it's sorted
+			return aok
+		}
+		an, aNum := a.numbers[col.name]
+		bn, bNum := b.numbers[col.name]
+		if col.kind == columnField && aNum && bNum {
+			if an == bn {
+				return false
+			}
+			return (an < bn) != screen.sortDesc
+		}
+		if at == bt {
+			return false
+		}
+		return naturalLess(at, bt) != screen.sortDesc
+	})
+}
+
+// cycleSort sorts by the selected column, then the other way, then back to the bucket's order
+func (screen *TableScreen) cycleSort() {
+	if screen.sortCol != screen.cursorCol {
+		screen.sortCol, screen.sortDesc = screen.cursorCol, false
+	} else if !screen.sortDesc {
+		screen.sortDesc = true
+	} else {
+		screen.sortCol, screen.sortDesc = -1, false
+	}
+	screen.applyView()
+}
+
+func (screen *TableScreen) startFilter() {
+	w, h := screenDisplay.Size()
+	inpW, inpH := (w / 2), 6
+	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+	mod.SetTitle(termboxUtil.AlignText("Filter "+screen.columns[screen.cursorCol].name, inpW, termboxUtil.AlignCenter))
+	if screen.filterCol == screen.cursorCol {
+		mod.SetValue(screen.filterText)
+	}
+	mod.Show()
+	screen.inputModal = mod
+}
+
+func (screen *TableScreen) hideColumn() {
+	shown := 0
+	for _, c := range screen.columns {
+		if !c.hidden {
+			shown++
+		}
+	}
+	if shown <= 1 {
+		screen.message = "Can't hide the last column"
+		return
+	}
+	screen.columns[screen.cursorCol].hidden = true
+	screen.moveCursorCol(1)
+	if screen.columns[screen.cursorCol].hidden {
+		screen.moveCursorCol(-1)
+	}
+}
+
+// moveColumn swaps the selected column with the next shown one in direction 'dir'
+func (screen *TableScreen) moveColumn(dir int) {
+	for i := screen.cursorCol + dir; i >= 0 && i < len(screen.columns); i += dir {
+		if screen.columns[i].hidden {
+			continue
+		}
+		screen.columns[i], screen.columns[screen.cursorCol] = screen.columns[screen.cursorCol], screen.columns[i]
+		// The sort and filter follow their columns
+		for _, idx := range []*int{&screen.sortCol, &screen.filterCol} {
+			if *idx == i {
+				*idx = screen.cursorCol
+			} else if *idx == screen.cursorCol {
+				*idx = i
+			}
+		}
+		screen.cursorCol = i
+		return
+	}
+}
+
+// moveCursorCol selects the next shown column in direction 'dir'
+func (screen *TableScreen) moveCursorCol(dir int) {
+	for i := screen.cursorCol + dir; i >= 0 && i < len(screen.columns); i += dir {
+		if !screen.columns[i].hidden {
+			screen.cursorCol = i
+			return
+		}
+	}
+}
+
+func (screen *TableScreen) clampCursor() {
+	if screen.cursorRow >= len(screen.visible) {
+		screen.cursorRow = len(screen.visible) - 1
+	}
+	if screen.cursorRow < 0 {
+		screen.cursorRow = 0
+	}
+	if screen.cursorCol >= len(screen.columns) {
+		screen.cursorCol = len(screen.columns) - 1
+	}
+	if screen.cursorCol < 0 {
+		screen.cursorCol = 0
+	}
+}
+
+// openRow goes to the selected row's pair in the browser
+func (screen *TableScreen) openRow() int {
+	if screen.cursorRow >= len(screen.visible) {
+		return TableScreenIndex
+	}
+	row := &screen.rows[screen.visible[screen.cursorRow]]
+	browser := screen.browser
+	prev := browser.currentPath
+	browser.recordJump()
+	if err := browser.goToPath(append(append([]string{}, screen.path...), row.rawKey)); err != nil {
+		browser.currentPath = prev
+		screen.message = err.Error() + ", ctrl+r to reload"
+		return TableScreenIndex
+	}
+	return BrowserScreenIndex
+}
+
+// clipWidth is how much of a column 'w' wide at 'x' fits on a screen 'width' wide
+func clipWidth(w, x, width int) int {
+	if x+w > width {
+		return width - x
+	}
+	return w
+}
+
+// The first row that rows are drawn on, under the title, the sort/filter line and the header
+const tableFirstRow = 3
+
+// shownColumns are the indexes of the columns that aren't hidden
+func (screen *TableScreen) shownColumns() []int {
+	var ret []int
+	for i, c := range screen.columns {
+		if !c.hidden {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// columnX works out where each of the columns from colOffset on is drawn, up to 'width'
+func (screen *TableScreen) columnX(width int) map[int]int {
+	shown := screen.shownColumns()
+	// Keep the selected column on the screen
+	for k, idx := range shown {
+		if idx == screen.cursorCol && k < screen.colOffset {
+			screen.colOffset = k
+		}
+	}
+	for {
+		ret := make(map[int]int)
+		x := 1
+		if screen.colOffset > len(shown) {
+			screen.colOffset = len(shown)
+		}
+		for _, idx := range shown[screen.colOffset:] {
+			if x >= width {
+				break
+			}
+			ret[idx] = x
+			x += screen.columns[idx].width + 3
+		}
+		cx, ok := ret[screen.cursorCol]
+		if screen.colOffset >= len(shown)-1 || (ok && cx+screen.columns[screen.cursorCol].width <= width) || (ok && cx == 1) {
+			return ret
+		}
+		screen.colOffset++
+	}
+}
+
+func (screen *TableScreen) drawScreen(style Style) {
+	width, height := screenDisplay.Size()
+	title := fmt.Sprintf("Table: %s (%d of %d rows)", displayPath(screen.path), len(screen.visible), len(screen.rows))
+	fillWithChar(' ', 0, 0, width, 0, style.titleFg, style.titleBg)
+	titleX := (width - runewidth.StringWidth(title)) / 2
+	if titleX < 0 {
+		titleX = 0
+	}
+	drawStringClipped(title, titleX, 0, 0, width, style.titleFg, style.titleBg)
+
+	var info []string
+	if screen.sortCol >= 0 && screen.sortCol < len(screen.columns) {
+		dir := "ascending"
+		if screen.sortDesc {
+			dir = "descending"
+		}
+		info = append(info, "Sorted by "+screen.columns[screen.sortCol].name+", "+dir)
+	}
+	if screen.filterText != "" && screen.filterCol >= 0 && screen.filterCol < len(screen.columns) {
+		info = append(info, "Filter: "+screen.columns[screen.filterCol].name+" contains "+screen.filterText)
+	}
+	drawStringClipped(strings.Join(info, "  "), 1, 1, 0, width-1, style.defaultFg, style.defaultBg)
+
+	rows := height - tableFirstRow - 1
+	if screen.cursorRow < screen.scrollRow {
+		screen.scrollRow = screen.cursorRow
+	} else if rows > 0 && screen.cursorRow >= screen.scrollRow+rows {
+		screen.scrollRow = screen.cursorRow - rows + 1
+	}
+	xs := screen.columnX(width)
+	for idx, x := range xs {
+		col := &screen.columns[idx]
+		fg, bg := style.titleFg, style.titleBg
+		if idx == screen.cursorCol {
+			fg, bg = style.cursorFg, style.cursorBg
+		}
+		name := col.name
+		if idx == screen.sortCol {
+			if screen.sortDesc {
+				name += " ↓"
+			} else {
+				name += " ↑"
+			}
+		}
+		fillWithChar(' ', x, 2, x+col.width-1, 2, fg, bg)
+		drawStringClipped(name, x, 2, 0, clipWidth(col.width, x, width), fg, bg)
+	}
+	for k := 0; k < rows && screen.scrollRow+k < len(screen.visible); k++ {
+		y := tableFirstRow + k
+		row := &screen.rows[screen.visible[screen.scrollRow+k]]
+		fg, bg := style.defaultFg, style.defaultBg
+		if screen.scrollRow+k == screen.cursorRow {
+			fg, bg = style.cursorFg, style.cursorBg
+			fillWithChar(' ', 0, y, width, y, fg, bg)
+		}
+		for idx, x := range xs {
+			col := &screen.columns[idx]
+			text, _ := screen.cell(row, col)
+			cw := clipWidth(col.width, x, width)
+			if runewidth.StringWidth(text) > cw {
+				text = runewidth.Truncate(text, cw, "…")
+			}
+			cellFg := fg
+			if col.kind == columnKey && fg == style.defaultFg {
+				cellFg = style.pairFg
+			}
+			drawStringClipped(text, x, y, 0, cw, cellFg, bg)
+			if sep := x + col.width + 1; sep < width {
+				screenDisplay.SetCell(sep, y, '|', style.treeFg, bg)
+			}
+		}
+	}
+
+	footer := screen.message
+	if footer == "" {
+		footer = "enter go to pair, s sort, / filter, x hide, a show all, < > move column, q back"
+	}
+	fillWithChar(' ', 0, height-1, width, height-1, style.footerFg, style.footerBg)
+	drawStringClipped(footer, 0, height-1, 0, width, style.footerFg, style.footerBg)
+	if screen.inputModal != nil {
+		drawInputModal(screen.inputModal)
+	}
+}
+
+func (screen *TableScreen) handleMouseEvent(event termbox.Event) int {
+	if screen.inputModal != nil {
+		return TableScreenIndex
+	}
+	switch event.Key {
+	case termbox.MouseWheelUp:
+		screen.cursorRow -= mouseWheelLines
+	case termbox.MouseWheelDown:
+		screen.cursorRow += mouseWheelLines
+	case termbox.MouseLeft:
+		if event.Mod&termbox.ModMotion == termbox.ModMotion {
+			break
+		}
+		w, _ := screenDisplay.Size()
+		for idx, x := range screen.columnX(w) {
+			if event.MouseX >= x && event.MouseX < x+screen.columns[idx].width+3 {
+				screen.cursorCol = idx
+			}
+		}
+		if row := screen.scrollRow + event.MouseY - tableFirstRow; event.MouseY >= tableFirstRow && row < len(screen.visible) {
+			if row == screen.cursorRow {
+				// Clicking the selected row again opens it
+				return screen.openRow()
+			}
+			screen.cursorRow = row
+		} else if event.MouseY == 2 {
+			screen.cycleSort()
+		}
+	}
+	screen.clampCursor()
+	return TableScreenIndex
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/br0xen/boltbrowser/model"
+	"github.com/vmihailenco/msgpack/v5"
+	lua "github.com/yuin/gopher-lua"
+	"go.etcd.io/bbolt"
+)
+
+/*
+Lua scripts for bulk changes to a DB. The whole script runs in one
+transaction, so it either all happens or none of it does. Scripts get:
+
+	each(path, function(key, value) ... end)  -- return false to stop
+	get(path, key), put(path, key, value), delete(path, key)
+	buckets(path), createBucket(path)
+	json.encode(v), json.decode(s), msgpack.encode(v), msgpack.decode(s)
+	arg  -- the arguments after the DB file
+
+Paths are written like on the command line ("users/42", a / in a name is \/),
+or as a table of names ({"users", "42"}).
+*/
+
+// errDryRun rolls the transaction back at the end of a dry run
+var errDryRun = errors.New("dry run")
+
+// scriptReport counts what a script did
+type scriptReport struct {
+	puts    int
+	deletes int
+	buckets int
+}
+
+func (r scriptReport) String() string {
+	return fmt.Sprintf("%d puts, %d deletes, %d buckets created", r.puts, r.deletes, r.buckets)
+}
+
+/*
+runScript runs the Lua script 'src' against 'bdb' in one db.Update. Anything
+the script prints goes to 'out'. When 'dryRun' is set everything is done
+the same way, then rolled back.
+*/
+func runScript(bdb *bbolt.DB, name, src string, args []string, dryRun bool, out io.Writer) (scriptReport, error) {
+	var report scriptReport
+	err := bdb.Update(func(tx *bbolt.Tx) error {
+		L := lua.NewState()
+		defer L.Close()
+		registerScriptAPI(L, tx, &report, out)
+		argTable := L.NewTable()
+		for _, a := range args {
+			argTable.Append(lua.LString(a))
+		}
+		L.SetGlobal("arg", argTable)
+		fn, err := L.Load(strings.NewReader(src), name)
+		if err != nil {
+			return errors.New(strings.TrimSpace(err.Error()))
+		}
+		L.Push(fn)
+		if err := L.PCall(0, lua.MultRet, nil); err != nil {
+			// Just the message, without Lua's stack traceback
+			if apiErr, ok := err.(*lua.ApiError); ok {
+				return errors.New(apiErr.Object.String())
+			}
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		err = nil
+	}
+	return report, err
+}
+
+func registerScriptAPI(L *lua.LState, tx *bbolt.Tx, report *scriptReport, out io.Writer) {
+	// The bucket at the path in argument 'n', which has to be there
+	bucketArg := func(n int) *bbolt.Bucket {
+		path := scriptPathArg(L, n)
+		b, err := model.TxBucket(tx, path)
+		if err != nil {
+			L.RaiseError("no bucket %s", displayPath(path))
+		}
+		return b
+	}
+	// Bolt only lets pairs go in buckets, not the root
+	pairBucketArg := func(n int) *bbolt.Bucket {
+		if len(scriptPathArg(L, n)) == 0 {
+			L.RaiseError("pairs can't be in the root, only buckets")
+		}
+		return bucketArg(n)
+	}
+	funcs := map[string]lua.LGFunction{
+		"print": func(L *lua.LState) int {
+			var parts []string
+			for i := 1; i <= L.GetTop(); i++ {
+				parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+			}
+			fmt.Fprintln(out, strings.Join(parts, "\t"))
+			return 0
+		},
+		"each": func(L *lua.LState) int {
+			b := bucketArg(1)
+			fn := L.CheckFunction(2)
+			// Gather the pairs first, so the function can change the bucket as it goes
+			type pair struct{ k, v []byte }
+			var pairs []pair
+			b.ForEach(func(k, v []byte) error {
+				if v != nil {
+					pairs = append(pairs, pair{append([]byte{}, k...), append([]byte{}, v...)})
+				}
+				return nil
+			})
+			for _, p := range pairs {
+				L.Push(fn)
+				L.Push(lua.LString(p.k))
+				L.Push(lua.LString(p.v))
+				L.Call(2, 1)
+				ret := L.Get(-1)
+				L.Pop(1)
+				if ret == lua.LFalse {
+					break
+				}
+			}
+			return 0
+		},
+		"get": func(L *lua.LState) int {
+			v := pairBucketArg(1).Get([]byte(L.CheckString(2)))
+			if v == nil {
+				L.Push(lua.LNil)
+			} else {
+				L.Push(lua.LString(v))
+			}
+			return 1
+		},
+		"put": func(L *lua.LState) int {
+			b := pairBucketArg(1)
+			if err := b.Put([]byte(L.CheckString(2)), []byte(L.CheckString(3))); err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			report.puts++
+			return 0
+		},
+		"delete": func(L *lua.LState) int {
+			b := bucketArg(1)
+			key := []byte(L.CheckString(2))
+			var err error
+			if b.Bucket(key) != nil {
+				err = b.DeleteBucket(key)
+			} else if b.Get(key) != nil {
+				err = b.Delete(key)
+			} else {
+				// Like bolt's Delete, a key that isn't there is fine
+				return 0
+			}
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			report.deletes++
+			return 0
+		},
+		"buckets": func(L *lua.LState) int {
+			b := bucketArg(1)
+			ret := L.NewTable()
+			b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					ret.Append(lua.LString(k))
+				}
+				return nil
+			})
+			L.Push(ret)
+			return 1
+		},
+		"createBucket": func(L *lua.LState) int {
+			path := scriptPathArg(L, 1)
+			if len(path) == 0 {
+				L.ArgError(1, "expected a bucket")
+			}
+			var b *bbolt.Bucket
+			for i, name := range path {
+				var existing *bbolt.Bucket
+				if i == 0 {
+					existing = tx.Bucket([]byte(name))
+				} else {
+					existing = b.Bucket([]byte(name))
+				}
+				if existing != nil {
+					b = existing
+					continue
+				}
+				var err error
+				if i == 0 {
+					b, err = tx.CreateBucket([]byte(name))
+				} else {
+					b, err = b.CreateBucket([]byte(name))
+				}
+				if err != nil {
+					L.RaiseError("%s: %s", displayPath(path[:i+1]), err.Error())
+				}
+				report.buckets++
+			}
+			return 0
+		},
+	}
+	for name, fn := range funcs {
+		L.SetGlobal(name, L.NewFunction(fn))
+	}
+
+	L.SetGlobal("json", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"encode": func(L *lua.LState) int {
+			out, err := json.Marshal(luaToGo(L.CheckAny(1)))
+			if err != nil {
+				L.RaiseError("json.encode: %s", err.Error())
+			}
+			L.Push(lua.LString(out))
+			return 1
+		},
+		"decode": func(L *lua.LState) int {
+			var v interface{}
+			if err := json.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+				L.RaiseError("json.decode: %s", err.Error())
+			}
+			L.Push(goToLua(L, v))
+			return 1
+		},
+	}))
+	L.SetGlobal("msgpack", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"encode": func(L *lua.LState) int {
+			out, err := msgpack.Marshal(luaToGo(L.CheckAny(1)))
+			if err != nil {
+				L.RaiseError("msgpack.encode: %s", err.Error())
+			}
+			L.Push(lua.LString(out))
+			return 1
+		},
+		"decode": func(L *lua.LState) int {
+			var v interface{}
+			if err := msgpack.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+				L.RaiseError("msgpack.decode: %s", err.Error())
+			}
+			L.Push(goToLua(L, v))
+			return 1
+		},
+	}))
+}
+
+// scriptPathArg reads a bucket path, either "a/b" or {"a", "b"}
+func scriptPathArg(L *lua.LState, n int) []string {
+	switch v := L.Get(n).(type) {
+	case lua.LString:
+		s := strings.Trim(string(v), "/")
+		if s == "" {
+			return nil
+		}
+		return splitPathArg(s)
+	case *lua.LTable:
+		var ret []string
+		for i := 1; i <= v.Len(); i++ {
+			ret = append(ret, v.RawGetInt(i).String())
+		}
+		return ret
+	}
+	L.ArgError(n, "expected a bucket path")
+	return nil
+}
+
+// luaToGo converts a Lua value for json or msgpack
+func luaToGo(v lua.LValue) interface{} {
+	switch t := v.(type) {
+	case lua.LBool:
+		return bool(t)
+	case lua.LNumber:
+		if f := float64(t); f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return float64(t)
+	case lua.LString:
+		return string(t)
+	case *lua.LTable:
+		// A table with keys 1 to n (and nothing else) is an array
+		if n := t.Len(); n > 0 {
+			isArray := true
+			t.ForEach(func(k, _ lua.LValue) {
+				if num, ok := k.(lua.LNumber); !ok || float64(num) != math.Trunc(float64(num)) || int(num) < 1 || int(num) > n {
+					isArray = false
+				}
+			})
+			if isArray {
+				arr := make([]interface{}, n)
+				for i := 1; i <= n; i++ {
+					arr[i-1] = luaToGo(t.RawGetInt(i))
+				}
+				return arr
+			}
+		}
+		obj := make(map[string]interface{})
+		t.ForEach(func(k, val lua.LValue) {
+			obj[k.String()] = luaToGo(val)
+		})
+		return obj
+	}
+	return nil
+}
+
+// goToLua converts something that came out of json or msgpack for Lua
+func goToLua(L *lua.LState, v interface{}) lua.LValue {
+	switch t := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(t)
+	case string:
+		return lua.LString(t)
+	case float64:
+		return lua.LNumber(t)
+	case float32:
+		return lua.LNumber(t)
+	case int8:
+		return lua.LNumber(t)
+	case int16:
+		return lua.LNumber(t)
+	case int32:
+		return lua.LNumber(t)
+	case int64:
+		return lua.LNumber(t)
+	case uint8:
+		return lua.LNumber(t)
+	case uint16:
+		return lua.LNumber(t)
+	case uint32:
+		return lua.LNumber(t)
+	case uint64:
+		return lua.LNumber(t)
+	case []byte:
+		// msgpack's binary, Lua strings are bytes anyway
+		return lua.LString(t)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = val
+		}
+		return goToLua(L, m)
+	case []interface{}:
+		tbl := L.NewTable()
+		for _, item := range t {
+			tbl.Append(goToLua(L, item))
+		}
+		return tbl
+	case map[string]interface{}:
+		tbl := L.NewTable()
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			tbl.RawSetString(k, goToLua(L, t[k]))
+		}
+		return tbl
+	}
+	return lua.LString(fmt.Sprint(v))
+}
+
+func cmdRunScript(opts map[string]string, args []string) error {
+	if len(args) < 2 {
+		return errors.New("expected <script.lua> <db file> [args...]")
+	}
+	src, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	bdb, err := openSubCommandDB(args[1], false)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	dryRun := opts["-dry-run"] == "true"
+	report, err := runScript(bdb, args[0], string(src), args[2:], dryRun, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Printf("Dry run of %s: %s, nothing was changed\n", args[0], report)
+	} else {
+		fmt.Printf("Ran %s: %s\n", args[0], report)
+	}
+	return nil
+}
+
+// cmdRun runs a script from the ':' command line, then reloads the tree
+func cmdRun(screen *BrowserScreen, args []string) int {
+	dryRun := len(args) > 0 && (args[0] == "-dry-run" || args[0] == "-n")
+	if dryRun {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		screen.setMessage("Usage: run [-dry-run] <script.lua> [args...]")
+		return BrowserScreenIndex
+	}
+	src, err := os.ReadFile(args[0])
+	if err != nil {
+		screen.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+	if AppArgs.ReadOnly {
+		// Even a dry run needs a write transaction to roll back
+		screen.setMessage("Scripts can't be run in Read-Only Mode")
+		return BrowserScreenIndex
+	}
+	if !dryRun {
+		if err := checkWritable(); err != nil {
+			screen.setMessage(err.Error())
+			return BrowserScreenIndex
+		}
+	}
+	var out strings.Builder
+	report, err := runScript(db, args[0], string(src), args[1:], dryRun, &out)
+	if err != nil {
+		screen.setMessage(err.Error())
+		return BrowserScreenIndex
+	}
+	msg := "Ran " + args[0] + ": " + report.String()
+	if dryRun {
+		msg = "Dry run of " + args[0] + ": " + report.String() + ", nothing was changed"
+	} else {
+		screen.refreshDatabase()
+	}
+	// There's only the one line for what it printed
+	if printed := strings.TrimSpace(out.String()); printed != "" {
+		msg += " | " + strings.ReplaceAll(printed, "\n", " / ")
+	}
+	screen.setMessage(msg)
+	return BrowserScreenIndex
+}
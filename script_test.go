@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// dumpTestDB lists every bucket and pair in 'bdb', one a line
+func dumpTestDB(t *testing.T, bdb *bbolt.DB) string {
+	t.Helper()
+	var lines []string
+	var walk func(path string, b *bbolt.Bucket) error
+	walk = func(path string, b *bbolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				lines = append(lines, path+string(k)+"/")
+				return walk(path+string(k)+"/", b.Bucket(k))
+			}
+			lines = append(lines, path+string(k)+" = "+string(v))
+			return nil
+		})
+	}
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(nm []byte, b *bbolt.Bucket) error {
+			lines = append(lines, string(nm)+"/")
+			return walk(string(nm)+"/", b)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Marks the users that are json, archives the failed ones and deletes the rest
+const testScript = `
+createBucket("archive/old")
+each("users", function(k, v)
+  local ok, u = pcall(json.decode, v)
+  if not ok then
+    delete("users", k)
+    return
+  end
+  u.seen = true
+  put("users", k, json.encode(u))
+  if u.status == "failed" then
+    put({"archive", "old"}, k, msgpack.encode(u))
+  end
+end)
+local m = msgpack.decode(get("archive/old", "u2"))
+print(m.name, #buckets("users"), arg[1])
+`
+
+func TestScriptDryRun(t *testing.T) {
+	bdb := openSQLTestDB(t)
+	before := dumpTestDB(t, bdb)
+
+	var out bytes.Buffer
+	report, err := runScript(bdb, "test.lua", testScript, []string{"dry"}, true, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.String(); got != "4 puts, 1 deletes, 2 buckets created" {
+		t.Errorf("report is %q", got)
+	}
+	if out.String() != "bob\t1\tdry\n" {
+		t.Errorf("printed %q", out.String())
+	}
+	if after := dumpTestDB(t, bdb); after != before {
+		t.Errorf("a dry run changed the DB:\n%s", after)
+	}
+
+	// The same script for real does what the dry run said
+	out.Reset()
+	wet, err := runScript(bdb, "test.lua", testScript, []string{"wet"}, false, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wet != report {
+		t.Errorf("report is %q, the dry run's was %q", wet, report)
+	}
+	after := dumpTestDB(t, bdb)
+	for _, want := range []string{
+		"archive/old/u2 = ",
+		`users/u1 = {"address":{"city":"Oslo"},"age":30,"name":"alice","seen":true,"status":"active"}`,
+	} {
+		if !strings.Contains(after, want) {
+			t.Errorf("expected %q in:\n%s", want, after)
+		}
+	}
+	if strings.Contains(after, "users/u4") {
+		t.Errorf("users/u4 wasn't deleted:\n%s", after)
+	}
+}
+
+func TestScriptErrorRollsBack(t *testing.T) {
+	bdb := openSQLTestDB(t)
+	before := dumpTestDB(t, bdb)
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{`put("users", "u1", "changed") createBucket("new") error("stop here")`, "stop here"},
+		{`delete("users", "u2") put("nope", "k", "v")`, "no bucket nope"},
+		{`createBucket("new") put("", "k", "v")`, "pairs can't be in the root"},
+		{`put("users", "u1", "changed") each("users", function(k, v) local x = nil .. k end)`, "cannot perform concat"},
+		{`put("users", "u1"`, "test.lua"},
+	} {
+		_, err := runScript(bdb, "test.lua", tc.src, nil, false, new(bytes.Buffer))
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.src)
+		} else if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: the error %q doesn't say %q", tc.src, err.Error(), tc.want)
+		}
+		if after := dumpTestDB(t, bdb); after != before {
+			t.Errorf("%s: the DB was changed:\n%s", tc.src, after)
+		}
+	}
+}
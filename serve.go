@@ -0,0 +1,392 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/br0xen/boltbrowser/model"
+	"go.etcd.io/bbolt"
+)
+
+/*
+The serve command puts a REST API and a small web UI in front of a DB.
+Reads are open to anyone who can reach the address, changes need the
+token, which is made up at startup unless one is given with -token
+(or $BOLTBROWSER_TOKEN). Changes go through the same functions as the
+browser's, so -readonly and -autobackup work the same way.
+
+	GET    /api/info                        the file and whether it's read-only
+	GET    /api/buckets?path=a/b            the buckets and pairs in a bucket
+	POST   /api/buckets?path=a/b&name=c     create a bucket
+	DELETE /api/buckets?path=a/b            delete a bucket
+	GET    /api/pair?path=a/b&key=k         a pair's value (raw=true for just the bytes)
+	PUT    /api/pair?path=a/b&key=k         set a pair to the request body
+	DELETE /api/pair?path=a/b&key=k         delete a pair
+	GET    /api/search?path=a/b&q=text      keys under a bucket containing text (values=true for values too)
+	GET    /api/export?path=a/b[&key=k]     a bucket as json, or a pair's value
+
+Paths are written like on the command line, a / in a name is \/.
+*/
+
+//go:embed web
+var webFiles embed.FS
+
+const (
+	defaultServeAddr = "127.0.0.1:8080"
+	// The most pairs /api/buckets lists at once, 'after' gets the next ones
+	maxServeListing = 1000
+	// The most results /api/search comes back with
+	maxServeSearchResults = 1000
+	// The biggest value that can be PUT
+	maxServePutSize = 64 << 20
+)
+
+type server struct {
+	token    string
+	readOnly bool
+	// checkWritable and the auto backup aren't safe to run at the same time
+	writeMu sync.Mutex
+}
+
+func cmdServe(opts map[string]string, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected <db file>")
+	}
+	addr := opts["-addr"]
+	if addr == "" {
+		addr = defaultServeAddr
+	}
+	AppArgs.ReadOnly = opts["-readonly"] == "true" || opts["-ro"] == "true"
+	AppArgs.AutoBackup = opts["-autobackup"] == "true"
+	srv := &server{token: opts["-token"], readOnly: AppArgs.ReadOnly}
+	if srv.token == "" {
+		srv.token = os.Getenv("BOLTBROWSER_TOKEN")
+	}
+	if srv.token == "" && !srv.readOnly {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		srv.token = hex.EncodeToString(buf)
+	}
+
+	var err error
+	if db, err = openSubCommandDB(args[0], AppArgs.ReadOnly); err != nil {
+		return err
+	}
+	defer db.Close()
+	currentFilename = args[0]
+
+	if srv.readOnly {
+		fmt.Printf("Serving %s (read-only) on http://%s/\n", args[0], addr)
+	} else {
+		fmt.Printf("Serving %s on http://%s/\nToken for changes: %s\n", args[0], addr, srv.token)
+	}
+	return http.ListenAndServe(addr, srv.routes())
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	web, _ := fs.Sub(webFiles, "web")
+	mux.Handle("/", http.FileServer(http.FS(web)))
+	mux.HandleFunc("/api/info", s.handleInfo)
+	mux.HandleFunc("/api/buckets", s.handleBuckets)
+	mux.HandleFunc("/api/pair", s.handlePair)
+	mux.HandleFunc("/api/search", s.handleSearch)
+	mux.HandleFunc("/api/export", s.handleExport)
+	return mux
+}
+
+// httpError is how errors come back from the API, as {"error": "..."}
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// requestPath reads the 'path' parameter, "" and "/" are the root
+func requestPath(r *http.Request) []string {
+	p := strings.TrimPrefix(r.URL.Query().Get("path"), "/")
+	if p == "" {
+		return nil
+	}
+	return splitPathArg(p)
+}
+
+/*
+authorize checks that a change can be made, the token comes in the
+Authorization header as "Bearer <token>" or in X-Boltbrowser-Token
+*/
+func (s *server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if s.readOnly {
+		httpError(w, http.StatusForbidden, errors.New("DB is in Read-Only Mode"))
+		return false
+	}
+	token := r.Header.Get("X-Boltbrowser-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		httpError(w, http.StatusUnauthorized, errors.New("a valid token is needed to make changes"))
+		return false
+	}
+	return true
+}
+
+// change runs one of the model's changes, one at a time
+func (s *server) change(w http.ResponseWriter, fn func() error) {
+	s.writeMu.Lock()
+	err := fn()
+	s.writeMu.Unlock()
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+// viewBucket runs 'fn' with the bucket at 'path', or says it's not there
+func viewBucket(w http.ResponseWriter, path []string, fn func(b *bbolt.Bucket) error) bool {
+	if err := model.New(db).View(path, fn); err != nil {
+		httpError(w, http.StatusNotFound, err)
+		return false
+	}
+	return true
+}
+
+func (s *server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"file":     currentFilename,
+		"readOnly": s.readOnly,
+	})
+}
+
+type servePair struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+}
+
+type serveBucket struct {
+	Path     []string    `json:"path"`
+	Buckets  []string    `json:"buckets"`
+	Pairs    []servePair `json:"pairs"`
+	Sequence uint64      `json:"sequence"`
+	// There are more pairs after the last one, ask again with after=<last key>
+	More bool `json:"more"`
+}
+
+func (s *server) handleBuckets(w http.ResponseWriter, r *http.Request) {
+	path := requestPath(r)
+	switch r.Method {
+	case http.MethodGet:
+		limit := maxServeListing
+		if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+		after := r.URL.Query().Get("after")
+		ret := serveBucket{Path: path, Buckets: []string{}, Pairs: []servePair{}}
+		ok := viewBucket(w, path, func(b *bbolt.Bucket) error {
+			ret.Sequence = b.Sequence()
+			c := b.Cursor()
+			k, v := c.First()
+			if after != "" {
+				if k, v = c.Seek([]byte(after)); k != nil && string(k) == after {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil; k, v = c.Next() {
+				if v == nil {
+					// Buckets are all listed on the first page
+					if after == "" {
+						ret.Buckets = append(ret.Buckets, string(k))
+					}
+					continue
+				}
+				if len(ret.Pairs) == limit {
+					ret.More = true
+					continue
+				}
+				ret.Pairs = append(ret.Pairs, servePair{string(k), len(v)})
+			}
+			return nil
+		})
+		if ok {
+			writeJSON(w, http.StatusOK, ret)
+		}
+	case http.MethodPost:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			httpError(w, http.StatusBadRequest, errors.New("expected a name"))
+			return
+		}
+		if !s.authorize(w, r) || !viewBucket(w, path, func(*bbolt.Bucket) error { return nil }) {
+			return
+		}
+		s.change(w, func() error { return insertBucket(path, name) })
+	case http.MethodDelete:
+		if len(path) == 0 {
+			httpError(w, http.StatusBadRequest, errors.New("the root can't be deleted"))
+			return
+		}
+		if !s.authorize(w, r) || !viewBucket(w, path, func(*bbolt.Bucket) error { return nil }) {
+			return
+		}
+		s.change(w, func() error { return deleteKey(path) })
+	default:
+		httpError(w, http.StatusMethodNotAllowed, errors.New("expected GET, POST or DELETE"))
+	}
+}
+
+func (s *server) handlePair(w http.ResponseWriter, r *http.Request) {
+	path, key := requestPath(r), r.URL.Query().Get("key")
+	if key == "" {
+		httpError(w, http.StatusBadRequest, errors.New("expected a key"))
+		return
+	}
+	// The bucket has to be there, for changes too, or the model falls back to the root
+	var val []byte
+	if !viewBucket(w, path, func(b *bbolt.Bucket) error {
+		if v := b.Get([]byte(key)); v != nil {
+			val = append([]byte{}, v...)
+		}
+		return nil
+	}) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if val == nil {
+			httpError(w, http.StatusNotFound, fmt.Errorf("no pair %s", key))
+			return
+		}
+		if r.URL.Query().Get("raw") == "true" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(val)
+			return
+		}
+		ret := map[string]string{"key": key}
+		// Values that aren't text come back as base64
+		if utf8.Valid(val) {
+			ret["value"] = string(val)
+		} else {
+			ret["base64"] = base64.StdEncoding.EncodeToString(val)
+		}
+		writeJSON(w, http.StatusOK, ret)
+	case http.MethodPut:
+		if len(path) == 0 {
+			httpError(w, http.StatusBadRequest, errors.New("pairs can't be in the root, only buckets"))
+			return
+		}
+		if !s.authorize(w, r) {
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxServePutSize))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.change(w, func() error { return insertPair(path, key, string(body)) })
+	case http.MethodDelete:
+		if !s.authorize(w, r) {
+			return
+		}
+		if val == nil {
+			httpError(w, http.StatusNotFound, fmt.Errorf("no pair %s", key))
+			return
+		}
+		s.change(w, func() error { return deleteKey(append(append([]string{}, path...), key)) })
+	default:
+		httpError(w, http.StatusMethodNotAllowed, errors.New("expected GET, PUT or DELETE"))
+	}
+}
+
+type serveSearchResult struct {
+	Path   []string `json:"path"`
+	Key    string   `json:"key"`
+	Bucket bool     `json:"bucket"`
+}
+
+// handleSearch looks through the bucket and everything under it, like the browser's filter
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		httpError(w, http.StatusBadRequest, errors.New("expected something to search for"))
+		return
+	}
+	values := r.URL.Query().Get("values") == "true"
+	results := []serveSearchResult{}
+	truncated := false
+	var search func(b *bbolt.Bucket, path []string)
+	search = func(b *bbolt.Bucket, path []string) {
+		b.ForEach(func(k, v []byte) error {
+			if len(results) == maxServeSearchResults {
+				truncated = true
+				return nil
+			}
+			if strings.Contains(string(k), q) || (values && v != nil && strings.Contains(string(v), q)) {
+				results = append(results, serveSearchResult{path, string(k), v == nil})
+			}
+			if v == nil {
+				search(b.Bucket(k), append(append([]string{}, path...), string(k)))
+			}
+			return nil
+		})
+	}
+	path := requestPath(r)
+	if viewBucket(w, path, func(b *bbolt.Bucket) error {
+		search(b, append([]string{}, path...))
+		return nil
+	}) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": results, "truncated": truncated})
+	}
+}
+
+// handleExport downloads a bucket as json (like :export json) or a pair's value
+func (s *server) handleExport(w http.ResponseWriter, r *http.Request) {
+	path, key := requestPath(r), r.URL.Query().Get("key")
+	var out []byte
+	name := "root"
+	if len(path) > 0 {
+		name = path[len(path)-1]
+	}
+	if !viewBucket(w, path, func(b *bbolt.Bucket) error {
+		if key == "" {
+			out = []byte(model.BucketJSON(b))
+			return nil
+		}
+		name = key
+		if v := b.Get([]byte(key)); v != nil {
+			out = append([]byte{}, v...)
+			return nil
+		}
+		return fmt.Errorf("no pair %s", key)
+	}) {
+		return
+	}
+	if key == "" {
+		w.Header().Set("Content-Type", "application/json")
+		name += ".json"
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Write(out)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// serveRequest makes a request to 'srv', with 'token' if it's set, and decodes json responses into 'out'
+func serveRequest(t *testing.T, srv *server, method, url, token, body string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	srv.routes().ServeHTTP(w, r)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v in %q", method, url, err, w.Body.String())
+		}
+	}
+	return w
+}
+
+func TestServeChanges(t *testing.T) {
+	useTestDB(t, openSQLTestDB(t))
+	srv := &server{token: "secret"}
+	for _, tc := range []struct {
+		method, url, token string
+		want               int
+	}{
+		{"PUT", "/api/pair?path=users&key=u5", "", http.StatusUnauthorized},
+		{"PUT", "/api/pair?path=users&key=u5", "wrong", http.StatusUnauthorized},
+		{"DELETE", "/api/pair?path=users&key=u1", "secre", http.StatusUnauthorized},
+		{"POST", "/api/buckets?path=users&name=new", "", http.StatusUnauthorized},
+		{"DELETE", "/api/buckets?path=users", "secretsecret", http.StatusUnauthorized},
+		{"PUT", "/api/pair?path=users&key=u5", "secret", http.StatusOK},
+		{"PUT", "/api/pair?path=users&key=u1", "secret", http.StatusOK},
+		{"PUT", "/api/pair?path=nope&key=u1", "secret", http.StatusNotFound},
+		{"PUT", "/api/pair?key=k", "secret", http.StatusBadRequest},
+		{"DELETE", "/api/pair?path=users&key=u2", "secret", http.StatusOK},
+		{"DELETE", "/api/pair?path=users&key=u2", "secret", http.StatusNotFound},
+		{"POST", "/api/buckets?path=users/orders&name=new", "secret", http.StatusOK},
+		{"DELETE", "/api/buckets?path=users/orders/new", "secret", http.StatusOK},
+		{"POST", "/api/buckets?name=archive", "secret", http.StatusOK},
+		{"DELETE", "/api/buckets", "secret", http.StatusBadRequest},
+	} {
+		w := serveRequest(t, srv, tc.method, tc.url, tc.token, "changed", nil)
+		if w.Code != tc.want {
+			t.Errorf("%s %s with %q: got %d, expected %d", tc.method, tc.url, tc.token, w.Code, tc.want)
+		}
+	}
+	got := dumpTestDB(t, db)
+	want := "archive/\nusers/\nusers/orders/\n" +
+		"users/orders/o1 = {\"total\":3}\nusers/orders/o2 = {\"total\":12.5}\nusers/orders/o3 = {\"total\":7}\n" +
+		"users/u1 = changed\nusers/u3 = {\"name\":\"carol\",\"age\":35,\"status\":\"active\"}\n" +
+		"users/u4 = not json\nusers/u5 = changed"
+	if got != want {
+		t.Errorf("the DB is:\n%s\nexpected:\n%s", got, want)
+	}
+}
+
+func TestServeReadOnly(t *testing.T) {
+	useTestDB(t, openSQLTestDB(t))
+	before := dumpTestDB(t, db)
+	srv := &server{token: "secret", readOnly: true}
+	for _, req := range []string{
+		"PUT /api/pair?path=users&key=u1",
+		"DELETE /api/pair?path=users&key=u1",
+		"POST /api/buckets?path=users&name=new",
+		"DELETE /api/buckets?path=users",
+	} {
+		method, url, _ := strings.Cut(req, " ")
+		if w := serveRequest(t, srv, method, url, "secret", "changed", nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d, expected %d", req, w.Code, http.StatusForbidden)
+		}
+	}
+	if after := dumpTestDB(t, db); after != before {
+		t.Errorf("the DB was changed:\n%s", after)
+	}
+	// Reads don't need the token
+	var pair map[string]string
+	serveRequest(t, srv, "GET", "/api/pair?path=users&key=u4", "", "", &pair)
+	if pair["value"] != "not json" {
+		t.Errorf("got %q", pair)
+	}
+}
+
+func TestServeListing(t *testing.T) {
+	useTestDB(t, openSQLTestDB(t))
+	srv := &server{}
+	var keys []string
+	after := ""
+	for page := 0; ; page++ {
+		var ret serveBucket
+		serveRequest(t, srv, "GET", "/api/buckets?path=users&limit=3&after="+after, "", "", &ret)
+		if page == 0 && strings.Join(ret.Buckets, ",") != "orders" {
+			t.Errorf("the first page has buckets %q", ret.Buckets)
+		} else if page > 0 && len(ret.Buckets) > 0 {
+			t.Errorf("page %d has buckets %q", page, ret.Buckets)
+		}
+		for _, p := range ret.Pairs {
+			keys = append(keys, p.Key)
+		}
+		if !ret.More {
+			break
+		}
+		after = ret.Pairs[len(ret.Pairs)-1].Key
+	}
+	if strings.Join(keys, ",") != "u1,u2,u3,u4" {
+		t.Errorf("paged through %q", keys)
+	}
+	if w := serveRequest(t, srv, "GET", "/api/buckets?path=nope", "", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("a bucket that isn't there: got %d", w.Code)
+	}
+}
+
+func TestServeSearch(t *testing.T) {
+	bdb := openSQLTestDB(t)
+	useTestDB(t, bdb)
+	srv := &server{}
+	var ret struct {
+		Results   []serveSearchResult `json:"results"`
+		Truncated bool                `json:"truncated"`
+	}
+	serveRequest(t, srv, "GET", "/api/search?q=o", "", "", &ret)
+	if len(ret.Results) != 4 || ret.Truncated {
+		t.Errorf("got %v", ret)
+	}
+
+	err := bdb.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("many"))
+		for i := 0; err == nil && i <= maxServeSearchResults; i++ {
+			err = b.Put([]byte(fmt.Sprintf("match%04d", i)), []byte("v"))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveRequest(t, srv, "GET", "/api/search?path=many&q=match", "", "", &ret)
+	if len(ret.Results) != maxServeSearchResults || !ret.Truncated {
+		t.Errorf("got %d results, truncated is %v", len(ret.Results), ret.Truncated)
+	}
+}
+
+func TestServeExport(t *testing.T) {
+	useTestDB(t, openSQLTestDB(t))
+	srv := &server{}
+
+	w := serveRequest(t, srv, "GET", "/api/export", "", "", nil)
+	var root map[string]map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &root); err != nil {
+		t.Fatalf("%v in %q", err, w.Body.String())
+	}
+	if _, ok := root["users"]["orders"]; !ok || !strings.Contains(w.Header().Get("Content-Disposition"), `"root.json"`) {
+		t.Errorf("root export is %q, %q", w.Body.String(), w.Header().Get("Content-Disposition"))
+	}
+
+	w = serveRequest(t, srv, "GET", "/api/export?path=users&key=u4", "", "", nil)
+	if w.Body.String() != "not json" || !strings.Contains(w.Header().Get("Content-Disposition"), `"u4"`) {
+		t.Errorf("pair export is %q, %q", w.Body.String(), w.Header().Get("Content-Disposition"))
+	}
+	if w = serveRequest(t, srv, "GET", "/api/export?path=users&key=nope", "", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("a pair that isn't there: got %d", w.Code)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// How many times we try to get a clean copy of a live file before giving up
+const snapshotAttempts = 5
+
+// How long we wait between copy attempts, giving the writer a chance to finish
+const snapshotRetryDelay = 200 * time.Millisecond
+
+/*
+snapshotDatabase copies the bolt file 'fn' to a temp file without taking
+bolt's file lock, so it works even when another process holds the write lock.
+The copy is opened read-only and checked with tx.Check. If the copy was torn
+by a write happening while we were reading it, we throw it away and try again.
+It returns the filename of the verified copy, the caller is responsible for
+removing it.
+*/
+func snapshotDatabase(fn string) (string, error) {
+	var lastErr error
+	for i := 0; i < snapshotAttempts; i++ {
+		if i > 0 {
+			time.Sleep(snapshotRetryDelay)
+		}
+		snapFile, err := copyToTempFile(fn)
+		if err != nil {
+			return "", err
+		}
+		if lastErr = checkDatabaseFile(snapFile); lastErr == nil {
+			return snapFile, nil
+		}
+		os.Remove(snapFile)
+	}
+	return "", fmt.Errorf("couldn't get a consistent snapshot of %s after %d attempts: %s", fn, snapshotAttempts, lastErr)
+}
+
+func copyToTempFile(fn string) (string, error) {
+	src, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp("", "boltbrowser-"+filepath.Base(fn)+"-*.snapshot")
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// checkDatabaseFile opens 'fn' read-only and runs bolt's consistency check on it
+func checkDatabaseFile(fn string) error {
+	snapDB, err := bbolt.Open(fn, 0600, &bbolt.Options{Timeout: AppArgs.DBOpenTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer snapDB.Close()
+	return snapDB.View(func(tx *bbolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("consistency check failed: %w", errors.Join(errs...))
+		}
+		return nil
+	})
+}
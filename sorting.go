@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/br0xen/boltbrowser/model"
+)
+
+/*
+sortMode is a way of ordering the items in a bucket,
+they're picked per bucket with ':sort name' or cycled through with 'o'
+*/
+type sortMode struct {
+	name        string
+	description string
+	// The decoder the bucket has to have for this to work, if any
+	needsDecoder string
+	// Works out anything 'less' needs that's slow to get, once per item
+	prepare func(item *sortItem)
+	less    func(a, b *sortItem) bool
+}
+
+/*
+sortItem is a bucket or pair being sorted, along with
+whatever its sort mode worked out about it
+*/
+type sortItem struct {
+	name string
+	// The pair's value, or nil for a bucket
+	value []byte
+	// How many buckets and pairs are in a bucket
+	count int
+
+	num     uint64
+	hasNum  bool
+	time    time.Time
+	hasTime bool
+}
+
+// size is what the 'size' sort mode compares
+func (item *sortItem) size() int {
+	if item.value == nil {
+		return item.count
+	}
+	return len(item.value)
+}
+
+// Buckets that haven't had a sort mode set are in bolt's own (byte) order
+const defaultSortMode = "byte"
+
+var bucketSortModes = []sortMode{
+	{"byte", "bolt's own order", "", nil, func(a, b *sortItem) bool {
+		return a.name < b.name
+	}},
+	{"numeric", "numbers in keys by their value", "", nil, func(a, b *sortItem) bool {
+		return naturalLess(a.name, b.name)
+	}},
+	{"int", "keys decoded as big endian integers", "", func(item *sortItem) {
+		item.num, item.hasNum = keyInt(item.name)
+	}, func(a, b *sortItem) bool {
+		if a.hasNum != b.hasNum {
+			// Keys that aren't integers go last
+			return a.hasNum
+		}
+		if a.hasNum && a.num != b.num {
+			return a.num < b.num
+		}
+		return a.name < b.name
+	}},
+	{"size", "biggest values (or buckets) first", "", nil, func(a, b *sortItem) bool {
+		if a.size() != b.size() {
+			return a.size() > b.size()
+		}
+		return a.name < b.name
+	}},
+	{"modified", "newest first, by the timestamp decoder", "timestamp", func(item *sortItem) {
+		if item.value != nil {
+			t, err := model.DecodeTimestamp(item.value)
+			item.time, item.hasTime = t, err == nil
+		}
+	}, func(a, b *sortItem) bool {
+		if a.hasTime != b.hasTime {
+			return a.hasTime
+		}
+		if a.hasTime && !a.time.Equal(b.time) {
+			return a.time.After(b.time)
+		}
+		return a.name < b.name
+	}},
+}
+
+// findSortMode looks up a sort mode by name
+func findSortMode(name string) (*sortMode, bool) {
+	for i := range bucketSortModes {
+		if bucketSortModes[i].name == name {
+			return &bucketSortModes[i], true
+		}
+	}
+	return nil, false
+}
+
+// sortModeNames lists the names of all of the sort modes, for completion and errors
+func sortModeNames() []string {
+	var ret []string
+	for _, m := range bucketSortModes {
+		ret = append(ret, m.name)
+	}
+	return ret
+}
+
+/*
+naturalLess compares strings so that runs of digits are compared by their
+value, "item2" comes before "item10"
+*/
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+/*
+keyInt reads a key as a 1, 2, 4 or 8 byte unsigned big endian number, what
+bolt's NextSequence keys usually are. Numbers written out as text are left
+to the numeric mode, "10" is two bytes like any other.
+*/
+func keyInt(k string) (uint64, bool) {
+	switch len(k) {
+	case 1:
+		return uint64(k[0]), true
+	case 2:
+		return uint64(binary.BigEndian.Uint16([]byte(k))), true
+	case 4:
+		return uint64(binary.BigEndian.Uint32([]byte(k))), true
+	case 8:
+		return binary.BigEndian.Uint64([]byte(k)), true
+	}
+	return 0, false
+}
+
+// setSortMode sets how the bucket at 'path' is sorted and re-sorts it
+func (screen *BrowserScreen) setSortMode(path []string, name string) error {
+	mode, ok := findSortMode(name)
+	if !ok {
+		return fmt.Errorf("unknown sort mode %q, try one of: %s", name, strings.Join(sortModeNames(), ", "))
+	}
+	if mode.needsDecoder != "" && screen.decoderFor(path) != mode.needsDecoder {
+		return fmt.Errorf("sorting by %s needs ':set decoder=%s' on this bucket", name, mode.needsDecoder)
+	}
+	if screen.sortModes == nil {
+		screen.sortModes = make(map[string]string)
+	}
+	if name == defaultSortMode {
+		delete(screen.sortModes, pathKey(path))
+	} else {
+		screen.sortModes[pathKey(path)] = name
+	}
+	screen.applySortModes()
+	return nil
+}
+
+// cycleSortMode moves the bucket at 'path' on to the next sort mode it can use
+func (screen *BrowserScreen) cycleSortMode(path []string) string {
+	current := screen.sortModeFor(path)
+	idx := 0
+	for i := range bucketSortModes {
+		if bucketSortModes[i].name == current {
+			idx = i
+		}
+	}
+	for i := 1; i <= len(bucketSortModes); i++ {
+		next := bucketSortModes[(idx+i)%len(bucketSortModes)].name
+		if screen.setSortMode(path, next) == nil {
+			return next
+		}
+	}
+	return current
+}
+
+// sortModeFor is the sort mode set for the bucket at 'path'
+func (screen *BrowserScreen) sortModeFor(path []string) string {
+	if name, ok := screen.sortModes[pathKey(path)]; ok {
+		return name
+	}
+	return defaultSortMode
+}
+
+/*
+applySortModes puts the buckets and pairs in memory into the order that's
+been picked for them. Everything that walks the tree (bucketToLines,
+buildVisiblePathSlice and the cursor movement built on it) goes through
+the same slices, so what's drawn and how the cursor moves always agree.
+*/
+func (screen *BrowserScreen) applySortModes() {
+	if screen.db == nil {
+		return
+	}
+	screen.sortBuckets(nil, screen.db.buckets)
+	for i := range screen.db.buckets {
+		screen.sortBucket(&screen.db.buckets[i])
+	}
+}
+
+func (screen *BrowserScreen) sortBucket(b *BoltBucket) {
+	path := b.GetPath()
+	screen.sortBuckets(path, b.buckets)
+	mode := screen.sortModeAt(path)
+	items := make([]sortItem, len(b.pairs))
+	for i := range b.pairs {
+		items[i] = sortItem{name: b.pairs[i].key, value: []byte(b.pairs[i].val)}
+		if mode.prepare != nil {
+			mode.prepare(&items[i])
+		}
+	}
+	sort.Stable(&sortedSlice{items, mode.less, func(i, j int) {
+		b.pairs[i], b.pairs[j] = b.pairs[j], b.pairs[i]
+	}})
+	for i := range b.buckets {
+		screen.sortBucket(&b.buckets[i])
+	}
+}
+
+// sortBuckets sorts the buckets in the bucket at 'path'
+func (screen *BrowserScreen) sortBuckets(path []string, buckets []BoltBucket) {
+	mode := screen.sortModeAt(path)
+	items := make([]sortItem, len(buckets))
+	for i := range buckets {
+		items[i] = sortItem{name: buckets[i].name, count: len(buckets[i].buckets) + len(buckets[i].pairs)}
+		if mode.prepare != nil {
+			mode.prepare(&items[i])
+		}
+	}
+	sort.Stable(&sortedSlice{items, mode.less, func(i, j int) {
+		buckets[i], buckets[j] = buckets[j], buckets[i]
+	}})
+}
+
+// sortModeAt gets the sort mode for 'path', falling back to the default if it can't be used
+func (screen *BrowserScreen) sortModeAt(path []string) *sortMode {
+	mode, ok := findSortMode(screen.sortModeFor(path))
+	if !ok || (mode.needsDecoder != "" && screen.decoderFor(path) != mode.needsDecoder) {
+		mode, _ = findSortMode(defaultSortMode)
+	}
+	return mode
+}
+
+/*
+sortedSlice sorts the prepared sortItems and keeps the
+slice they came from (the buckets or pairs) in step with them
+*/
+type sortedSlice struct {
+	items []sortItem
+	less  func(a, b *sortItem) bool
+	swap  func(i, j int)
+}
+
+func (s *sortedSlice) Len() int           { return len(s.items) }
+func (s *sortedSlice) Less(i, j int) bool { return s.less(&s.items[i], &s.items[j]) }
+func (s *sortedSlice) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.swap(i, j)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/br0xen/boltbrowser/model"
+	"go.etcd.io/bbolt"
+)
+
+// The pairs the sort modes are tested on, by a name for each
+var sortTestPairs = []struct {
+	name, key, value string
+}{
+	{"a", "\x00\x02", "2024-01-05T00:00:00Z"},
+	{"b", "9", "2024-01-02T00:00:00.5Z"},
+	{"c", "\xff", "2024-01-04T00:00:00.123Z"},
+	{"d", "\x00\x00\x00\x00\x00\x00\x01\x00", "2024-01-01T00:00:00.1Z"},
+	{"e", "10", "not a time"},
+	{"f", "\xff\xff\xff\xff\xff\xff\xff\xff", "2024-01-03T00:00:00.12345Z"},
+	{"g", "item10", "x"},
+	{"h", "item2", "xy"},
+}
+
+// sortTestName is the name of the pair with 'key'
+func sortTestName(key string) string {
+	for _, p := range sortTestPairs {
+		if p.key == key || model.Stringify([]byte(p.key)) == key {
+			return p.name
+		}
+	}
+	return "?" + key
+}
+
+func TestSortModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode, want string
+	}{
+		{"byte", "daebghcf"},
+		{"numeric", "dabehgcf"},
+		// The biggest uint64 is last, text numbers are bytes like anything else
+		{"int", "abcdefgh"},
+		{"size", "fcdbaehg"},
+		{"modified", "acfbdegh"},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			h := newHarness(t, func(tx *bbolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("items"))
+				for _, p := range sortTestPairs {
+					if err == nil {
+						err = b.Put([]byte(p.key), []byte(p.value))
+					}
+				}
+				return err
+			})
+			h.press("l : set space decoder=timestamp enter : sort space " + tc.mode + " enter")
+			if got := h.browser().sortModeFor([]string{"items"}); got != tc.mode {
+				t.Fatalf("sort mode is %s: %s", got, h.browser().message)
+			}
+
+			// The order the pairs are drawn in
+			var drawn string
+			for _, line := range h.browser().leftPaneBuffer[1:] {
+				key, _, _ := strings.Cut(strings.TrimLeft(line.Text, " "), ": ")
+				drawn += sortTestName(key)
+			}
+			if drawn != tc.want {
+				t.Errorf("drawn as %s, expected %s", drawn, tc.want)
+			}
+
+			// The order the cursor goes through them
+			paths, err := h.browser().db.buildVisiblePathSlice("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var visible, moved string
+			for _, p := range paths[1:] {
+				visible += sortTestName(p[1])
+			}
+			for range sortTestPairs {
+				h.press("j")
+				moved += sortTestName(h.browser().currentPath[1])
+			}
+			if visible != drawn || moved != drawn {
+				t.Errorf("drawn as %s, the visible paths are %s and the cursor went %s", drawn, visible, moved)
+			}
+		})
+	}
+}
@@ -0,0 +1,1250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/br0xen/boltbrowser/model"
+	"go.etcd.io/bbolt"
+)
+
+/*
+A small SQL engine, where every bucket is a table of its pairs. Each row
+has the columns key and value, and the top level fields of the value when
+it's a json object. It's only SELECT:
+
+	SELECT key, json_extract(value, '$.user.email') AS email
+	FROM "users/42/orders" WHERE status = 'failed' AND total > 10
+	ORDER BY total DESC LIMIT 20
+
+Nested buckets are written as a path, "a/b/c" or "a"."b"."c". GROUP BY and
+HAVING work with count, sum, avg, min and max.
+*/
+type sqlQuery struct {
+	columns []sqlColumn
+	from    []string
+	where   sqlExpr
+	groupBy []sqlExpr
+	having  sqlExpr
+	orderBy []sqlOrder
+	// -1 when there's no LIMIT
+	limit     int
+	offset    int
+	aggregate bool
+}
+
+type sqlColumn struct {
+	expr sqlExpr
+	name string
+}
+
+type sqlOrder struct {
+	expr sqlExpr
+	// When it's the name of one of the columns, the index of that column
+	column int
+	desc   bool
+}
+
+// sqlRow is a pair being looked at by a query
+type sqlRow struct {
+	key    []byte
+	value  []byte
+	parsed bool
+	fields map[string]interface{}
+}
+
+func (r *sqlRow) field(name string) interface{} {
+	if !r.parsed {
+		r.parsed = true
+		json.Unmarshal(r.value, &r.fields)
+	}
+	return r.fields[name]
+}
+
+// sqlContext is what an expression is evaluated against, a row and the group it's in
+type sqlContext struct {
+	row   *sqlRow
+	group []*sqlRow
+}
+
+type sqlExpr interface {
+	eval(ctx *sqlContext) (interface{}, error)
+}
+
+type sqlLiteral struct{ v interface{} }
+type sqlColumnRef struct{ name string }
+type sqlUnary struct {
+	op string
+	x  sqlExpr
+}
+type sqlBinary struct {
+	op   string
+	l, r sqlExpr
+}
+type sqlIsNull struct {
+	x   sqlExpr
+	not bool
+}
+type sqlIn struct {
+	x    sqlExpr
+	list []sqlExpr
+	not  bool
+}
+type sqlCall struct {
+	name string
+	args []sqlExpr
+	// count(*)
+	star bool
+}
+
+var sqlAggregates = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+func (e *sqlLiteral) eval(ctx *sqlContext) (interface{}, error) { return e.v, nil }
+
+func (e *sqlColumnRef) eval(ctx *sqlContext) (interface{}, error) {
+	if ctx.row == nil {
+		return nil, nil
+	}
+	switch strings.ToLower(e.name) {
+	case "key":
+		return model.Stringify(ctx.row.key), nil
+	case "value":
+		return model.Stringify(ctx.row.value), nil
+	}
+	return ctx.row.field(e.name), nil
+}
+
+func (e *sqlUnary) eval(ctx *sqlContext) (interface{}, error) {
+	v, err := e.x.eval(ctx)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	if e.op == "NOT" {
+		return !sqlTruthy(v), nil
+	}
+	n, ok := sqlNumber(v)
+	if !ok {
+		return nil, nil
+	}
+	return -n, nil
+}
+
+func (e *sqlBinary) eval(ctx *sqlContext) (interface{}, error) {
+	l, err := e.l.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// AND and OR don't look any further than they need to
+	switch e.op {
+	case "AND":
+		if l != nil && !sqlTruthy(l) {
+			return false, nil
+		}
+	case "OR":
+		if l != nil && sqlTruthy(l) {
+			return true, nil
+		}
+	}
+	r, err := e.r.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "AND", "OR":
+		// NULL AND false is false, NULL OR true is true
+		if r != nil && sqlTruthy(r) == (e.op == "OR") {
+			return sqlTruthy(r), nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return sqlTruthy(r), nil
+	case "||":
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return sqlText(l) + sqlText(r), nil
+	case "LIKE":
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return sqlLike(sqlText(l), sqlText(r)), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		c, ok := sqlCompare(l, r)
+		if !ok {
+			return nil, nil
+		}
+		switch e.op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+	a, aok := sqlNumber(l)
+	b, bok := sqlNumber(r)
+	if !aok || !bok {
+		return nil, nil
+	}
+	switch e.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, nil
+		}
+		return a / b, nil
+	}
+	if b == 0 {
+		return nil, nil
+	}
+	return math.Mod(a, b), nil
+}
+
+func (e *sqlIsNull) eval(ctx *sqlContext) (interface{}, error) {
+	v, err := e.x.eval(ctx)
+	return (v == nil) != e.not, err
+}
+
+func (e *sqlIn) eval(ctx *sqlContext) (interface{}, error) {
+	v, err := e.x.eval(ctx)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	for _, item := range e.list {
+		iv, err := item.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := sqlCompare(v, iv); ok && c == 0 {
+			return !e.not, nil
+		}
+	}
+	return e.not, nil
+}
+
+func (e *sqlCall) eval(ctx *sqlContext) (interface{}, error) {
+	if sqlAggregates[e.name] {
+		return e.evalAggregate(ctx)
+	}
+	var args []interface{}
+	for _, a := range e.args {
+		v, err := a.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	switch e.name {
+	case "json_extract":
+		if args[0] == nil {
+			return nil, nil
+		}
+		return jsonExtract(args[0], sqlText(args[1]))
+	case "lower":
+		if args[0] == nil {
+			return nil, nil
+		}
+		return strings.ToLower(sqlText(args[0])), nil
+	case "upper":
+		if args[0] == nil {
+			return nil, nil
+		}
+		return strings.ToUpper(sqlText(args[0])), nil
+	case "length":
+		if args[0] == nil {
+			return nil, nil
+		}
+		return float64(len([]rune(sqlText(args[0])))), nil
+	case "coalesce":
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("no such function: %s", e.name)
+}
+
+// evalAggregate works out an aggregate over the rows in the context's group
+func (e *sqlCall) evalAggregate(ctx *sqlContext) (interface{}, error) {
+	count, sum := 0, 0.0
+	var best interface{}
+	for _, row := range ctx.group {
+		if e.star {
+			count++
+			continue
+		}
+		v, err := e.args[0].eval(&sqlContext{row: row})
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		count++
+		if n, ok := sqlNumber(v); ok {
+			sum += n
+		}
+		if best == nil {
+			best = v
+		} else if c, ok := sqlCompare(v, best); ok && ((e.name == "min" && c < 0) || (e.name == "max" && c > 0)) {
+			best = v
+		}
+	}
+	switch e.name {
+	case "count":
+		return float64(count), nil
+	case "sum":
+		if count == 0 {
+			return nil, nil
+		}
+		return sum, nil
+	case "avg":
+		if count == 0 {
+			return nil, nil
+		}
+		return sum / float64(count), nil
+	}
+	return best, nil
+}
+
+// sqlTruthy is whether a value counts as true in WHERE and HAVING
+func sqlTruthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	}
+	return true
+}
+
+func sqlNumber(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case bool:
+		if t {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		// Only decimals, ParseFloat would also take hex, "nan" and "inf"
+		t = strings.TrimSpace(t)
+		if strings.IndexFunc(t, notDecimal) >= 0 {
+			return 0, false
+		}
+		n, err := strconv.ParseFloat(t, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func notDecimal(r rune) bool {
+	return (r < '0' || r > '9') && !strings.ContainsRune("+-.eE", r)
+}
+
+// sqlText is how a value is shown, and what's compared when it isn't a number
+func sqlText(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return compactJSON(v)
+}
+
+/*
+sqlCompare compares two values, as numbers when they both are (or both look
+like one) and as text otherwise. It's not ok when either of them is NULL.
+*/
+func sqlCompare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if an, ok := sqlNumber(a); ok {
+		if bn, ok := sqlNumber(b); ok {
+			switch {
+			case an < bn:
+				return -1, true
+			case an > bn:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return strings.Compare(sqlText(a), sqlText(b)), true
+}
+
+// sqlLike matches 'pattern' (with % and _) against 'text', ignoring case like sqlite does
+func sqlLike(text, pattern string) bool {
+	var re strings.Builder
+	re.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	ok, _ := regexp.MatchString(re.String(), text)
+	return ok
+}
+
+/*
+jsonExtract gets what 'path' (like $.user.emails[0]) points to in 'v',
+which is either json text or something that's already been decoded
+*/
+func jsonExtract(v interface{}, path string) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, nil
+		}
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("bad json path %q, it should start with $", path)
+	}
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, nil
+			}
+			v, rest = obj[rest[1:end+1]], rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad json path %q", path)
+			}
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("bad json path %q", path)
+			}
+			arr, ok := v.([]interface{})
+			if !ok || idx < 0 || idx >= len(arr) {
+				return nil, nil
+			}
+			v, rest = arr[idx], rest[end+1:]
+		default:
+			return nil, fmt.Errorf("bad json path %q", path)
+		}
+	}
+	return v, nil
+}
+
+/*
+run runs the query in 'tx', passing each row of the result to 'emit' along
+with the key of the pair it came from (nil for rows made from a group).
+Queries without ORDER BY or aggregates stream the rows straight from a
+cursor, so they can stop at the LIMIT without reading the whole bucket.
+*/
+func (q *sqlQuery) run(tx *bbolt.Tx, emit func(out []interface{}, key []byte) error) error {
+	b, err := q.bucket(tx)
+	if err != nil {
+		return err
+	}
+	type sortedRow struct {
+		out   []interface{}
+		order []interface{}
+		key   []byte
+	}
+	var sorted []sortedRow
+	var groups [][]*sqlRow
+	groupIdx := make(map[string]int)
+	skipped, emitted := 0, 0
+	// output hands a row on, after OFFSET and until LIMIT
+	output := func(out []interface{}, key []byte) (bool, error) {
+		if skipped < q.offset {
+			skipped++
+			return true, nil
+		}
+		if q.limit >= 0 && emitted >= q.limit {
+			return false, nil
+		}
+		emitted++
+		return true, emit(out, key)
+	}
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			// Nested buckets aren't rows
+			continue
+		}
+		row := &sqlRow{key: k, value: v}
+		ctx := &sqlContext{row: row, group: []*sqlRow{row}}
+		if q.where != nil {
+			ok, err := q.where.eval(ctx)
+			if err != nil {
+				return err
+			}
+			if !sqlTruthy(ok) {
+				continue
+			}
+		}
+		if q.aggregate {
+			gk, err := q.groupKey(ctx)
+			if err != nil {
+				return err
+			}
+			// The row has to outlive the cursor
+			row.key, row.value = append([]byte{}, k...), append([]byte{}, v...)
+			if idx, ok := groupIdx[gk]; ok {
+				groups[idx] = append(groups[idx], row)
+			} else {
+				groupIdx[gk] = len(groups)
+				groups = append(groups, []*sqlRow{row})
+			}
+			continue
+		}
+		out, order, err := q.evalRow(ctx)
+		if err != nil {
+			return err
+		}
+		if len(q.orderBy) > 0 {
+			sorted = append(sorted, sortedRow{out, order, append([]byte{}, k...)})
+			continue
+		}
+		if more, err := output(out, k); err != nil || !more {
+			return err
+		}
+	}
+
+	if q.aggregate {
+		if len(groups) == 0 && len(q.groupBy) == 0 {
+			// An aggregate over nothing is still one row, count(*) is 0
+			groups = append(groups, nil)
+		}
+		for _, g := range groups {
+			ctx := &sqlContext{group: g}
+			if len(g) > 0 {
+				ctx.row = g[0]
+			}
+			if q.having != nil {
+				ok, err := q.having.eval(ctx)
+				if err != nil {
+					return err
+				}
+				if !sqlTruthy(ok) {
+					continue
+				}
+			}
+			out, order, err := q.evalRow(ctx)
+			if err != nil {
+				return err
+			}
+			sorted = append(sorted, sortedRow{out, order, nil})
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		for n, o := range q.orderBy {
+			a, b := sorted[i].order[n], sorted[j].order[n]
+			if a == nil || b == nil {
+				if (a == nil) == (b == nil) {
+					continue
+				}
+				// NULLs come first, like in sqlite
+				return (a == nil) != o.desc
+			}
+			if c, _ := sqlCompare(a, b); c != 0 {
+				return (c < 0) != o.desc
+			}
+		}
+		return false
+	})
+	for _, s := range sorted {
+		if more, err := output(s.out, s.key); err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucket is the bucket the query is FROM
+func (q *sqlQuery) bucket(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	b, err := model.TxBucket(tx, q.from)
+	if err != nil {
+		return nil, fmt.Errorf("no bucket %s", displayPath(q.from))
+	}
+	return b, nil
+}
+
+// evalRow works out the columns of a row, and what it's ordered by
+func (q *sqlQuery) evalRow(ctx *sqlContext) ([]interface{}, []interface{}, error) {
+	out := make([]interface{}, len(q.columns))
+	for i, col := range q.columns {
+		v, err := col.expr.eval(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		out[i] = v
+	}
+	var order []interface{}
+	for _, o := range q.orderBy {
+		if o.column >= 0 {
+			order = append(order, out[o.column])
+			continue
+		}
+		v, err := o.expr.eval(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		order = append(order, v)
+	}
+	return out, order, nil
+}
+
+// groupKey is the GROUP BY values of a row, as json so any text can be in them
+func (q *sqlQuery) groupKey(ctx *sqlContext) (string, error) {
+	var parts []interface{}
+	for _, g := range q.groupBy {
+		v, err := g.eval(ctx)
+		if err != nil {
+			return "", err
+		}
+		if v != nil {
+			// NULL stays null, so it isn't in the same group as the text 'NULL'
+			v = sqlText(v)
+		}
+		parts = append(parts, v)
+	}
+	key, err := json.Marshal(parts)
+	return string(key), err
+}
+
+// columnNames are the headings for the query's columns
+func (q *sqlQuery) columnNames() []string {
+	var ret []string
+	for _, c := range q.columns {
+		ret = append(ret, c.name)
+	}
+	return ret
+}
+
+// The kinds of sqlToken
+const (
+	sqlEOF = iota
+	sqlIdent
+	// A "quoted" identifier
+	sqlQuoted
+	sqlString
+	sqlNum
+	sqlOp
+)
+
+type sqlToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+func sqlTokenize(src string) ([]sqlToken, error) {
+	var ret []sqlToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			// A comment to the end of the line
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			continue
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '$') {
+				i++
+			}
+			ret = append(ret, sqlToken{sqlIdent, string(runes[start:i]), start})
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '-' || runes[i] == '+') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			ret = append(ret, sqlToken{sqlNum, string(runes[start:i]), start})
+		case r == '\'' || r == '"' || r == '`':
+			// Quotes inside are doubled, like 'it''s'
+			var text strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("missing closing %c for the one at %d", r, start+1)
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						text.WriteRune(r)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			kind := sqlQuoted
+			if r == '\'' {
+				kind = sqlString
+			}
+			ret = append(ret, sqlToken{kind, text.String(), start})
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "<=", ">=", "!=", "<>", "==", "||":
+					op = two
+				}
+			}
+			if !strings.Contains("(),.*+-/%=<>!|;", op[:1]) {
+				return nil, fmt.Errorf("unexpected %q at %d", op, start+1)
+			}
+			i += len([]rune(op))
+			ret = append(ret, sqlToken{sqlOp, op, start})
+		}
+	}
+	return append(ret, sqlToken{sqlEOF, "", len(runes)}), nil
+}
+
+// sqlParser is a recursive descent parser for the SELECTs that sqlQuery can run
+type sqlParser struct {
+	tokens []sqlToken
+	pos    int
+	src    string
+}
+
+// parseSQL parses a SELECT statement
+func parseSQL(src string) (*sqlQuery, error) {
+	tokens, err := sqlTokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &sqlParser{tokens: tokens, src: src}
+	q, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptOp(";")
+	if t := p.peek(); t.kind != sqlEOF {
+		return nil, p.errorf("unexpected %q", t.text)
+	}
+	return q, nil
+}
+
+func (p *sqlParser) peek() sqlToken { return p.tokens[p.pos] }
+
+func (p *sqlParser) next() sqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != sqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *sqlParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s at %d", fmt.Sprintf(format, a...), p.peek().pos+1)
+}
+
+// isKeyword is whether the next token is the keyword 'kw'
+func (p *sqlParser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == sqlIdent && strings.EqualFold(t.text, kw)
+}
+
+func (p *sqlParser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf("expected %s", kw)
+	}
+	return nil
+}
+
+func (p *sqlParser) acceptOp(op string) bool {
+	if t := p.peek(); t.kind == sqlOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.errorf("expected %q", op)
+	}
+	return nil
+}
+
+// Keywords that can't be a column name without quoting it
+var sqlReserved = map[string]bool{"select": true, "from": true, "where": true, "group": true, "having": true, "order": true,
+	"by": true, "limit": true, "offset": true, "and": true, "or": true, "not": true, "as": true, "like": true, "is": true,
+	"in": true, "null": true, "asc": true, "desc": true}
+
+func (p *sqlParser) parseSelect() (*sqlQuery, error) {
+	q := &sqlQuery{limit: -1}
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	for {
+		if p.acceptOp("*") {
+			q.columns = append(q.columns, sqlColumn{&sqlColumnRef{"key"}, "key"}, sqlColumn{&sqlColumnRef{"value"}, "value"})
+		} else {
+			start := p.peek().pos
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			name := strings.TrimSpace(string([]rune(p.src)[start:p.peek().pos]))
+			if ref, ok := e.(*sqlColumnRef); ok {
+				name = ref.name
+			}
+			if p.acceptKeyword("AS") || (p.peek().kind == sqlIdent && !sqlReserved[strings.ToLower(p.peek().text)]) || p.peek().kind == sqlQuoted {
+				t := p.next()
+				if t.kind != sqlIdent && t.kind != sqlQuoted {
+					return nil, p.errorf("expected a column name after AS")
+				}
+				name = t.text
+			}
+			q.columns = append(q.columns, sqlColumn{e, name})
+		}
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	from, err := p.parseBucketPath()
+	if err != nil {
+		return nil, err
+	}
+	q.from = from
+	if p.acceptKeyword("WHERE") {
+		if q.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		// WHERE can use the names given with AS, fields can't be
+		// told apart from missing ones so the names win
+		q.where = replaceAliases(q.where, q.columns)
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			q.groupBy = append(q.groupBy, replaceAliases(e, q.columns))
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("HAVING") {
+		if q.having, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		// HAVING can use the names given with AS too
+		q.having = replaceAliases(q.having, q.columns)
+	}
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			o := sqlOrder{expr: e, column: -1}
+			if ref, ok := e.(*sqlColumnRef); ok {
+				// ORDER BY can use the names given with AS
+				for i, c := range q.columns {
+					if c.name == ref.name {
+						o.column = i
+						break
+					}
+				}
+			}
+			if p.acceptKeyword("DESC") {
+				o.desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			q.orderBy = append(q.orderBy, o)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if q.limit, err = p.parseCount(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("OFFSET") {
+			if q.offset, err = p.parseCount(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	q.aggregate = len(q.groupBy) > 0 || q.having != nil
+	for _, c := range q.columns {
+		q.aggregate = q.aggregate || hasAggregate(c.expr)
+	}
+	for _, o := range q.orderBy {
+		q.aggregate = q.aggregate || hasAggregate(o.expr)
+	}
+	if hasAggregate(q.where) {
+		return nil, errors.New("aggregates can't be used in WHERE, use HAVING")
+	}
+	return q, nil
+}
+
+// parseBucketPath reads the bucket after FROM: a/b/c (quoted or not), or "a"."b"."c"
+func (p *sqlParser) parseBucketPath() ([]string, error) {
+	var parts []string
+	for {
+		t := p.next()
+		if t.kind != sqlIdent && t.kind != sqlQuoted && t.kind != sqlString {
+			p.pos--
+			return nil, p.errorf("expected a bucket")
+		}
+		parts = append(parts, t.text)
+		if !p.acceptOp(".") {
+			break
+		}
+	}
+	if len(parts) == 1 {
+		return splitPathArg(strings.Trim(parts[0], "/")), nil
+	}
+	return parts, nil
+}
+
+func (p *sqlParser) parseCount() (int, error) {
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != sqlNum || err != nil || n < 0 {
+		p.pos--
+		return 0, p.errorf("expected a number")
+	}
+	return n, nil
+}
+
+func (p *sqlParser) parseExpr() (sqlExpr, error) {
+	return p.parseBinary(0)
+}
+
+// The binary operators, loosest first
+var sqlPrecedence = [][]string{
+	{"OR"},
+	{"AND"},
+	// NOT is between these two, see parseBinary
+	{"=", "==", "!=", "<>", "<", "<=", ">", ">=", "LIKE", "IS", "IN"},
+	{"+", "-", "||"},
+	{"*", "/", "%"},
+}
+
+func (p *sqlParser) parseBinary(level int) (sqlExpr, error) {
+	if level == len(sqlPrecedence) {
+		return p.parseUnary()
+	}
+	if level == 2 && p.acceptKeyword("NOT") {
+		x, err := p.parseBinary(level)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlUnary{"NOT", x}, nil
+	}
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, not := p.matchOp(sqlPrecedence[level])
+		if op == "" {
+			return l, nil
+		}
+		switch op {
+		case "IS":
+			isNot := p.acceptKeyword("NOT")
+			if err := p.expectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			l = &sqlIsNull{l, isNot}
+			continue
+		case "IN":
+			list, err := p.parseList()
+			if err != nil {
+				return nil, err
+			}
+			l = &sqlIn{l, list, not}
+			continue
+		case "==":
+			op = "="
+		case "<>":
+			op = "!="
+		}
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &sqlBinary{op, l, r}
+		if not {
+			l = &sqlUnary{"NOT", l}
+		}
+	}
+}
+
+// matchOp takes one of 'ops' if it's next, along with a NOT before LIKE or IN
+func (p *sqlParser) matchOp(ops []string) (string, bool) {
+	start := p.pos
+	not := p.acceptKeyword("NOT")
+	t := p.peek()
+	for _, op := range ops {
+		if (t.kind == sqlOp && t.text == op) || (t.kind == sqlIdent && strings.EqualFold(t.text, op) && unicode.IsLetter(rune(op[0]))) {
+			if not && op != "LIKE" && op != "IN" {
+				break
+			}
+			p.pos++
+			return op, not
+		}
+	}
+	p.pos = start
+	return "", false
+}
+
+func (p *sqlParser) parseList() ([]sqlExpr, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var ret []sqlExpr
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return ret, p.expectOp(")")
+}
+
+func (p *sqlParser) parseUnary() (sqlExpr, error) {
+	if p.acceptOp("-") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlUnary{"-", x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *sqlParser) parsePrimary() (sqlExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case sqlNum:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.pos--
+			return nil, p.errorf("bad number %q", t.text)
+		}
+		return &sqlLiteral{n}, nil
+	case sqlString:
+		return &sqlLiteral{t.text}, nil
+	case sqlQuoted:
+		return &sqlColumnRef{t.text}, nil
+	case sqlOp:
+		if t.text == "(" {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expectOp(")")
+		}
+	case sqlIdent:
+		lower := strings.ToLower(t.text)
+		switch lower {
+		case "null":
+			return &sqlLiteral{nil}, nil
+		case "true":
+			return &sqlLiteral{true}, nil
+		case "false":
+			return &sqlLiteral{false}, nil
+		}
+		if !p.acceptOp("(") {
+			if sqlReserved[lower] {
+				p.pos--
+				return nil, p.errorf("unexpected %s", t.text)
+			}
+			return &sqlColumnRef{t.text}, nil
+		}
+		return p.parseCall(lower)
+	}
+	p.pos--
+	if t.kind == sqlEOF {
+		return nil, p.errorf("unexpected end")
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// The functions that can be called, and how many arguments they take (-1 for any)
+var sqlFunctions = map[string]int{"json_extract": 2, "lower": 1, "upper": 1, "length": 1, "coalesce": -1,
+	"count": 1, "sum": 1, "avg": 1, "min": 1, "max": 1}
+
+func (p *sqlParser) parseCall(name string) (sqlExpr, error) {
+	nargs, ok := sqlFunctions[name]
+	if !ok {
+		p.pos -= 2
+		return nil, p.errorf("no such function: %s", name)
+	}
+	call := &sqlCall{name: name}
+	if name == "count" && p.acceptOp("*") {
+		call.star = true
+		return call, p.expectOp(")")
+	}
+	if !p.acceptOp(")") {
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if sqlAggregates[name] && hasAggregate(e) {
+				return nil, p.errorf("aggregates can't be nested")
+			}
+			call.args = append(call.args, e)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+	if nargs >= 0 && len(call.args) != nargs {
+		return nil, fmt.Errorf("%s takes %d arguments", name, nargs)
+	}
+	if nargs < 0 && len(call.args) == 0 {
+		return nil, fmt.Errorf("%s needs an argument", name)
+	}
+	return call, nil
+}
+
+// replaceAliases swaps the names given to columns with AS in 'e' for what they're the name of
+func replaceAliases(e sqlExpr, columns []sqlColumn) sqlExpr {
+	switch t := e.(type) {
+	case *sqlColumnRef:
+		for _, c := range columns {
+			if ref, ok := c.expr.(*sqlColumnRef); c.name == t.name && !(ok && ref.name == t.name) {
+				return c.expr
+			}
+		}
+	case *sqlCall:
+		for i := range t.args {
+			t.args[i] = replaceAliases(t.args[i], columns)
+		}
+	case *sqlUnary:
+		t.x = replaceAliases(t.x, columns)
+	case *sqlBinary:
+		t.l, t.r = replaceAliases(t.l, columns), replaceAliases(t.r, columns)
+	case *sqlIsNull:
+		t.x = replaceAliases(t.x, columns)
+	case *sqlIn:
+		t.x = replaceAliases(t.x, columns)
+		for i := range t.list {
+			t.list[i] = replaceAliases(t.list[i], columns)
+		}
+	}
+	return e
+}
+
+func hasAggregate(e sqlExpr) bool {
+	switch t := e.(type) {
+	case *sqlCall:
+		if sqlAggregates[t.name] {
+			return true
+		}
+		for _, a := range t.args {
+			if hasAggregate(a) {
+				return true
+			}
+		}
+	case *sqlUnary:
+		return hasAggregate(t.x)
+	case *sqlBinary:
+		return hasAggregate(t.l) || hasAggregate(t.r)
+	case *sqlIsNull:
+		return hasAggregate(t.x)
+	case *sqlIn:
+		if hasAggregate(t.x) {
+			return true
+		}
+		for _, a := range t.list {
+			if hasAggregate(a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sqlBucketName writes 'path' so that it comes back out of parseBucketPath the same
+func sqlBucketName(path []string) string {
+	var parts []string
+	for _, p := range path {
+		parts = append(parts, escapePathPart(p))
+	}
+	return `"` + strings.ReplaceAll(strings.Join(parts, "/"), `"`, `""`) + `"`
+}
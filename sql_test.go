@@ -0,0 +1,157 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// openSQLTestDB has users with json values (and one that isn't json), and their orders in a nested bucket
+func openSQLTestDB(t *testing.T) *bbolt.DB {
+	t.Helper()
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "sql.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bdb.Close() })
+	err = bdb.Update(func(tx *bbolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		users.Put([]byte("u1"), []byte(`{"name":"alice","age":30,"status":"active","address":{"city":"Oslo"}}`))
+		users.Put([]byte("u2"), []byte(`{"name":"bob","age":25,"status":"failed"}`))
+		users.Put([]byte("u3"), []byte(`{"name":"carol","age":35,"status":"active"}`))
+		users.Put([]byte("u4"), []byte(`not json`))
+		orders, err := users.CreateBucket([]byte("orders"))
+		if err != nil {
+			return err
+		}
+		orders.Put([]byte("o1"), []byte(`{"total":3}`))
+		orders.Put([]byte("o2"), []byte(`{"total":12.5}`))
+		return orders.Put([]byte("o3"), []byte(`{"total":7}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bdb
+}
+
+// runTestSQL runs 'src', the rows are separated by ';' and the columns by '|'
+func runTestSQL(bdb *bbolt.DB, src string) (string, error) {
+	q, err := parseSQL(src)
+	if err != nil {
+		return "", err
+	}
+	var rows []string
+	err = bdb.View(func(tx *bbolt.Tx) error {
+		return q.run(tx, func(out []interface{}, key []byte) error {
+			var cols []string
+			for _, v := range out {
+				cols = append(cols, sqlText(v))
+			}
+			rows = append(rows, strings.Join(cols, "|"))
+			return nil
+		})
+	})
+	return strings.Join(rows, ";"), err
+}
+
+func TestSQLQueries(t *testing.T) {
+	bdb := openSQLTestDB(t)
+	for _, tc := range []struct {
+		sql, want string
+	}{
+		// Nested buckets aren't rows
+		{`SELECT key FROM users`, "u1;u2;u3;u4"},
+		{`SELECT * FROM users WHERE key = 'u4'`, "u4|not json"},
+
+		// WHERE
+		{`SELECT key FROM users WHERE age > 26`, "u1;u3"},
+		{`SELECT key FROM users WHERE status = 'active' AND age < 32`, "u1"},
+		{`SELECT key FROM users WHERE status = 'failed' OR NOT age < 35`, "u2;u3"},
+		{`SELECT key FROM users WHERE name LIKE 'B%'`, "u2"},
+		{`SELECT key FROM users WHERE key IN ('u1', 'u4')`, "u1;u4"},
+		{`SELECT key FROM users WHERE key NOT IN ('u1', 'u4')`, "u2;u3"},
+		{`SELECT key FROM users WHERE age IS NULL`, "u4"},
+		{`SELECT key FROM users WHERE age + 5 = 30`, "u2"},
+
+		// Only decimal text is a number
+		{`SELECT key FROM users WHERE age = '3e1'`, "u1"},
+		{`SELECT key FROM users WHERE age = 'nan'`, ""},
+		{`SELECT key FROM users WHERE age < '0x1p6'`, ""},
+		{`SELECT key FROM users WHERE age + 'inf' IS NULL`, "u1;u2;u3;u4"},
+		{`SELECT key, 'Infinity' * 0 FROM users WHERE key = 'u1'`, "u1|NULL"},
+
+		// ORDER BY, NULLs first
+		{`SELECT name FROM users WHERE age IS NOT NULL ORDER BY age DESC`, "carol;alice;bob"},
+		{`SELECT key, age FROM users ORDER BY age`, "u4|NULL;u2|25;u1|30;u3|35"},
+		{`SELECT key, upper(name) AS n FROM users WHERE name IS NOT NULL ORDER BY n DESC`, "u3|CAROL;u2|BOB;u1|ALICE"},
+		{`SELECT key FROM users ORDER BY status, age DESC`, "u4;u3;u1;u2"},
+
+		// LIMIT and OFFSET
+		{`SELECT key FROM users LIMIT 2`, "u1;u2"},
+		{`SELECT key FROM users LIMIT 2 OFFSET 1`, "u2;u3"},
+		{`SELECT key FROM users ORDER BY key DESC LIMIT 1`, "u4"},
+		{`SELECT key FROM users LIMIT 0`, ""},
+
+		// Aggregates, GROUP BY and HAVING
+		{`SELECT count(*), count(age), sum(age), avg(age), min(age), max(age) FROM users`, "4|3|90|30|25|35"},
+		{`SELECT count(*) FROM users WHERE age > 100`, "0"},
+		{`SELECT status, count(*) FROM users GROUP BY status ORDER BY status`, "NULL|1;active|2;failed|1"},
+		{`SELECT status, count(*) AS n FROM users GROUP BY status HAVING n > 1`, "active|2"},
+		{`SELECT status, max(age) FROM users WHERE age IS NOT NULL GROUP BY status HAVING count(*) = 1`, "failed|25"},
+
+		// json_extract and the other functions
+		{`SELECT json_extract(value, '$.address.city') FROM users WHERE key = 'u1'`, "Oslo"},
+		{`SELECT key FROM users WHERE json_extract(value, '$.address.city') IS NULL`, "u2;u3;u4"},
+		{`SELECT coalesce(name, key), length(key) FROM users WHERE key >= 'u3'`, "carol|2;u4|2"},
+
+		// Names given with AS work in WHERE and GROUP BY
+		{`SELECT json_extract(value, '$.total') AS t FROM "users/orders" WHERE t > 5`, "12.5;7"},
+		{`SELECT json_extract(value, '$.status') AS s, count(*) AS n FROM users GROUP BY s ORDER BY n DESC LIMIT 1`, "active|2"},
+
+		// Nested buckets as tables
+		{`SELECT key FROM "users"."orders"`, "o1;o2;o3"},
+		{`SELECT key FROM 'users/orders' WHERE total < 10;`, "o1;o3"},
+		{`SELECT sum(total) FROM "users/orders"`, "22.5"},
+	} {
+		got, err := runTestSQL(bdb, tc.sql)
+		if err != nil {
+			t.Errorf("%s: %v", tc.sql, err)
+		} else if got != tc.want {
+			t.Errorf("%s\n got: %s\nwant: %s", tc.sql, got, tc.want)
+		}
+	}
+}
+
+func TestSQLErrors(t *testing.T) {
+	bdb := openSQLTestDB(t)
+	for _, tc := range []struct {
+		sql, want string
+	}{
+		{``, "SELECT"},
+		{`UPDATE users SET x = 1`, "SELECT"},
+		{`SELECT key`, "FROM"},
+		{`SELECT key FROM`, "expected a bucket"},
+		{`SELECT key FROM users WHERE`, "unexpected end"},
+		{`SELECT key FROM users extra`, "unexpected"},
+		{`SELECT nope(key) FROM users`, "no such function: nope"},
+		{`SELECT json_extract(value) FROM users`, "json_extract takes 2 arguments"},
+		{`SELECT sum(count(*)) FROM users`, "aggregates can't be nested"},
+		{`SELECT key FROM users WHERE count(*) > 1`, "use HAVING"},
+		{`SELECT count(*) AS n FROM users WHERE n > 1`, "use HAVING"},
+		{`SELECT key FROM users LIMIT ten`, "expected a number"},
+		{`SELECT key FROM users WHERE name = 'alice`, ""},
+		{`SELECT key FROM "users/nope"`, "no bucket"},
+	} {
+		_, err := runTestSQL(bdb, tc.sql)
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.sql)
+		} else if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: the error %q doesn't say %q", tc.sql, err.Error(), tc.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// How many commands are kept in the command line history
+const maxCommandHistory = 500
+
+/*
+stateDir is where boltbrowser keeps things between runs that aren't config,
+like the command line history. It follows XDG_STATE_HOME where there is one.
+*/
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, ProgramName), nil
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, ProgramName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", ProgramName), nil
+}
+
+func commandHistoryFilename() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "command_history"), nil
+}
+
+// loadCommandHistory reads the saved command line history, oldest first
+func loadCommandHistory() []string {
+	fn, err := commandHistoryFilename()
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var ret []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
+
+// saveCommandHistory writes the newest maxCommandHistory commands
+func saveCommandHistory(history []string) error {
+	fn, err := commandHistoryFilename()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return err
+	}
+	if len(history) > maxCommandHistory {
+		history = history[len(history)-maxCommandHistory:]
+	}
+	return os.WriteFile(fn, []byte(strings.Join(history, "\n")+"\n"), 0600)
+}
+
+/*
+dbState is what's remembered about one DB file between runs,
+it's kept in its own file in the state directory
+*/
+type dbState struct {
+	// The absolute path of the DB file this is for
+	Path string `json:"path"`
+	// Named marks, from 'm'
+	Marks map[string]statePath `json:"marks,omitempty"`
+	// Where the cursor was, which buckets were open and the filter
+	Cursor   statePath   `json:"cursor,omitempty"`
+	Expanded []statePath `json:"expanded,omitempty"`
+	Filter   string      `json:"filter,omitempty"`
+	// The per bucket settings from ':set decoder' and ':sort'
+	Decoders  []pathSetting `json:"decoders,omitempty"`
+	SortModes []pathSetting `json:"sort_modes,omitempty"`
+
+	filename string
+}
+
+// pathSetting is a setting for the bucket at Path
+type pathSetting struct {
+	Path  statePath `json:"path"`
+	Value string    `json:"value"`
+}
+
+/*
+statePath is a path in the DB as it's saved in a state file. Bolt keys
+don't have to be text, so the parts of the path that aren't valid UTF-8
+are saved as {"base64": "..."} instead of as a string.
+*/
+type statePath []string
+
+func (p statePath) MarshalJSON() ([]byte, error) {
+	parts := make([]interface{}, len(p))
+	for i, part := range p {
+		if utf8.ValidString(part) {
+			parts[i] = part
+		} else {
+			parts[i] = map[string][]byte{"base64": []byte(part)}
+		}
+	}
+	return json.Marshal(parts)
+}
+
+func (p *statePath) UnmarshalJSON(data []byte) error {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	*p = make(statePath, len(parts))
+	for i, raw := range parts {
+		var str string
+		if err := json.Unmarshal(raw, &str); err == nil {
+			(*p)[i] = str
+			continue
+		}
+		var bin map[string][]byte
+		if err := json.Unmarshal(raw, &bin); err != nil {
+			return err
+		}
+		(*p)[i] = string(bin["base64"])
+	}
+	return nil
+}
+
+/*
+loadDBState reads the state for the DB file 'dbFile'. A DB that hasn't been
+seen before (or one whose state can't be read) gets an empty state.
+*/
+func loadDBState(dbFile string) *dbState {
+	state := new(dbState)
+	abs, err := filepath.Abs(dbFile)
+	if err != nil {
+		abs = dbFile
+	}
+	state.Path = abs
+	dir, err := stateDir()
+	if err != nil {
+		return state
+	}
+	// The DB's path is hashed so it's safe to use as a file name
+	sum := sha1.Sum([]byte(abs))
+	state.filename = filepath.Join(dir, "db", hex.EncodeToString(sum[:])+".json")
+	if data, err := os.ReadFile(state.filename); err == nil {
+		json.Unmarshal(data, state)
+		state.Path = abs
+	}
+	return state
+}
+
+func (s *dbState) save() error {
+	if s.filename == "" {
+		return errors.New("there's no state directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(s.filename), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.filename, data, 0600)
+}
+
+/*
+restoreState puts the browser back how it was the last time this DB was open:
+open buckets, filter, decoders, sorting and the cursor
+*/
+func (screen *BrowserScreen) restoreState() {
+	if screen.db == nil {
+		return
+	}
+	state := screen.dbState()
+	for _, d := range state.Decoders {
+		screen.setDecoder(d.Path, d.Value)
+	}
+	for _, m := range state.SortModes {
+		if _, ok := findSortMode(m.Value); ok {
+			if screen.sortModes == nil {
+				screen.sortModes = make(map[string]string)
+			}
+			screen.sortModes[pathKey(m.Path)] = m.Value
+		}
+	}
+	screen.applySortModes()
+	var expanded [][]string
+	for _, p := range state.Expanded {
+		expanded = append(expanded, p)
+	}
+	screen.db.openPaths(expanded)
+	screen.filter = state.Filter
+	if len(state.Cursor) > 0 && screen.db.isVisiblePath(state.Cursor, screen.filter) {
+		screen.currentPath = append([]string{}, state.Cursor...)
+	}
+}
+
+// saveState remembers how the browser is for the next time this DB is opened
+func (screen *BrowserScreen) saveState() error {
+	if screen.db == nil {
+		return nil
+	}
+	state := screen.dbState()
+	state.Cursor = append(statePath{}, screen.currentPath...)
+	state.Expanded = nil
+	for _, p := range screen.db.expandedPaths() {
+		state.Expanded = append(state.Expanded, p)
+	}
+	state.Filter = screen.filter
+	state.Decoders = pathSettings(screen.decoders)
+	state.SortModes = pathSettings(screen.sortModes)
+	return state.save()
+}
+
+// pathSettings turns settings kept by pathKey into a list, in a stable order
+func pathSettings(settings map[string]string) []pathSetting {
+	var keys []string
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var ret []pathSetting
+	for _, k := range keys {
+		ret = append(ret, pathSetting{keyPath(k), settings[k]})
+	}
+	return ret
+}
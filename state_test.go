@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/br0xen/boltbrowser/model"
+)
+
+func TestStatePathSettings(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	// A big-endian id as a bucket name, and a bucket whose name is the same bytes joined up
+	nested := []string{"orders", "\x00\x00\x00\x2a"}
+	joined := []string{"orders\x00\x00\x00\x00\x2a"}
+
+	screen := new(BrowserScreen)
+	screen.setDecoder(nested, "hex")
+	screen.setDecoder(joined, "json")
+	if len(screen.decoders) != 2 {
+		t.Fatalf("expected 2 decoders, got %q", screen.decoders)
+	}
+
+	fn := filepath.Join(t.TempDir(), "test.db")
+	state := loadDBState(fn)
+	state.Decoders = pathSettings(screen.decoders)
+	if err := state.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := loadDBState(fn)
+	if !reflect.DeepEqual(loaded.Decoders, state.Decoders) {
+		t.Errorf("decoders came back as %q, expected %q", loaded.Decoders, state.Decoders)
+	}
+
+	restored := new(BrowserScreen)
+	for _, d := range loaded.Decoders {
+		restored.setDecoder(d.Path, d.Value)
+	}
+	for _, tc := range []struct {
+		path []string
+		want string
+	}{
+		{nested, "hex"},
+		{append(nested, "items"), "hex"},
+		{joined, "json"},
+		{[]string{"orders"}, model.DefaultDecoder},
+	} {
+		if got := restored.decoderFor(tc.path); got != tc.want {
+			t.Errorf("decoder for %q is %s, expected %s", tc.path, got, tc.want)
+		}
+	}
+}
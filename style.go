@@ -1,6 +1,13 @@
 package main
 
-import "github.com/nsf/termbox-go"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
 
 /*
 Style Defines the colors for the terminal display, basically
@@ -10,8 +17,17 @@ type Style struct {
 	defaultFg termbox.Attribute
 	titleFg   termbox.Attribute
 	titleBg   termbox.Attribute
+	footerFg  termbox.Attribute
+	footerBg  termbox.Attribute
 	cursorFg  termbox.Attribute
 	cursorBg  termbox.Attribute
+	bucketFg  termbox.Attribute
+	pairFg    termbox.Attribute
+	treeFg    termbox.Attribute
+	modalFg   termbox.Attribute
+	modalBg   termbox.Attribute
+	errorFg   termbox.Attribute
+	errorBg   termbox.Attribute
 }
 
 func defaultStyle() Style {
@@ -20,8 +36,274 @@ func defaultStyle() Style {
 	style.defaultFg = termbox.ColorWhite
 	style.titleFg = termbox.ColorBlack
 	style.titleBg = termbox.ColorGreen
+	style.footerFg = termbox.ColorWhite
+	style.footerBg = termbox.ColorBlack
 	style.cursorFg = termbox.ColorBlack
 	style.cursorBg = termbox.ColorGreen
+	style.bucketFg = termbox.ColorWhite
+	style.pairFg = termbox.ColorWhite
+	style.treeFg = termbox.ColorWhite
+	style.modalFg = termbox.ColorWhite
+	style.modalBg = termbox.ColorBlack
+	style.errorFg = termbox.ColorRed
+	style.errorBg = termbox.ColorBlack
 
 	return style
 }
+
+/*
+monochromeStyle is used when the NO_COLOR environment variable is set,
+it only uses the terminal's default colors and attributes
+*/
+func monochromeStyle() Style {
+	var style Style
+	style.titleFg = termbox.AttrReverse
+	style.cursorFg = termbox.AttrReverse
+	style.errorFg = termbox.AttrBold
+	style.bucketFg = termbox.AttrBold
+	return style
+}
+
+/*
+ThemeColors is a theme as it's written in the config file.
+Each entry is "fg" or "fg/bg", where a color is a name ("green", "default"),
+a 256 color index ("208") or a truecolor value ("#ff8700"). Colors can be
+followed by "bold", "underline" or "reverse".
+Anything left out is taken from the "dark" theme.
+*/
+type ThemeColors map[string]string
+
+// The themes that come with boltbrowser
+var builtinThemes = map[string]ThemeColors{
+	"dark": {
+		"default": "white/black",
+		"title":   "black/green",
+		"footer":  "white/black",
+		"cursor":  "black/green",
+		"bucket":  "white",
+		"pair":    "white",
+		"tree":    "white",
+		"modal":   "white/black",
+		"error":   "red/black",
+	},
+	"light": {
+		"default": "235/255",
+		"title":   "255/25",
+		"footer":  "235/255",
+		"cursor":  "255/25",
+		"bucket":  "bold 18",
+		"pair":    "235",
+		"tree":    "245",
+		"modal":   "235/253",
+		"error":   "bold 160/255",
+	},
+	"solarized": {
+		"default": "#839496/#002b36",
+		"title":   "#002b36/#268bd2",
+		"footer":  "#93a1a1/#073642",
+		"cursor":  "#002b36/#b58900",
+		"bucket":  "#268bd2",
+		"pair":    "#839496",
+		"tree":    "#586e75",
+		"modal":   "#93a1a1/#073642",
+		"error":   "#dc322f/#002b36",
+	},
+	"high-contrast": {
+		"default": "lightgray/black",
+		"title":   "bold black/lightyellow",
+		"footer":  "bold lightgray/black",
+		"cursor":  "bold black/lightyellow",
+		"bucket":  "bold lightcyan",
+		"pair":    "lightgray",
+		"tree":    "lightgray",
+		"modal":   "bold lightgray/blue",
+		"error":   "bold lightgray/red",
+	},
+}
+
+// Names accepted for the 16 basic colors, they're the same as termbox's
+var colorNames = []string{
+	"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+	"darkgray", "lightred", "lightgreen", "lightyellow", "lightblue", "lightmagenta", "lightcyan", "lightgray",
+}
+
+// The rgb values of the basic 16 colors, as xterm has them
+var basicColorRGB = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0}, {0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0}, {92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+/*
+themeColor is a parsed color, it knows how to turn itself into
+a termbox attribute for whichever output mode we end up in
+*/
+type themeColor struct {
+	isDefault bool
+	isRGB     bool
+	index     int
+	rgb       [3]uint8
+	attrs     termbox.Attribute
+}
+
+/*
+loadStyle builds the style for the theme chosen on the command line or in
+the config, and picks the termbox output mode the theme needs
+*/
+func loadStyle() (Style, termbox.OutputMode, error) {
+	if os.Getenv("NO_COLOR") != "" {
+		return monochromeStyle(), termbox.OutputNormal, nil
+	}
+	name := AppConfig.Theme
+	if AppArgs.Theme != "" {
+		name = AppArgs.Theme
+	}
+	if name == "" {
+		return defaultStyle(), termbox.Output256, nil
+	}
+	theme, ok := AppConfig.Themes[name]
+	if !ok {
+		if theme, ok = builtinThemes[name]; !ok {
+			return Style{}, termbox.Output256, fmt.Errorf("unknown theme %q", name)
+		}
+	}
+	colors := make(map[string]themeColor)
+	useRGB := false
+	for _, src := range []ThemeColors{builtinThemes["dark"], theme} {
+		for element, spec := range src {
+			fgSpec, bgSpec := spec, "default"
+			if pts := strings.SplitN(spec, "/", 2); len(pts) == 2 {
+				fgSpec, bgSpec = pts[0], pts[1]
+			}
+			fg, err := parseThemeColor(fgSpec)
+			if err != nil {
+				return Style{}, termbox.Output256, fmt.Errorf("theme %s, %s: %w", name, element, err)
+			}
+			bg, err := parseThemeColor(bgSpec)
+			if err != nil {
+				return Style{}, termbox.Output256, fmt.Errorf("theme %s, %s: %w", name, element, err)
+			}
+			colors[element+"Fg"], colors[element+"Bg"] = fg, bg
+			useRGB = useRGB || fg.isRGB || bg.isRGB
+		}
+	}
+	mode := termbox.Output256
+	if useRGB && terminalHasTruecolor() {
+		mode = termbox.OutputRGB
+	}
+	attr := func(nm string) termbox.Attribute {
+		return colors[nm].attribute(mode)
+	}
+	var style Style
+	style.defaultFg, style.defaultBg = attr("defaultFg"), attr("defaultBg")
+	style.titleFg, style.titleBg = attr("titleFg"), attr("titleBg")
+	style.footerFg, style.footerBg = attr("footerFg"), attr("footerBg")
+	style.cursorFg, style.cursorBg = attr("cursorFg"), attr("cursorBg")
+	style.bucketFg = attr("bucketFg")
+	style.pairFg = attr("pairFg")
+	style.treeFg = attr("treeFg")
+	style.modalFg, style.modalBg = attr("modalFg"), attr("modalBg")
+	style.errorFg, style.errorBg = attr("errorFg"), attr("errorBg")
+	if !colors["bucketBg"].isDefault || !colors["pairBg"].isDefault || !colors["treeBg"].isDefault {
+		return style, mode, fmt.Errorf("theme %s: bucket, pair and tree only take a foreground color", name)
+	}
+	return style, mode, nil
+}
+
+// terminalHasTruecolor checks the usual way terminals advertise 24 bit color
+func terminalHasTruecolor() bool {
+	ct := strings.ToLower(os.Getenv("COLORTERM"))
+	return ct == "truecolor" || ct == "24bit"
+}
+
+func parseThemeColor(spec string) (themeColor, error) {
+	c := themeColor{isDefault: true}
+	for _, word := range strings.Fields(strings.ToLower(spec)) {
+		switch word {
+		case "bold":
+			c.attrs |= termbox.AttrBold
+			continue
+		case "underline":
+			c.attrs |= termbox.AttrUnderline
+			continue
+		case "reverse":
+			c.attrs |= termbox.AttrReverse
+			continue
+		case "default":
+			continue
+		}
+		c.isDefault = false
+		if strings.HasPrefix(word, "#") && len(word) == 7 {
+			v, err := strconv.ParseUint(word[1:], 16, 32)
+			if err != nil {
+				return c, fmt.Errorf("invalid color %q", word)
+			}
+			c.isRGB = true
+			c.rgb = [3]uint8{uint8(v >> 16), uint8(v >> 8), uint8(v)}
+			continue
+		}
+		if idx, err := strconv.Atoi(word); err == nil && idx >= 0 && idx < 256 {
+			c.index = idx
+			c.rgb = xtermRGB(idx)
+			continue
+		}
+		found := false
+		for idx, nm := range colorNames {
+			if nm == word {
+				c.index = idx
+				c.rgb = xtermRGB(idx)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return c, fmt.Errorf("invalid color %q", word)
+		}
+	}
+	return c, nil
+}
+
+func (c themeColor) attribute(mode termbox.OutputMode) termbox.Attribute {
+	if c.isDefault {
+		return termbox.ColorDefault | c.attrs
+	}
+	if mode == termbox.OutputRGB {
+		return termbox.RGBToAttribute(c.rgb[0], c.rgb[1], c.rgb[2]) | c.attrs
+	}
+	idx := c.index
+	if c.isRGB {
+		idx = nearestXtermColor(c.rgb)
+	}
+	// termbox's 256 color attributes are offset by one, 0 being the default
+	return termbox.Attribute(idx+1) | c.attrs
+}
+
+// xtermRGB gives the rgb value of one of the 256 xterm colors
+func xtermRGB(idx int) [3]uint8 {
+	if idx < 16 {
+		return basicColorRGB[idx]
+	}
+	if idx >= 232 {
+		v := uint8(8 + (idx-232)*10)
+		return [3]uint8{v, v, v}
+	}
+	levels := [6]uint8{0, 95, 135, 175, 215, 255}
+	idx -= 16
+	return [3]uint8{levels[idx/36], levels[(idx/6)%6], levels[idx%6]}
+}
+
+// nearestXtermColor finds the closest color to 'rgb' in the 256 color cube and gray ramp
+func nearestXtermColor(rgb [3]uint8) int {
+	best, bestDist := 16, -1
+	for idx := 16; idx < 256; idx++ {
+		c := xtermRGB(idx)
+		dist := 0
+		for i := range c {
+			d := int(c[i]) - int(rgb[i])
+			dist += d * d
+		}
+		if bestDist == -1 || dist < bestDist {
+			best, bestDist = idx, dist
+		}
+	}
+	return best
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+/*
+wrapText breaks 'text' into lines that are at most 'width' cells wide,
+counting wide (CJK, emoji) runes as two cells. Lines are broken after
+a space when there's one on the line, otherwise in the middle of a word.
+*/
+func wrapText(text string, width int) []string {
+	if width < 2 || runewidth.StringWidth(text) <= width {
+		return []string{text}
+	}
+	var ret []string
+	runes := []rune(text)
+	start, lineW, lastSpace := 0, 0, -1
+	for i := 0; i < len(runes); i++ {
+		rw := runewidth.RuneWidth(runes[i])
+		if lineW+rw > width && i > start {
+			end := i
+			if lastSpace > start {
+				end = lastSpace + 1
+			}
+			ret = append(ret, string(runes[start:end]))
+			start, lastSpace = end, -1
+			lineW = runewidth.StringWidth(string(runes[start:i]))
+		}
+		if runes[i] == ' ' {
+			lastSpace = i
+		}
+		lineW += rw
+	}
+	return append(ret, string(runes[start:]))
+}
+
+// wrapLines soft-wraps every line in 'lines', keeping their colors
+func wrapLines(lines []Line, width int) []Line {
+	var ret []Line
+	for _, l := range lines {
+		for _, t := range wrapText(l.Text, width) {
+			ret = append(ret, Line{t, l.Fg, l.Bg})
+		}
+	}
+	return ret
+}
+
+/*
+drawStringClipped draws 'str' at x,y leaving out the first 'skip' cells
+and anything past 'width' cells. Wide runes take two cells, one that's
+cut in half by either edge is drawn as a space.
+Returns the number of cells drawn.
+*/
+func drawStringClipped(str string, x, y, skip, width int, fg, bg termbox.Attribute) int {
+	col, drawn := 0, 0
+	for _, r := range str {
+		rw := runewidth.RuneWidth(r)
+		if drawn >= width {
+			break
+		} else if rw == 0 || col+rw <= skip {
+			col += rw
+			continue
+		}
+		if col < skip || drawn+rw > width {
+			// Only part of this rune is on the screen
+			visible := rw
+			if col < skip {
+				visible = col + rw - skip
+			}
+			for i := 0; i < visible && drawn < width; i++ {
+				screenDisplay.SetCell(x+drawn, y, ' ', fg, bg)
+				drawn++
+			}
+		} else {
+			screenDisplay.SetCell(x+drawn, y, r, fg, bg)
+			drawn += rw
+		}
+		col += rw
+	}
+	return drawn
+}
+
+// truncateLeft cuts the start off of 'str' so it fits in 'width' cells
+func truncateLeft(str string, width int) string {
+	if runewidth.StringWidth(str) <= width {
+		return str
+	}
+	if width < 1 {
+		return ""
+	}
+	runes := []rune(str)
+	w := 1 // The ellipsis
+	i := len(runes)
+	for i > 0 && w+runewidth.RuneWidth(runes[i-1]) <= width {
+		i--
+		w += runewidth.RuneWidth(runes[i])
+	}
+	return "…" + string(runes[i:])
+}